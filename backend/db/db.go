@@ -11,15 +11,20 @@ import (
 
 var DB *gorm.DB
 
-// ConnectDB opens a connection to PostgreSQL using GORM and sets the global DB variable
-func ConnectDB() (*gorm.DB, error) {
+// DSN builds the PostgreSQL connection string from the DB_* environment variables
+func DSN() string {
     host := os.Getenv("DB_HOST")
     port := os.Getenv("DB_PORT")
     user := os.Getenv("DB_USER")
     password := os.Getenv("DB_PASSWORD")
     dbname := os.Getenv("DB_NAME")
 
-    dsn := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=disable", host, port, user, password, dbname)
+    return fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=disable", host, port, user, password, dbname)
+}
+
+// ConnectDB opens a connection to PostgreSQL using GORM and sets the global DB variable
+func ConnectDB() (*gorm.DB, error) {
+    dsn := DSN()
 
     var db *gorm.DB
     var err error
@@ -41,44 +46,3 @@ func ConnectDB() (*gorm.DB, error) {
 
     return nil, fmt.Errorf("could not connect to database after 10 attempts: %w", err)
 }
-
-// Migrate the schema
-func Migrate() error {
-    schema := `
-    CREATE EXTENSION IF NOT EXISTS "pgcrypto";
-
-    CREATE TABLE IF NOT EXISTS users (
-        id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
-        username TEXT UNIQUE NOT NULL,
-        password TEXT NOT NULL,
-        email TEXT UNIQUE NOT NULL,
-        created_at TIMESTAMP DEFAULT NOW()
-    );
-
-    CREATE TABLE IF NOT EXISTS sessions (
-        id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
-        user_id UUID REFERENCES users(id) ON DELETE CASCADE,
-        token TEXT UNIQUE NOT NULL,
-        expires_at TIMESTAMP NOT NULL,
-        created_at TIMESTAMP DEFAULT NOW()
-    );
-
-    CREATE TABLE IF NOT EXISTS messages (
-        id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
-        sender_id UUID REFERENCES users(id) ON DELETE SET NULL,
-        content TEXT,
-        media_url TEXT,
-        created_at TIMESTAMP DEFAULT NOW()
-    );
-
-    CREATE TABLE IF NOT EXISTS message_recipients (
-        id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
-        message_id UUID REFERENCES messages(id) ON DELETE CASCADE,
-        recipient_id UUID REFERENCES users(id) ON DELETE CASCADE,
-        is_read BOOLEAN DEFAULT FALSE
-    );
-    `
-
-    return DB.Exec(schema).Error
-}
-
@@ -0,0 +1,237 @@
+package db
+
+import (
+	"embed"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// migrationLockID is the advisory lock key taken while applying or rolling back
+// a migration, so several backend instances booting at once do not race.
+const migrationLockID = 727274001
+
+// Migration is a single numbered schema change loaded from db/migrations.
+// Files are named NNNN_description.up.sql and NNNN_description.down.sql.
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// MigrationStatus reports whether a migration has been applied to the database
+type MigrationStatus struct {
+	Migration
+	Applied   bool
+	AppliedAt *time.Time
+}
+
+type schemaMigration struct {
+	Version   int `gorm:"primaryKey"`
+	Name      string
+	AppliedAt time.Time
+}
+
+func (schemaMigration) TableName() string {
+	return "schema_migrations"
+}
+
+// LoadMigrations reads the embedded migration files sorted by version
+func LoadMigrations() ([]Migration, error) {
+	entries, err := fs.ReadDir(migrationFiles, "migrations")
+	if err != nil {
+		return nil, err
+	}
+	byVersion := make(map[int]*Migration)
+	for _, entry := range entries {
+		fileName := entry.Name()
+		var direction string
+		switch {
+		case strings.HasSuffix(fileName, ".up.sql"):
+			direction = "up"
+		case strings.HasSuffix(fileName, ".down.sql"):
+			direction = "down"
+		default:
+			return nil, fmt.Errorf("unexpected migration file %q", fileName)
+		}
+		base := strings.TrimSuffix(fileName, "."+direction+".sql")
+		versionStr, name, ok := strings.Cut(base, "_")
+		if !ok {
+			return nil, fmt.Errorf("migration file %q must be named NNNN_name.%s.sql", fileName, direction)
+		}
+		version, err := strconv.Atoi(versionStr)
+		if err != nil {
+			return nil, fmt.Errorf("migration file %q has an invalid version: %w", fileName, err)
+		}
+		content, err := fs.ReadFile(migrationFiles, path.Join("migrations", fileName))
+		if err != nil {
+			return nil, err
+		}
+		m, exists := byVersion[version]
+		if !exists {
+			m = &Migration{Version: version, Name: name}
+			byVersion[version] = m
+		} else if m.Name != name {
+			return nil, fmt.Errorf("migration %d has mismatched names %q and %q", version, m.Name, name)
+		}
+		if direction == "up" {
+			m.Up = string(content)
+		} else {
+			m.Down = string(content)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" {
+			return nil, fmt.Errorf("migration %d (%s) has no up file", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	return migrations, nil
+}
+
+func ensureMigrationsTable() error {
+	return DB.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+        version BIGINT PRIMARY KEY,
+        name TEXT NOT NULL,
+        applied_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+    )`).Error
+}
+
+func appliedMigrations(tx *gorm.DB) (map[int]schemaMigration, error) {
+	var rows []schemaMigration
+	if err := tx.Order("version asc").Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	applied := make(map[int]schemaMigration, len(rows))
+	for _, row := range rows {
+		applied[row.Version] = row
+	}
+	return applied, nil
+}
+
+// MigrateUp applies every pending migration in order and returns the ones it applied
+func MigrateUp() ([]Migration, error) {
+	migrations, err := LoadMigrations()
+	if err != nil {
+		return nil, err
+	}
+	if err := ensureMigrationsTable(); err != nil {
+		return nil, err
+	}
+
+	var done []Migration
+	for _, m := range migrations {
+		applied := false
+		err := DB.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Exec("SELECT pg_advisory_xact_lock(?)", migrationLockID).Error; err != nil {
+				return err
+			}
+			// Re-check under the lock: another instance may have applied it meanwhile
+			var count int64
+			if err := tx.Model(&schemaMigration{}).Where("version = ?", m.Version).Count(&count).Error; err != nil {
+				return err
+			}
+			if count > 0 {
+				return nil
+			}
+			if err := tx.Exec(m.Up).Error; err != nil {
+				return err
+			}
+			applied = true
+			return tx.Create(&schemaMigration{Version: m.Version, Name: m.Name, AppliedAt: time.Now()}).Error
+		})
+		if err != nil {
+			return done, fmt.Errorf("migration %04d_%s failed: %w", m.Version, m.Name, err)
+		}
+		if applied {
+			done = append(done, m)
+		}
+	}
+	return done, nil
+}
+
+// MigrateDown rolls back the given number of most recently applied migrations
+func MigrateDown(steps int) ([]Migration, error) {
+	migrations, err := LoadMigrations()
+	if err != nil {
+		return nil, err
+	}
+	if err := ensureMigrationsTable(); err != nil {
+		return nil, err
+	}
+
+	var done []Migration
+	for i := len(migrations) - 1; i >= 0 && len(done) < steps; i-- {
+		m := migrations[i]
+		rolledBack := false
+		err := DB.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Exec("SELECT pg_advisory_xact_lock(?)", migrationLockID).Error; err != nil {
+				return err
+			}
+			var count int64
+			if err := tx.Model(&schemaMigration{}).Where("version = ?", m.Version).Count(&count).Error; err != nil {
+				return err
+			}
+			if count == 0 {
+				return nil
+			}
+			if m.Down == "" {
+				return fmt.Errorf("migration has no down file")
+			}
+			if err := tx.Exec(m.Down).Error; err != nil {
+				return err
+			}
+			rolledBack = true
+			return tx.Where("version = ?", m.Version).Delete(&schemaMigration{}).Error
+		})
+		if err != nil {
+			return done, fmt.Errorf("rollback of %04d_%s failed: %w", m.Version, m.Name, err)
+		}
+		if rolledBack {
+			done = append(done, m)
+		}
+	}
+	return done, nil
+}
+
+// MigrationStatuses lists every known migration and whether it has been applied
+func MigrationStatuses() ([]MigrationStatus, error) {
+	migrations, err := LoadMigrations()
+	if err != nil {
+		return nil, err
+	}
+	if err := ensureMigrationsTable(); err != nil {
+		return nil, err
+	}
+	applied, err := appliedMigrations(DB)
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]MigrationStatus, 0, len(migrations))
+	for _, m := range migrations {
+		status := MigrationStatus{Migration: m}
+		if row, ok := applied[m.Version]; ok {
+			appliedAt := row.AppliedAt
+			status.Applied = true
+			status.AppliedAt = &appliedAt
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
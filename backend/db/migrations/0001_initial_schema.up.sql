@@ -0,0 +1,41 @@
+-- Baseline schema. Uses IF NOT EXISTS so databases previously created by
+-- GORM AutoMigrate are adopted without losing data.
+CREATE EXTENSION IF NOT EXISTS "pgcrypto";
+
+CREATE TABLE IF NOT EXISTS users (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    username TEXT NOT NULL UNIQUE,
+    password TEXT NOT NULL,
+    email TEXT UNIQUE,
+    is_online BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS messages (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    sender_id UUID,
+    recipient_id UUID,
+    content TEXT,
+    media_url TEXT,
+    is_broadcast BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS message_recipients (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    message_id UUID,
+    recipient_id UUID,
+    delivered_at TIMESTAMPTZ,
+    read_at TIMESTAMPTZ
+);
+
+CREATE TABLE IF NOT EXISTS sessions (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id UUID,
+    token TEXT,
+    expires_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_message_recipients_message_recipient
+    ON message_recipients (message_id, recipient_id);
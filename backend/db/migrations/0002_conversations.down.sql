@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS idx_messages_conversation;
+ALTER TABLE messages DROP COLUMN IF EXISTS conversation_id;
+DROP TABLE IF EXISTS conversation_members;
+DROP TABLE IF EXISTS conversations;
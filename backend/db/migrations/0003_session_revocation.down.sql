@@ -0,0 +1,6 @@
+DROP INDEX IF EXISTS idx_sessions_user;
+ALTER TABLE sessions DROP COLUMN IF EXISTS revoked_at;
+ALTER TABLE sessions DROP COLUMN IF EXISTS last_used_at;
+ALTER TABLE sessions DROP COLUMN IF EXISTS ip_address;
+ALTER TABLE sessions DROP COLUMN IF EXISTS user_agent;
+ALTER TABLE sessions ADD COLUMN token TEXT;
@@ -0,0 +1,8 @@
+-- Sessions are keyed by the JWT jti; the raw token is no longer stored.
+ALTER TABLE sessions DROP COLUMN IF EXISTS token;
+ALTER TABLE sessions ADD COLUMN user_agent TEXT NOT NULL DEFAULT '';
+ALTER TABLE sessions ADD COLUMN ip_address TEXT NOT NULL DEFAULT '';
+ALTER TABLE sessions ADD COLUMN last_used_at TIMESTAMPTZ;
+ALTER TABLE sessions ADD COLUMN revoked_at TIMESTAMPTZ;
+
+CREATE INDEX idx_sessions_user ON sessions (user_id, created_at DESC);
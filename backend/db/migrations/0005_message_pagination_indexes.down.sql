@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS idx_messages_conversation_created;
+CREATE INDEX IF NOT EXISTS idx_messages_conversation ON messages (conversation_id, created_at);
+DROP INDEX IF EXISTS idx_messages_sender_created;
+DROP INDEX IF EXISTS idx_messages_recipient_created;
+DROP INDEX IF EXISTS idx_messages_sender_recipient_created;
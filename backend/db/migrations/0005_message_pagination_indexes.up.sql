@@ -0,0 +1,11 @@
+-- Composite indexes backing keyset pagination on (created_at, id)
+CREATE INDEX IF NOT EXISTS idx_messages_sender_recipient_created
+    ON messages (sender_id, recipient_id, created_at, id);
+CREATE INDEX IF NOT EXISTS idx_messages_recipient_created
+    ON messages (recipient_id, created_at, id);
+CREATE INDEX IF NOT EXISTS idx_messages_sender_created
+    ON messages (sender_id, created_at, id);
+
+DROP INDEX IF EXISTS idx_messages_conversation;
+CREATE INDEX IF NOT EXISTS idx_messages_conversation_created
+    ON messages (conversation_id, created_at, id);
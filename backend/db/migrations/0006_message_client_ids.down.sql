@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_messages_sender_client_message;
+ALTER TABLE messages DROP COLUMN IF EXISTS client_message_id;
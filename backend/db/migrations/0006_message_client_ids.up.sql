@@ -0,0 +1,4 @@
+ALTER TABLE messages ADD COLUMN client_message_id TEXT;
+CREATE UNIQUE INDEX idx_messages_sender_client_message
+    ON messages (sender_id, client_message_id)
+    WHERE client_message_id IS NOT NULL;
@@ -0,0 +1,8 @@
+-- Hub events too large for a NOTIFY payload; see websocket.PostgresBroker
+CREATE TABLE hub_events (
+    id BIGSERIAL PRIMARY KEY,
+    payload TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_hub_events_created ON hub_events (created_at);
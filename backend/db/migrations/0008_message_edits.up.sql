@@ -0,0 +1,11 @@
+ALTER TABLE messages ADD COLUMN edited_at TIMESTAMPTZ;
+
+-- One row per edit, holding the content the edit replaced
+CREATE TABLE message_edits (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    message_id UUID NOT NULL REFERENCES messages(id) ON DELETE CASCADE,
+    previous_content TEXT NOT NULL,
+    edited_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_message_edits_message ON message_edits (message_id, edited_at);
@@ -0,0 +1,3 @@
+ALTER TABLE message_recipients DROP COLUMN IF EXISTS hidden_at;
+ALTER TABLE messages DROP COLUMN IF EXISTS sender_hidden_at;
+ALTER TABLE messages DROP COLUMN IF EXISTS deleted_at;
@@ -0,0 +1,5 @@
+-- deleted_at tombstones a message for everyone; sender_hidden_at and
+-- message_recipients.hidden_at hide it for a single participant
+ALTER TABLE messages ADD COLUMN deleted_at TIMESTAMPTZ;
+ALTER TABLE messages ADD COLUMN sender_hidden_at TIMESTAMPTZ;
+ALTER TABLE message_recipients ADD COLUMN hidden_at TIMESTAMPTZ;
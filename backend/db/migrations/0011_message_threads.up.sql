@@ -0,0 +1,7 @@
+-- reply_to_id quotes another message; thread_root_id groups replies under the
+-- message that started the thread
+ALTER TABLE messages ADD COLUMN reply_to_id UUID REFERENCES messages(id) ON DELETE SET NULL;
+ALTER TABLE messages ADD COLUMN thread_root_id UUID REFERENCES messages(id) ON DELETE SET NULL;
+
+CREATE INDEX idx_messages_thread_created ON messages (thread_root_id, created_at, id)
+    WHERE thread_root_id IS NOT NULL;
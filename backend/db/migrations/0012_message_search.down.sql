@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_messages_content_tsv;
+ALTER TABLE messages DROP COLUMN IF EXISTS content_tsv;
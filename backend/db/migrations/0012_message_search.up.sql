@@ -0,0 +1,5 @@
+-- The 'simple' configuration matches whole words in any language without stemming
+ALTER TABLE messages ADD COLUMN content_tsv TSVECTOR
+    GENERATED ALWAYS AS (to_tsvector('simple', coalesce(content, ''))) STORED;
+
+CREATE INDEX idx_messages_content_tsv ON messages USING GIN (content_tsv);
@@ -0,0 +1,5 @@
+CREATE TABLE uploads (
+    file_name TEXT PRIMARY KEY,
+    owner_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
@@ -0,0 +1,16 @@
+CREATE TABLE uploads (
+    file_name TEXT PRIMARY KEY,
+    owner_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+INSERT INTO uploads (file_name, owner_id, created_at)
+SELECT object_key, owner_id, created_at FROM attachments;
+
+UPDATE messages m
+SET media_url = '/uploads/' || a.object_key
+FROM attachments a
+WHERE m.attachment_id = a.id;
+
+ALTER TABLE messages DROP COLUMN attachment_id;
+DROP TABLE IF EXISTS attachments;
@@ -0,0 +1,32 @@
+CREATE TABLE attachments (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    owner_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    object_key TEXT NOT NULL UNIQUE,
+    file_name TEXT NOT NULL DEFAULT '',
+    content_type TEXT NOT NULL DEFAULT '',
+    size BIGINT NOT NULL DEFAULT 0,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+ALTER TABLE messages ADD COLUMN attachment_id UUID REFERENCES attachments(id) ON DELETE SET NULL;
+CREATE INDEX idx_messages_attachment_id ON messages(attachment_id) WHERE attachment_id IS NOT NULL;
+
+-- Files already in uploads/ become attachments whose object key is the file
+-- name, so the local store keeps serving them from the same directory
+INSERT INTO attachments (owner_id, object_key, file_name, created_at)
+SELECT owner_id, file_name, file_name, created_at FROM uploads;
+
+-- Files sent before uploads were recorded belong to their first sender
+INSERT INTO attachments (owner_id, object_key, file_name, created_at)
+SELECT DISTINCT ON (substr(media_url, 10)) sender_id, substr(media_url, 10), substr(media_url, 10), created_at
+FROM messages
+WHERE media_url LIKE '/uploads/%'
+ORDER BY substr(media_url, 10), created_at
+ON CONFLICT (object_key) DO NOTHING;
+
+UPDATE messages m
+SET attachment_id = a.id, media_url = '/attachments/' || a.id
+FROM attachments a
+WHERE m.media_url = '/uploads/' || a.object_key;
+
+DROP TABLE uploads;
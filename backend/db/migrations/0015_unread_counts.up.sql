@@ -0,0 +1,3 @@
+-- Backs the unread counts of the conversation list
+CREATE INDEX IF NOT EXISTS idx_message_recipients_unread
+    ON message_recipients (recipient_id, message_id) WHERE read_at IS NULL;
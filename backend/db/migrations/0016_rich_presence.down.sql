@@ -0,0 +1,7 @@
+ALTER TABLE users
+    DROP COLUMN IF EXISTS is_idle,
+    DROP COLUMN IF EXISTS last_seen_at,
+    DROP COLUMN IF EXISTS presence_status,
+    DROP COLUMN IF EXISTS custom_status,
+    DROP COLUMN IF EXISTS custom_status_expires_at,
+    DROP COLUMN IF EXISTS last_seen_visibility;
@@ -0,0 +1,9 @@
+ALTER TABLE users
+    ADD COLUMN is_idle BOOLEAN NOT NULL DEFAULT FALSE,
+    ADD COLUMN last_seen_at TIMESTAMPTZ,
+    ADD COLUMN presence_status TEXT NOT NULL DEFAULT 'online'
+        CHECK (presence_status IN ('online', 'away', 'dnd', 'invisible')),
+    ADD COLUMN custom_status TEXT NOT NULL DEFAULT '',
+    ADD COLUMN custom_status_expires_at TIMESTAMPTZ,
+    ADD COLUMN last_seen_visibility TEXT NOT NULL DEFAULT 'everyone'
+        CHECK (last_seen_visibility IN ('everyone', 'contacts', 'nobody'));
@@ -0,0 +1,12 @@
+-- Which instance holds connections of which user. Instances renew their leases
+-- periodically; users left without a live lease, e.g. after a crash, are
+-- marked offline by the next sweep. See websocket.PresenceStore
+CREATE TABLE presence_leases (
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    instance_id TEXT NOT NULL,
+    renewed_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    expires_at TIMESTAMPTZ NOT NULL,
+    PRIMARY KEY (user_id, instance_id)
+);
+
+CREATE INDEX idx_presence_leases_expires_at ON presence_leases (expires_at);
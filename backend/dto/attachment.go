@@ -0,0 +1,21 @@
+package dto
+
+import "time"
+
+// AttachmentResponse is returned by POST /upload; URL is the media_url to send
+type AttachmentResponse struct {
+	ID          string `json:"id"`
+	URL         string `json:"url"`
+	FileName    string `json:"file_name"`
+	ContentType string `json:"content_type"`
+	Size        int64  `json:"size"`
+}
+
+// AttachmentLink is a short-lived URL that downloads an attachment without a token
+type AttachmentLink struct {
+	URL         string    `json:"url"`
+	ExpiresAt   time.Time `json:"expires_at"`
+	FileName    string    `json:"file_name"`
+	ContentType string    `json:"content_type"`
+	Size        int64     `json:"size"`
+}
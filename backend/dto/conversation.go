@@ -0,0 +1,68 @@
+package dto
+
+import (
+	"chatting-service-app/models"
+	"github.com/google/uuid"
+	"time"
+)
+
+type CreateConversationRequest struct {
+	Name      string      `json:"name"`
+	MemberIDs []uuid.UUID `json:"member_ids"`
+}
+
+type RenameConversationRequest struct {
+	Name string `json:"name"`
+}
+
+type AddMembersRequest struct {
+	UserIDs []uuid.UUID `json:"user_ids"`
+}
+
+type UpdateMemberRoleRequest struct {
+	Role string `json:"role"`
+}
+
+type ConversationMemberResponse struct {
+	UserID   uuid.UUID `json:"user_id"`
+	Username string    `json:"username"`
+	Role     string    `json:"role"`
+	JoinedAt time.Time `json:"joined_at"`
+}
+
+type ConversationResponse struct {
+	ID        uuid.UUID                    `json:"id"`
+	Name      string                       `json:"name"`
+	CreatedBy uuid.UUID                    `json:"created_by"`
+	CreatedAt time.Time                    `json:"created_at"`
+	Members   []ConversationMemberResponse `json:"members,omitempty"`
+}
+
+// Kinds of conversation in the conversation list
+const (
+	ConversationTypeDirect = "direct"
+	ConversationTypeGroup  = "group"
+)
+
+// ConversationSummary is one entry of the caller's conversation list. ID is the
+// peer's user ID for direct chats and the conversation ID for groups; Name is
+// the peer's username or the group name. Groups without messages yet use their
+// creation time as LastActivityAt. Removed is only set on the update that
+// tells a user they left or were removed from a group; clients drop the entry.
+type ConversationSummary struct {
+	Type           string          `json:"type"`
+	ID             uuid.UUID       `json:"id"`
+	Name           string          `json:"name"`
+	LastMessage    *models.Message `json:"last_message,omitempty"`
+	LastActivityAt time.Time       `json:"last_activity_at"`
+	UnreadCount    int             `json:"unread_count"`
+	Removed        bool            `json:"removed,omitempty"`
+}
+
+// ConversationPage is the envelope returned by GET /conversations, most
+// recently active first; pass NextCursor back as before to continue
+type ConversationPage struct {
+	Conversations []ConversationSummary `json:"conversations"`
+	NextCursor    string                `json:"next_cursor,omitempty"`
+	HasMore       bool                  `json:"has_more"`
+}
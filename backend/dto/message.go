@@ -0,0 +1,11 @@
+package dto
+
+// EditMessageRequest is the body of PATCH /messages/{id}
+type EditMessageRequest struct {
+	Content string `json:"content"`
+}
+
+// AddReactionRequest is the body of POST /messages/{id}/reactions
+type AddReactionRequest struct {
+	Emoji string `json:"emoji"`
+}
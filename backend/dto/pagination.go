@@ -0,0 +1,65 @@
+package dto
+
+import (
+	"chatting-service-app/models"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Page size bounds for paginated endpoints
+const (
+	DefaultPageLimit = 50
+	MaxPageLimit     = 200
+)
+
+// Cursor is a keyset position on (created_at, id); clients treat it as opaque
+type Cursor struct {
+	CreatedAt time.Time
+	ID        uuid.UUID
+}
+
+func (c Cursor) Encode() string {
+	raw := c.CreatedAt.UTC().Format(time.RFC3339Nano) + "|" + c.ID.String()
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func DecodeCursor(s string) (*Cursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, errors.New("invalid cursor")
+	}
+	createdAtStr, idStr, ok := strings.Cut(string(raw), "|")
+	if !ok {
+		return nil, errors.New("invalid cursor")
+	}
+	createdAt, err := time.Parse(time.RFC3339Nano, createdAtStr)
+	if err != nil {
+		return nil, errors.New("invalid cursor")
+	}
+	id, err := uuid.Parse(idStr)
+	if err != nil {
+		return nil, errors.New("invalid cursor")
+	}
+	return &Cursor{CreatedAt: createdAt, ID: id}, nil
+}
+
+// PageRequest selects a page of results. With no cursor it returns the newest
+// page; Before walks back through older results and After forward through newer ones.
+type PageRequest struct {
+	Before *Cursor
+	After  *Cursor
+	Limit  int
+}
+
+// MessagePage is the envelope returned by the message history endpoints.
+// Messages are always in ascending order; pass NextCursor back as the same
+// direction parameter (before, or after when paging forward) to continue.
+type MessagePage struct {
+	Messages   []models.Message `json:"messages"`
+	NextCursor string           `json:"next_cursor,omitempty"`
+	HasMore    bool             `json:"has_more"`
+}
@@ -0,0 +1,68 @@
+package dto
+
+import (
+	"chatting-service-app/models"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// MaxCustomStatusLength bounds custom_status in characters
+const MaxCustomStatusLength = 100
+
+// UserPresence is how a user appears to others. LastSeenAt is left out when
+// the user's privacy settings hide it from the viewer.
+type UserPresence struct {
+	ID                    uuid.UUID  `json:"id"`
+	Username              string     `json:"username"`
+	Status                string     `json:"status"`
+	CustomStatus          string     `json:"custom_status,omitempty"`
+	CustomStatusExpiresAt *time.Time `json:"custom_status_expires_at,omitempty"`
+	LastSeenAt            *time.Time `json:"last_seen_at,omitempty"`
+}
+
+// NewUserPresence describes the user as others see them: invisible and
+// disconnected users are offline, idle users who chose online are away, and
+// expired custom statuses are dropped
+func NewUserPresence(user *models.User, showLastSeen bool) UserPresence {
+	p := UserPresence{ID: user.ID, Username: user.Username, Status: EffectiveStatus(user)}
+	if user.CustomStatus != "" && (user.CustomStatusExpiresAt == nil || user.CustomStatusExpiresAt.After(time.Now())) {
+		p.CustomStatus = user.CustomStatus
+		p.CustomStatusExpiresAt = user.CustomStatusExpiresAt
+	}
+	if showLastSeen {
+		p.LastSeenAt = user.LastSeenAt
+	}
+	return p
+}
+
+// EffectiveStatus is the status others see for the user
+func EffectiveStatus(user *models.User) string {
+	switch {
+	case !user.IsOnline || user.PresenceStatus == models.PresenceInvisible:
+		return models.PresenceOffline
+	case user.PresenceStatus == models.PresenceAway || user.PresenceStatus == models.PresenceDoNotDisturb:
+		return user.PresenceStatus
+	case user.IsIdle:
+		return models.PresenceAway
+	}
+	return models.PresenceOnline
+}
+
+// PresenceSettings are the caller's own presence choices
+type PresenceSettings struct {
+	Status                string     `json:"status"`
+	CustomStatus          string     `json:"custom_status"`
+	CustomStatusExpiresAt *time.Time `json:"custom_status_expires_at,omitempty"`
+	LastSeenVisibility    string     `json:"last_seen_visibility"`
+}
+
+// UpdatePresenceRequest changes the fields that are present. An empty
+// custom_status clears it; custom_status_expires_at only applies together
+// with a new custom_status.
+type UpdatePresenceRequest struct {
+	Status                *string    `json:"status"`
+	CustomStatus          *string    `json:"custom_status"`
+	CustomStatusExpiresAt *time.Time `json:"custom_status_expires_at"`
+	LastSeenVisibility    *string    `json:"last_seen_visibility"`
+}
@@ -0,0 +1,35 @@
+package dto
+
+import (
+	"chatting-service-app/models"
+	"time"
+)
+
+// MaxSearchQueryLength bounds the q parameter of GET /messages/search
+const MaxSearchQueryLength = 256
+
+// SearchRequest holds the query and filters of a message search. Results are
+// returned newest first; Before continues from a previous page's NextCursor.
+type SearchRequest struct {
+	Query    string
+	PeerID   string
+	From     *time.Time
+	To       *time.Time
+	HasMedia *bool
+	Before   *Cursor
+	Limit    int
+}
+
+// SearchResult is one matching message; Snippet is an HTML-escaped excerpt of
+// the content with matches wrapped in <mark></mark>
+type SearchResult struct {
+	Message models.Message `json:"message"`
+	Snippet string         `json:"snippet"`
+}
+
+// SearchPage is the envelope returned by GET /messages/search
+type SearchPage struct {
+	Results    []SearchResult `json:"results"`
+	NextCursor string         `json:"next_cursor,omitempty"`
+	HasMore    bool           `json:"has_more"`
+}
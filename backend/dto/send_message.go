@@ -2,14 +2,20 @@ package dto
 
 import (
 	"github.com/google/uuid"
-	"time"
 )
 
+// SendMessageRequest is the body of POST /messages. ClientMessageID is an
+// optional idempotency key chosen by the sender. ReplyToID quotes a message and
+// ThreadRootID posts the message as a reply in that message's thread; both must
+// reference a message in the same conversation.
 type SendMessageRequest struct {
-	SenderID    uuid.UUID `json:"sender_id"`
-	RecipientID uuid.UUID `json:"recipient_id"`
-	Content     string    `json:"content"`
-	MediaURL    string    `json:"media_url"`
-	IsBroadcast bool      `json:"is_broadcast"`
-	CreatedAt   time.Time `json:"created_at"`
+	SenderID        uuid.UUID  `json:"sender_id"`
+	RecipientID     uuid.UUID  `json:"recipient_id"`
+	ConversationID  *uuid.UUID `json:"conversation_id,omitempty"`
+	Content         string     `json:"content"`
+	MediaURL        string     `json:"media_url"`
+	IsBroadcast     bool       `json:"is_broadcast"`
+	ClientMessageID string     `json:"client_msg_id,omitempty"`
+	ReplyToID       *uuid.UUID `json:"reply_to_id,omitempty"`
+	ThreadRootID    *uuid.UUID `json:"thread_root_id,omitempty"`
 }
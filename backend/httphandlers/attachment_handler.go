@@ -0,0 +1,99 @@
+package httphandlers
+
+import (
+	"chatting-service-app/dto"
+	"chatting-service-app/service"
+	"chatting-service-app/utils"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// maxUploadSize bounds the multipart body of POST /upload
+const maxUploadSize = 10 << 20
+
+type AttachmentHandler struct {
+	attachmentService *service.AttachmentService
+}
+
+func NewAttachmentHandler(as *service.AttachmentService) *AttachmentHandler {
+	return &AttachmentHandler{attachmentService: as}
+}
+
+// UploadHandler stores a file owned by the caller; its url is the media_url
+// to send, and only the caller can attach it until it is shared in a message
+func (h *AttachmentHandler) UploadHandler(w http.ResponseWriter, r *http.Request) {
+	userID := principalFrom(r).UserID
+	r.Body = http.MaxBytesReader(w, r.Body, maxUploadSize+1<<20)
+	if err := r.ParseMultipartForm(maxUploadSize); err != nil {
+		writeError(w, http.StatusBadRequest, "could not parse multipart form")
+		return
+	}
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "could not get file from form")
+		return
+	}
+	defer file.Close()
+	if header.Size > maxUploadSize {
+		writeError(w, http.StatusRequestEntityTooLarge, "file is too large")
+		return
+	}
+
+	attachment, err := h.attachmentService.Upload(userID, file, header.Filename, header.Size)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "could not save file")
+		return
+	}
+	utils.WriteJSON(w, http.StatusOK, dto.AttachmentResponse{
+		ID:          attachment.ID.String(),
+		URL:         service.AttachmentURL(attachment.ID),
+		FileName:    attachment.FileName,
+		ContentType: attachment.ContentType,
+		Size:        attachment.Size,
+	})
+}
+
+// GetAttachmentLinkHandler returns a short-lived download URL for an
+// attachment the caller uploaded or can see in a message
+func (h *AttachmentHandler) GetAttachmentLinkHandler(w http.ResponseWriter, r *http.Request) {
+	link, err := h.attachmentService.Link(principalFrom(r).UserID, mux.Vars(r)["id"])
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	utils.WriteJSON(w, http.StatusOK, link)
+}
+
+// DownloadAttachmentHandler serves the file behind a signed URL. It needs no
+// token, so <img> tags can load it; the signature is the authorization.
+func (h *AttachmentHandler) DownloadAttachmentHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	attachment, body, err := h.attachmentService.Open(r.Context(), mux.Vars(r)["id"], q.Get("expires"), q.Get("signature"))
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	defer body.Close()
+
+	// Only media renders inline; anything else, HTML included, is downloaded
+	disposition := "attachment"
+	for _, prefix := range []string{"image/", "video/", "audio/"} {
+		if strings.HasPrefix(attachment.ContentType, prefix) && attachment.ContentType != "image/svg+xml" {
+			disposition = "inline"
+		}
+	}
+	w.Header().Set("Content-Type", attachment.ContentType)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType(disposition, map[string]string{"filename": attachment.FileName}))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Cache-Control", "private, max-age=300")
+	if attachment.Size > 0 {
+		w.Header().Set("Content-Length", strconv.FormatInt(attachment.Size, 10))
+	}
+	w.WriteHeader(http.StatusOK)
+	io.Copy(w, body)
+}
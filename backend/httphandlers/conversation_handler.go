@@ -0,0 +1,149 @@
+package httphandlers
+
+import (
+	"chatting-service-app/dto"
+	"chatting-service-app/models"
+	"chatting-service-app/service"
+	"chatting-service-app/utils"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+type ConversationHandler struct {
+	conversationService *service.ConversationService
+	userService         *service.UserService
+}
+
+func NewConversationHandler(cs *service.ConversationService, us *service.UserService) *ConversationHandler {
+	return &ConversationHandler{conversationService: cs, userService: us}
+}
+
+func (h *ConversationHandler) CreateConversationHandler(w http.ResponseWriter, r *http.Request) {
+	userID := principalFrom(r).UserID
+	var req dto.CreateConversationRequest
+	if !utils.DecodeJSON(r, &req, w) {
+		return
+	}
+	creatorID, err := uuid.Parse(userID)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid user id")
+		return
+	}
+	conv, err := h.conversationService.CreateConversation(creatorID, req.Name, req.MemberIDs)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	h.writeConversation(w, http.StatusCreated, userID, conv.ID.String())
+}
+
+// ListConversationsHandler returns the caller's conversation list; before and
+// limit page through it
+func (h *ConversationHandler) ListConversationsHandler(w http.ResponseWriter, r *http.Request) {
+	userID := principalFrom(r).UserID
+	page, err := parsePageRequest(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	conversations, err := h.conversationService.ListConversations(userID, page)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	utils.WriteJSON(w, http.StatusOK, conversations)
+}
+
+func (h *ConversationHandler) GetConversationHandler(w http.ResponseWriter, r *http.Request) {
+	userID := principalFrom(r).UserID
+	h.writeConversation(w, http.StatusOK, userID, mux.Vars(r)["id"])
+}
+
+func (h *ConversationHandler) RenameConversationHandler(w http.ResponseWriter, r *http.Request) {
+	userID := principalFrom(r).UserID
+	var req dto.RenameConversationRequest
+	if !utils.DecodeJSON(r, &req, w) {
+		return
+	}
+	conversationID := mux.Vars(r)["id"]
+	if err := h.conversationService.Rename(userID, conversationID, req.Name); err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	h.writeConversation(w, http.StatusOK, userID, conversationID)
+}
+
+func (h *ConversationHandler) LeaveConversationHandler(w http.ResponseWriter, r *http.Request) {
+	userID := principalFrom(r).UserID
+	if err := h.conversationService.Leave(userID, mux.Vars(r)["id"]); err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	utils.WriteJSON(w, http.StatusOK, map[string]string{"status": "left"})
+}
+
+func (h *ConversationHandler) AddMembersHandler(w http.ResponseWriter, r *http.Request) {
+	userID := principalFrom(r).UserID
+	var req dto.AddMembersRequest
+	if !utils.DecodeJSON(r, &req, w) {
+		return
+	}
+	conversationID := mux.Vars(r)["id"]
+	if err := h.conversationService.AddMembers(userID, conversationID, req.UserIDs); err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	h.writeConversation(w, http.StatusOK, userID, conversationID)
+}
+
+func (h *ConversationHandler) RemoveMemberHandler(w http.ResponseWriter, r *http.Request) {
+	userID := principalFrom(r).UserID
+	vars := mux.Vars(r)
+	if err := h.conversationService.RemoveMember(userID, vars["id"], vars["userId"]); err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	utils.WriteJSON(w, http.StatusOK, map[string]string{"status": "removed"})
+}
+
+func (h *ConversationHandler) UpdateMemberRoleHandler(w http.ResponseWriter, r *http.Request) {
+	userID := principalFrom(r).UserID
+	var req dto.UpdateMemberRoleRequest
+	if !utils.DecodeJSON(r, &req, w) {
+		return
+	}
+	vars := mux.Vars(r)
+	if err := h.conversationService.SetMemberRole(userID, vars["id"], vars["userId"], req.Role); err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	h.writeConversation(w, http.StatusOK, userID, vars["id"])
+}
+
+func (h *ConversationHandler) writeConversation(w http.ResponseWriter, status int, userID, conversationID string) {
+	conv, members, err := h.conversationService.GetConversation(userID, conversationID)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	utils.WriteJSON(w, status, h.toResponse(conv, members))
+}
+
+func (h *ConversationHandler) toResponse(conv *models.Conversation, members []models.ConversationMember) dto.ConversationResponse {
+	resp := dto.ConversationResponse{
+		ID:        conv.ID,
+		Name:      conv.Name,
+		CreatedBy: conv.CreatedBy,
+		CreatedAt: conv.CreatedAt,
+	}
+	for _, m := range members {
+		member := dto.ConversationMemberResponse{UserID: m.UserID, Role: m.Role, JoinedAt: m.JoinedAt}
+		if user, err := h.userService.GetUserByID(m.UserID.String()); err == nil && user != nil {
+			member.Username = user.Username
+		}
+		resp.Members = append(resp.Members, member)
+	}
+	return resp
+}
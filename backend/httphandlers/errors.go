@@ -0,0 +1,39 @@
+package httphandlers
+
+import (
+	"chatting-service-app/service"
+	"chatting-service-app/utils"
+	"errors"
+	"log"
+	"net/http"
+)
+
+// writeError writes the {"error": message} body every endpoint uses for failures
+func writeError(w http.ResponseWriter, status int, message string) {
+	if status == http.StatusUnauthorized {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+	}
+	utils.WriteJSON(w, status, map[string]string{"error": message})
+}
+
+// writeServiceError maps service sentinel errors to an HTTP status and writes a
+// JSON error. Anything else is a server fault: it is logged and the client only
+// gets a generic message, so database errors never leak.
+func writeServiceError(w http.ResponseWriter, err error) {
+	var status int
+	switch {
+	case errors.Is(err, service.ErrInvalid):
+		status = http.StatusBadRequest
+	case errors.Is(err, service.ErrUnauthorized):
+		status = http.StatusUnauthorized
+	case errors.Is(err, service.ErrNotFound):
+		status = http.StatusNotFound
+	case errors.Is(err, service.ErrForbidden):
+		status = http.StatusForbidden
+	default:
+		log.Printf("internal error: %v", err)
+		writeError(w, http.StatusInternalServerError, "internal server error")
+		return
+	}
+	writeError(w, status, err.Error())
+}
@@ -4,18 +4,52 @@ import (
     "chatting-service-app/dto"
     "chatting-service-app/service"
     "chatting-service-app/utils"
+    "errors"
+    "fmt"
     "net/http"
-    "strings"
+    "strconv"
+    "time"
     "github.com/google/uuid"
+    "github.com/gorilla/mux"
 )
 
 type MessageHandler struct {
     messageService *service.MessageService
-    recipientService *service.MessageRecipientService
 }
 
-func NewMessageHandler(ms *service.MessageService, rs *service.MessageRecipientService) *MessageHandler {
-    return &MessageHandler{messageService: ms, recipientService: rs}
+func NewMessageHandler(ms *service.MessageService) *MessageHandler {
+    return &MessageHandler{messageService: ms}
+}
+
+// Helper: read before/after/limit query parameters for paginated history
+func parsePageRequest(r *http.Request) (dto.PageRequest, error) {
+    var page dto.PageRequest
+    q := r.URL.Query()
+    if before := q.Get("before"); before != "" {
+        cursor, err := dto.DecodeCursor(before)
+        if err != nil {
+            return page, err
+        }
+        page.Before = cursor
+    }
+    if after := q.Get("after"); after != "" {
+        cursor, err := dto.DecodeCursor(after)
+        if err != nil {
+            return page, err
+        }
+        page.After = cursor
+    }
+    if page.Before != nil && page.After != nil {
+        return page, errors.New("before and after cannot be combined")
+    }
+    if limit := q.Get("limit"); limit != "" {
+        n, err := strconv.Atoi(limit)
+        if err != nil || n < 1 || n > dto.MaxPageLimit {
+            return page, fmt.Errorf("limit must be between 1 and %d", dto.MaxPageLimit)
+        }
+        page.Limit = n
+    }
+    return page, nil
 }
 
 func (h *MessageHandler) SendMessageHandler(w http.ResponseWriter, r *http.Request) {
@@ -23,118 +57,256 @@ func (h *MessageHandler) SendMessageHandler(w http.ResponseWriter, r *http.Reque
         w.WriteHeader(http.StatusMethodNotAllowed)
         return
     }
+    senderID := principalFrom(r).UserID
     var req dto.SendMessageRequest
     if !utils.DecodeJSON(r, &req, w) {
         return
     }
-    authHeader := r.Header.Get("Authorization")
-    tokenStr := strings.TrimPrefix(authHeader, "Bearer ")
-    senderID, err := utils.ExtractUserIDFromJWT(tokenStr)
+    // The sender is always the token's user, never the request body
+    req.SenderID = uuid.MustParse(senderID)
+    // Optionally validate RecipientID is a valid uuid.UUID (if needed)
+    msg, err := h.messageService.SendMessage(req, nil)
     if err != nil {
-        utils.WriteJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid or missing token"})
+        writeServiceError(w, err)
+        return
+    }
+    utils.WriteJSON(w, http.StatusCreated, map[string]interface{}{
+        "message":    "message sent",
+        "id":         msg.ID,
+        "created_at": msg.CreatedAt,
+    })
+}
+
+// EditMessageHandler lets the sender change a message's content
+func (h *MessageHandler) EditMessageHandler(w http.ResponseWriter, r *http.Request) {
+    userID := principalFrom(r).UserID
+    var req dto.EditMessageRequest
+    if !utils.DecodeJSON(r, &req, w) {
         return
     }
-    senderUUID, err := uuid.Parse(senderID)
+    msg, err := h.messageService.EditMessage(userID, mux.Vars(r)["id"], req.Content)
     if err != nil {
-        utils.WriteJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid sender id"})
+        writeServiceError(w, err)
         return
     }
-    req.SenderID = senderUUID
-    // Optionally validate RecipientID is a valid uuid.UUID (if needed)
-    err = h.messageService.SendMessage(req)
+    utils.WriteJSON(w, http.StatusOK, msg)
+}
+
+// DeleteMessageHandler deletes a message for the caller (?scope=me, the
+// default) or, for its sender, for everyone (?scope=everyone)
+func (h *MessageHandler) DeleteMessageHandler(w http.ResponseWriter, r *http.Request) {
+    userID := principalFrom(r).UserID
+    scope := r.URL.Query().Get("scope")
+    if scope == "" {
+        scope = service.DeleteScopeMe
+    }
+    if err := h.messageService.DeleteMessage(userID, mux.Vars(r)["id"], scope); err != nil {
+        writeServiceError(w, err)
+        return
+    }
+    utils.WriteJSON(w, http.StatusOK, map[string]string{"status": "deleted"})
+}
+
+func (h *MessageHandler) AddReactionHandler(w http.ResponseWriter, r *http.Request) {
+    userID := principalFrom(r).UserID
+    var req dto.AddReactionRequest
+    if !utils.DecodeJSON(r, &req, w) {
+        return
+    }
+    if err := h.messageService.AddReaction(userID, mux.Vars(r)["id"], req.Emoji); err != nil {
+        writeServiceError(w, err)
+        return
+    }
+    utils.WriteJSON(w, http.StatusOK, map[string]string{"status": "added"})
+}
+
+func (h *MessageHandler) RemoveReactionHandler(w http.ResponseWriter, r *http.Request) {
+    userID := principalFrom(r).UserID
+    vars := mux.Vars(r)
+    if err := h.messageService.RemoveReaction(userID, vars["id"], vars["emoji"]); err != nil {
+        writeServiceError(w, err)
+        return
+    }
+    utils.WriteJSON(w, http.StatusOK, map[string]string{"status": "removed"})
+}
+
+// GetThreadRepliesHandler pages through a thread with the same before/after/limit
+// parameters as the history endpoints
+func (h *MessageHandler) GetThreadRepliesHandler(w http.ResponseWriter, r *http.Request) {
+    userID := principalFrom(r).UserID
+    page, err := parsePageRequest(r)
     if err != nil {
-        utils.WriteJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+        writeError(w, http.StatusBadRequest, err.Error())
         return
     }
-    utils.WriteJSON(w, http.StatusCreated, map[string]string{"message": "message sent"})
+    replies, err := h.messageService.GetThreadReplies(userID, mux.Vars(r)["id"], page)
+    if err != nil {
+        writeServiceError(w, err)
+        return
+    }
+    utils.WriteJSON(w, http.StatusOK, replies)
 }
 
-func (h *MessageHandler) GetMessagesBetweenUsersHandler(w http.ResponseWriter, r *http.Request) {
-    // JWT check
-    authHeader := r.Header.Get("Authorization")
-    tokenStr := strings.TrimPrefix(authHeader, "Bearer ")
-    _, err := utils.ExtractUserIDFromJWT(tokenStr)
+// SearchMessagesHandler searches the caller's messages. q is required; peer,
+// from/to (RFC 3339), has_media, before and limit narrow or page the results.
+func (h *MessageHandler) SearchMessagesHandler(w http.ResponseWriter, r *http.Request) {
+    userID := principalFrom(r).UserID
+    q := r.URL.Query()
+    page, err := parsePageRequest(r)
+    if err == nil && page.After != nil {
+        err = errors.New("search results can only be paged with before")
+    }
     if err != nil {
-        utils.WriteJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid or missing token"})
+        writeError(w, http.StatusBadRequest, err.Error())
         return
     }
+    req := dto.SearchRequest{Query: q.Get("q"), PeerID: q.Get("peer"), Before: page.Before, Limit: page.Limit}
+    for name, dst := range map[string]**time.Time{"from": &req.From, "to": &req.To} {
+        if v := q.Get(name); v != "" {
+            t, err := time.Parse(time.RFC3339, v)
+            if err != nil {
+                writeError(w, http.StatusBadRequest, name + " must be an RFC 3339 timestamp")
+                return
+            }
+            *dst = &t
+        }
+    }
+    if v := q.Get("has_media"); v != "" {
+        hasMedia, err := strconv.ParseBool(v)
+        if err != nil {
+            writeError(w, http.StatusBadRequest, "has_media must be true or false")
+            return
+        }
+        req.HasMedia = &hasMedia
+    }
+    results, err := h.messageService.SearchMessages(userID, req)
+    if err != nil {
+        writeServiceError(w, err)
+        return
+    }
+    utils.WriteJSON(w, http.StatusOK, results)
+}
+
+func (h *MessageHandler) GetMessageEditsHandler(w http.ResponseWriter, r *http.Request) {
+    userID := principalFrom(r).UserID
+    edits, err := h.messageService.GetMessageEdits(userID, mux.Vars(r)["id"])
+    if err != nil {
+        writeServiceError(w, err)
+        return
+    }
+    utils.WriteJSON(w, http.StatusOK, edits)
+}
+
+// GetMessagesBetweenUsersHandler returns 1:1 history; the caller must be user1 or user2
+func (h *MessageHandler) GetMessagesBetweenUsersHandler(w http.ResponseWriter, r *http.Request) {
+    viewerID := principalFrom(r).UserID
     user1ID := r.URL.Query().Get("user1")
     user2ID := r.URL.Query().Get("user2")
     if user1ID == "" || user2ID == "" {
-        utils.WriteJSON(w, http.StatusBadRequest, map[string]string{"error": "user1 and user2 are required"})
+        writeError(w, http.StatusBadRequest, "user1 and user2 are required")
+        return
+    }
+    page, err := parsePageRequest(r)
+    if err != nil {
+        writeError(w, http.StatusBadRequest, err.Error())
         return
     }
-    messages, err := h.messageService.GetMessagesBetweenUsers(user1ID, user2ID)
+    messages, err := h.messageService.GetMessagesBetweenUsers(viewerID, user1ID, user2ID, page)
     if err != nil {
-        utils.WriteJSON(w, http.StatusInternalServerError, map[string]string{"error": "could not fetch messages"})
+        writeServiceError(w, err)
         return
     }
     utils.WriteJSON(w, http.StatusOK, messages)
 }
 
+// GetAllMessagesForUserHandler returns the caller's own messages; user must be the caller
 func (h *MessageHandler) GetAllMessagesForUserHandler(w http.ResponseWriter, r *http.Request) {
-    // JWT check
-    authHeader := r.Header.Get("Authorization")
-    tokenStr := strings.TrimPrefix(authHeader, "Bearer ")
-    _, err := utils.ExtractUserIDFromJWT(tokenStr)
-    if err != nil {
-        utils.WriteJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid or missing token"})
-        return
-    }
+    viewerID := principalFrom(r).UserID
     userID := r.URL.Query().Get("user")
     if userID == "" {
-        utils.WriteJSON(w, http.StatusBadRequest, map[string]string{"error": "user is required"})
+        writeError(w, http.StatusBadRequest, "user is required")
         return
     }
-    messages, err := h.messageService.GetAllMessagesForUser(userID)
+    page, err := parsePageRequest(r)
     if err != nil {
-        utils.WriteJSON(w, http.StatusInternalServerError, map[string]string{"error": "could not fetch messages"})
+        writeError(w, http.StatusBadRequest, err.Error())
+        return
+    }
+    messages, err := h.messageService.GetAllMessagesForUser(viewerID, userID, page)
+    if err != nil {
+        writeServiceError(w, err)
         return
     }
     utils.WriteJSON(w, http.StatusOK, messages)
 }
 
-func (h *MessageHandler) MarkMessageDeliveredHandler(w http.ResponseWriter, r *http.Request) {
-    // JWT check
-    authHeader := r.Header.Get("Authorization")
-    tokenStr := strings.TrimPrefix(authHeader, "Bearer ")
-    _, err := utils.ExtractUserIDFromJWT(tokenStr)
+// GetConversationMessagesHandler returns the history of a group conversation the caller belongs to
+func (h *MessageHandler) GetConversationMessagesHandler(w http.ResponseWriter, r *http.Request) {
+    userID := principalFrom(r).UserID
+    conversationID := r.URL.Query().Get("conversation_id")
+    if _, err := uuid.Parse(conversationID); err != nil {
+        writeError(w, http.StatusBadRequest, "invalid conversation_id")
+        return
+    }
+    page, err := parsePageRequest(r)
     if err != nil {
-        utils.WriteJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid or missing token"})
+        writeError(w, http.StatusBadRequest, err.Error())
         return
     }
+    messages, err := h.messageService.GetConversationMessages(userID, conversationID, page)
+    if err != nil {
+        writeServiceError(w, err)
+        return
+    }
+    utils.WriteJSON(w, http.StatusOK, messages)
+}
+
+// receiptParams reads message_id and the optional recipient_id of a receipt
+// request. The recipient is always the caller; a different recipient_id is rejected.
+func receiptParams(w http.ResponseWriter, r *http.Request) (string, string, bool) {
+    recipientID := principalFrom(r).UserID
     messageID := r.URL.Query().Get("message_id")
-    recipientID := r.URL.Query().Get("recipient_id")
-    if messageID == "" || recipientID == "" {
-        utils.WriteJSON(w, http.StatusBadRequest, map[string]string{"error": "message_id and recipient_id are required"})
+    if messageID == "" {
+        writeError(w, http.StatusBadRequest, "message_id is required")
+        return "", "", false
+    }
+    if param := r.URL.Query().Get("recipient_id"); param != "" && param != recipientID {
+        writeError(w, http.StatusForbidden, "cannot acknowledge messages for another user")
+        return "", "", false
+    }
+    return messageID, recipientID, true
+}
+
+func (h *MessageHandler) MarkMessageDeliveredHandler(w http.ResponseWriter, r *http.Request) {
+    messageID, recipientID, ok := receiptParams(w, r)
+    if !ok {
         return
     }
-    err = h.recipientService.SetDeliveredAt(messageID, recipientID)
-    if err != nil {
-        utils.WriteJSON(w, http.StatusInternalServerError, map[string]string{"error": "could not mark as delivered"})
+    if err := h.messageService.SetDeliveredAt(messageID, recipientID); err != nil {
+        writeServiceError(w, err)
         return
     }
     utils.WriteJSON(w, http.StatusOK, map[string]string{"status": "delivered"})
 }
 
-func (h *MessageHandler) MarkMessageReadHandler(w http.ResponseWriter, r *http.Request) {
-    // JWT check
-    authHeader := r.Header.Get("Authorization")
-    tokenStr := strings.TrimPrefix(authHeader, "Bearer ")
-    _, err := utils.ExtractUserIDFromJWT(tokenStr)
+// ReadUpToHandler marks read every message the caller received in the
+// message's conversation up to and including it
+func (h *MessageHandler) ReadUpToHandler(w http.ResponseWriter, r *http.Request) {
+    read, err := h.messageService.ReadUpTo(principalFrom(r).UserID, mux.Vars(r)["id"], nil)
     if err != nil {
-        utils.WriteJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid or missing token"})
+        writeServiceError(w, err)
         return
     }
-    messageID := r.URL.Query().Get("message_id")
-    recipientID := r.URL.Query().Get("recipient_id")
-    if messageID == "" || recipientID == "" {
-        utils.WriteJSON(w, http.StatusBadRequest, map[string]string{"error": "message_id and recipient_id are required"})
+    utils.WriteJSON(w, http.StatusOK, map[string]interface{}{"status": "read", "message_ids": read})
+}
+
+func (h *MessageHandler) MarkMessageReadHandler(w http.ResponseWriter, r *http.Request) {
+    messageID, recipientID, ok := receiptParams(w, r)
+    if !ok {
         return
     }
-    err = h.recipientService.SetReadAt(messageID, recipientID)
-    if err != nil {
-        utils.WriteJSON(w, http.StatusInternalServerError, map[string]string{"error": "could not mark as read"})
+    if err := h.messageService.SetReadAt(messageID, recipientID, nil); err != nil {
+        writeServiceError(w, err)
         return
     }
     utils.WriteJSON(w, http.StatusOK, map[string]string{"status": "read"})
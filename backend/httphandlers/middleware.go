@@ -0,0 +1,45 @@
+package httphandlers
+
+import (
+	"chatting-service-app/service"
+	"context"
+	"net/http"
+	"strings"
+)
+
+type principalKey struct{}
+
+// RequireAuth authenticates the bearer token of every request it wraps and
+// stores the caller in the request context; unauthenticated requests get a
+// JSON 401. Handlers behind it read the caller with principalFrom.
+func RequireAuth(next http.Handler) http.Handler {
+	return authenticate(bearerToken, next)
+}
+
+// requireAuthQuery is RequireAuth for WebSocket upgrades, which browsers
+// cannot send headers with; the token comes from the token query parameter
+func requireAuthQuery(next http.Handler) http.Handler {
+	return authenticate(func(r *http.Request) string { return r.URL.Query().Get("token") }, next)
+}
+
+func authenticate(token func(*http.Request) string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		principal, err := service.Authenticate(token(r))
+		if err != nil {
+			writeError(w, http.StatusUnauthorized, "invalid or missing token")
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), principalKey{}, principal)))
+	})
+}
+
+func bearerToken(r *http.Request) string {
+	return strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+}
+
+// principalFrom returns the caller stored by RequireAuth. It is only
+// meaningful on authenticated routes.
+func principalFrom(r *http.Request) service.Principal {
+	principal, _ := r.Context().Value(principalKey{}).(service.Principal)
+	return principal
+}
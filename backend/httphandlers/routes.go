@@ -4,36 +4,72 @@ import (
     "net/http"
 
     "github.com/gorilla/mux"
-    "chatting-service-app/service"
+    "chatting-service-app/utils"
     "chatting-service-app/websocket"
 )
 
-func SetupRouter(userHandler *UserHandler, hub *websocket.Hub, messageHandler *MessageHandler, recipientService *service.MessageRecipientService) *mux.Router {
+// SetupRouter registers every route as either public or authenticated.
+// Authenticated routes sit behind RequireAuth and read the caller with principalFrom.
+func SetupRouter(userHandler *UserHandler, hub *websocket.Hub, messageHandler *MessageHandler, conversationHandler *ConversationHandler, attachmentHandler *AttachmentHandler) *mux.Router {
     r := mux.NewRouter()
 
+    // Public auth routes
     authRouter := r.PathPrefix("/auth").Subrouter()
     authRouter.HandleFunc("/signup", userHandler.SignUpHandler).Methods("POST")
     authRouter.HandleFunc("/login", userHandler.LoginHandler).Methods("POST")
-    authRouter.HandleFunc("/online-users", userHandler.GetOnlineUsersHandler).Methods("GET")
+    authRouter.HandleFunc("/refresh", userHandler.RefreshHandler).Methods("POST")
+
+    // Authenticated auth routes
+    account := authRouter.NewRoute().Subrouter()
+    account.Use(RequireAuth)
+    account.HandleFunc("/logout", userHandler.LogoutHandler).Methods("POST")
+    account.HandleFunc("/logout-all", userHandler.LogoutAllHandler).Methods("POST")
+    account.HandleFunc("/sessions", userHandler.ListSessionsHandler).Methods("GET")
+    account.HandleFunc("/sessions/{id}", userHandler.RevokeSessionHandler).Methods("DELETE")
+    account.HandleFunc("/online-users", userHandler.GetOnlineUsersHandler).Methods("GET")
+    account.HandleFunc("/presence", userHandler.GetPresenceHandler).Methods("GET")
+    account.HandleFunc("/presence", userHandler.UpdatePresenceHandler).Methods("PATCH")
     // Add endpoint to get all users except self
-    authRouter.HandleFunc("/users", userHandler.GetAllUsersExceptHandler).Methods("GET")
+    account.HandleFunc("/users", userHandler.GetAllUsersExceptHandler).Methods("GET")
     // Add endpoint to get current user data
-    authRouter.HandleFunc("/me", userHandler.MeHandler).Methods("GET")
+    account.HandleFunc("/me", userHandler.MeHandler).Methods("GET")
+
+    api := r.NewRoute().Subrouter()
+    api.Use(RequireAuth)
 
     // Message routes
-    r.HandleFunc("/messages", messageHandler.SendMessageHandler).Methods("POST")
-    r.HandleFunc("/messages", messageHandler.GetMessagesBetweenUsersHandler).Methods("GET").Queries("user1", "{user1}", "user2", "{user2}")
-    r.HandleFunc("/messages", messageHandler.GetAllMessagesForUserHandler).Methods("GET").Queries("user", "{user}")
-    r.HandleFunc("/messages/delivered", messageHandler.MarkMessageDeliveredHandler).Methods("POST")
-    r.HandleFunc("/messages/read", messageHandler.MarkMessageReadHandler).Methods("POST")
+    api.HandleFunc("/messages", messageHandler.SendMessageHandler).Methods("POST")
+    api.HandleFunc("/messages", messageHandler.GetMessagesBetweenUsersHandler).Methods("GET").Queries("user1", "{user1}", "user2", "{user2}")
+    api.HandleFunc("/messages", messageHandler.GetAllMessagesForUserHandler).Methods("GET").Queries("user", "{user}")
+    api.HandleFunc("/messages", messageHandler.GetConversationMessagesHandler).Methods("GET").Queries("conversation_id", "{conversation_id}")
+    api.HandleFunc("/messages/delivered", messageHandler.MarkMessageDeliveredHandler).Methods("POST")
+    api.HandleFunc("/messages/read", messageHandler.MarkMessageReadHandler).Methods("POST")
+    api.HandleFunc("/messages/search", messageHandler.SearchMessagesHandler).Methods("GET")
+    api.HandleFunc("/messages/{id}", messageHandler.EditMessageHandler).Methods("PATCH")
+    api.HandleFunc("/messages/{id}", messageHandler.DeleteMessageHandler).Methods("DELETE")
+    api.HandleFunc("/messages/{id}/edits", messageHandler.GetMessageEditsHandler).Methods("GET")
+    api.HandleFunc("/messages/{id}/thread", messageHandler.GetThreadRepliesHandler).Methods("GET")
+    api.HandleFunc("/messages/{id}/read-up-to", messageHandler.ReadUpToHandler).Methods("POST")
+    api.HandleFunc("/messages/{id}/reactions", messageHandler.AddReactionHandler).Methods("POST")
+    api.HandleFunc("/messages/{id}/reactions/{emoji}", messageHandler.RemoveReactionHandler).Methods("DELETE")
+
+    // Group conversation routes
+    api.HandleFunc("/conversations", conversationHandler.ListConversationsHandler).Methods("GET")
+    api.HandleFunc("/conversations", conversationHandler.CreateConversationHandler).Methods("POST")
+    api.HandleFunc("/conversations/{id}", conversationHandler.GetConversationHandler).Methods("GET")
+    api.HandleFunc("/conversations/{id}", conversationHandler.RenameConversationHandler).Methods("PATCH")
+    api.HandleFunc("/conversations/{id}/leave", conversationHandler.LeaveConversationHandler).Methods("POST")
+    api.HandleFunc("/conversations/{id}/members", conversationHandler.AddMembersHandler).Methods("POST")
+    api.HandleFunc("/conversations/{id}/members/{userId}", conversationHandler.UpdateMemberRoleHandler).Methods("PATCH")
+    api.HandleFunc("/conversations/{id}/members/{userId}", conversationHandler.RemoveMemberHandler).Methods("DELETE")
 
-    // Upload and download routes
-    r.HandleFunc("/upload", UploadHandler).Methods("POST")
-    r.HandleFunc("/download", DownloadHandler).Methods("GET")
-    r.PathPrefix("/uploads/").Handler(http.StripPrefix("/uploads/", http.FileServer(http.Dir("uploads"))))
+    // Attachment routes; the content route is authorized by its URL signature instead of a token
+    api.HandleFunc("/upload", attachmentHandler.UploadHandler).Methods("POST")
+    api.HandleFunc("/attachments/{id}", attachmentHandler.GetAttachmentLinkHandler).Methods("GET")
+    r.HandleFunc("/attachments/{id}/content", attachmentHandler.DownloadAttachmentHandler).Methods("GET")
 
-    // WebSocket route
-    r.HandleFunc("/ws", ServeWs(hub)).Methods("GET")
+    // WebSocket route; browsers cannot set headers on the upgrade, so the token is a query parameter
+    r.Handle("/ws", requireAuthQuery(ServeWs(hub))).Methods("GET")
 
     r.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
         w.Write([]byte("Hello from my Go project!"))
@@ -44,5 +80,11 @@ func SetupRouter(userHandler *UserHandler, hub *websocket.Hub, messageHandler *M
         w.Write([]byte(`{"status": "ok"}`))
     }).Methods("GET")
 
+    // Hub gauges and slow-consumer counters for monitoring; they describe the
+    // whole deployment, so only authenticated callers may read them
+    api.HandleFunc("/metrics/hub", func(w http.ResponseWriter, r *http.Request) {
+        utils.WriteJSON(w, http.StatusOK, hub.Stats())
+    }).Methods("GET")
+
     return r
-}
\ No newline at end of file
+}
@@ -1,18 +1,25 @@
 package httphandlers
 
 import (
+    "chatting-service-app/dto"
+    "chatting-service-app/models"
     "chatting-service-app/service"
     "chatting-service-app/utils"
+    "net"
     "net/http"
     "strings"
+
+    "github.com/gorilla/mux"
 )
 
 type UserHandler struct {
-    userService *service.UserService
+    userService     *service.UserService
+    sessionService  *service.SessionService
+    presenceService *service.PresenceService
 }
 
-func NewUserHandler(us *service.UserService) *UserHandler {
-    return &UserHandler{userService: us}
+func NewUserHandler(us *service.UserService, ss *service.SessionService, ps *service.PresenceService) *UserHandler {
+    return &UserHandler{userService: us, sessionService: ss, presenceService: ps}
 }
 
 type signUpRequest struct {
@@ -30,6 +37,18 @@ func requirePost(w http.ResponseWriter, r *http.Request) bool {
     return true
 }
 
+// Helper: best-effort client address for the session list
+func clientIP(r *http.Request) string {
+    if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+        return strings.TrimSpace(strings.Split(forwarded, ",")[0])
+    }
+    host, _, err := net.SplitHostPort(r.RemoteAddr)
+    if err != nil {
+        return r.RemoteAddr
+    }
+    return host
+}
+
 func (h *UserHandler) SignUpHandler(w http.ResponseWriter, r *http.Request) {
     if !requirePost(w, r) {
         return
@@ -40,22 +59,25 @@ func (h *UserHandler) SignUpHandler(w http.ResponseWriter, r *http.Request) {
     }
     err := h.userService.SignUp(req.Username, req.Email, req.Password)
     if err != nil {
-        utils.WriteJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+        writeServiceError(w, err)
         return
     }
     user, err := h.userService.Authenticate(req.Email, req.Password)
-    if err != nil || user == nil {
-        utils.WriteJSON(w, http.StatusInternalServerError, map[string]string{"error": "could not authenticate user after signup"})
+    if err != nil {
+        writeServiceError(w, err)
         return
     }
-    token, err := utils.GenerateJWT(user.ID.String())
+    _, tokens, err := h.sessionService.CreateSession(user.ID, r.UserAgent(), clientIP(r))
     if err != nil {
-        utils.WriteJSON(w, http.StatusInternalServerError, map[string]string{"error": "could not generate token"})
+        writeServiceError(w, err)
         return
     }
-    // Return both token and user data (id, username, email)
+    // Return both tokens and user data (id, username, email)
     utils.WriteJSON(w, http.StatusCreated, map[string]interface{}{
-        "token": token,
+        "token":              tokens.AccessToken,
+        "expires_at":         tokens.AccessExpiresAt,
+        "refresh_token":      tokens.RefreshToken,
+        "refresh_expires_at": tokens.RefreshExpiresAt,
         "user": map[string]interface{}{
             "id":       user.ID,
             "username": user.Username,
@@ -76,18 +98,21 @@ func (h *UserHandler) LoginHandler(w http.ResponseWriter, r *http.Request) {
         return
     }
     user, err := h.userService.Authenticate(req.Email, req.Password)
-    if err != nil || user == nil {
-        utils.WriteJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid email or password"})
+    if err != nil {
+        writeError(w, http.StatusUnauthorized, "invalid email or password")
         return
     }
-    token, err := utils.GenerateJWT(user.ID.String())
+    _, tokens, err := h.sessionService.CreateSession(user.ID, r.UserAgent(), clientIP(r))
     if err != nil {
-        utils.WriteJSON(w, http.StatusInternalServerError, map[string]string{"error": "could not generate token"})
+        writeServiceError(w, err)
         return
     }
-    // Return both token and user data (id, username, email)
+    // Return both tokens and user data (id, username, email)
     utils.WriteJSON(w, http.StatusOK, map[string]interface{}{
-        "token": token,
+        "token":              tokens.AccessToken,
+        "expires_at":         tokens.AccessExpiresAt,
+        "refresh_token":      tokens.RefreshToken,
+        "refresh_expires_at": tokens.RefreshExpiresAt,
         "user": map[string]interface{}{
             "id":       user.ID,
             "username": user.Username,
@@ -96,69 +121,68 @@ func (h *UserHandler) LoginHandler(w http.ResponseWriter, r *http.Request) {
     })
 }
 
+// GetOnlineUsersHandler returns the connected users who are not invisible,
+// with their status and, where their privacy allows, last seen time
 func (h *UserHandler) GetOnlineUsersHandler(w http.ResponseWriter, r *http.Request) {
-    // JWT check
-    authHeader := r.Header.Get("Authorization")
-    tokenStr := strings.TrimPrefix(authHeader, "Bearer ")
-    _, err := utils.ExtractUserIDFromJWT(tokenStr)
-    if err != nil {
-        utils.WriteJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid or missing token"})
-        return
-    }
     users, err := h.userService.GetOnlineUsers()
     if err != nil {
-        utils.WriteJSON(w, http.StatusInternalServerError, map[string]string{"error": "could not fetch online users"})
+        writeServiceError(w, err)
         return
     }
-    // Optionally, only return ID and Username for privacy
-    var result []map[string]interface{}
-    for _, u := range users {
-        result = append(result, map[string]interface{}{
-            "id":       u.ID,
-            "username": u.Username,
-        })
-    }
-    utils.WriteJSON(w, http.StatusOK, result)
+    h.writePresence(w, r, users)
 }
 
 // GetAllUsersExceptHandler returns all users except the authenticated user
 func (h *UserHandler) GetAllUsersExceptHandler(w http.ResponseWriter, r *http.Request) {
-    // JWT check
-    authHeader := r.Header.Get("Authorization")
-    tokenStr := strings.TrimPrefix(authHeader, "Bearer ")
-    userID, err := utils.ExtractUserIDFromJWT(tokenStr)
+    userID := principalFrom(r).UserID
+    users, err := h.userService.GetAllUsersExcept(userID)
     if err != nil {
-        utils.WriteJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid or missing token"})
+        writeServiceError(w, err)
         return
     }
-    users, err := h.userService.GetAllUsersExcept(userID)
+    h.writePresence(w, r, users)
+}
+
+// Helper: write users as the caller sees their presence
+func (h *UserHandler) writePresence(w http.ResponseWriter, r *http.Request, users []models.User) {
+    result, err := h.presenceService.Describe(principalFrom(r).UserID, users)
     if err != nil {
-        utils.WriteJSON(w, http.StatusInternalServerError, map[string]string{"error": "could not fetch users"})
+        writeServiceError(w, err)
         return
     }
-    // Optionally, only return ID and Username for privacy
-    var result []map[string]interface{}
-    for _, u := range users {
-        result = append(result, map[string]interface{}{
-            "id":       u.ID,
-            "username": u.Username,
-        })
-    }
     utils.WriteJSON(w, http.StatusOK, result)
 }
 
-// MeHandler returns the current authenticated user's data
-func (h *UserHandler) MeHandler(w http.ResponseWriter, r *http.Request) {
-    authHeader := r.Header.Get("Authorization")
-    tokenStr := strings.TrimPrefix(authHeader, "Bearer ")
-    userID, err := utils.ExtractUserIDFromJWT(tokenStr)
+// GetPresenceHandler returns the caller's status, custom status and last-seen privacy
+func (h *UserHandler) GetPresenceHandler(w http.ResponseWriter, r *http.Request) {
+    settings, err := h.presenceService.Settings(principalFrom(r).UserID)
     if err != nil {
-        utils.WriteJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid or missing token"})
+        writeServiceError(w, err)
         return
     }
+    utils.WriteJSON(w, http.StatusOK, settings)
+}
+
+// UpdatePresenceHandler changes the caller's presence settings; omitted fields stay as they are
+func (h *UserHandler) UpdatePresenceHandler(w http.ResponseWriter, r *http.Request) {
+    var req dto.UpdatePresenceRequest
+    if !utils.DecodeJSON(r, &req, w) {
+        return
+    }
+    settings, err := h.presenceService.UpdateSettings(principalFrom(r).UserID, req)
+    if err != nil {
+        writeServiceError(w, err)
+        return
+    }
+    utils.WriteJSON(w, http.StatusOK, settings)
+}
+
+// MeHandler returns the current authenticated user's data
+func (h *UserHandler) MeHandler(w http.ResponseWriter, r *http.Request) {
+    userID := principalFrom(r).UserID
     user, err := h.userService.GetUserByID(userID)
     if err != nil || user == nil {
-        utils.WriteJSON(w, http.StatusNotFound, map[string]string{"error": "user not found"})
+        writeError(w, http.StatusNotFound, "user not found")
         return
     }
     // Optionally, only return ID and Username for privacy
@@ -169,3 +193,84 @@ func (h *UserHandler) MeHandler(w http.ResponseWriter, r *http.Request) {
     }
     utils.WriteJSON(w, http.StatusOK, result)
 }
+
+// RefreshHandler exchanges a refresh token for a new access/refresh token pair
+func (h *UserHandler) RefreshHandler(w http.ResponseWriter, r *http.Request) {
+    if !requirePost(w, r) {
+        return
+    }
+    var req struct {
+        RefreshToken string `json:"refresh_token"`
+    }
+    if !utils.DecodeJSON(r, &req, w) {
+        return
+    }
+    if req.RefreshToken == "" {
+        writeError(w, http.StatusBadRequest, "refresh_token is required")
+        return
+    }
+    tokens, err := h.sessionService.Refresh(req.RefreshToken)
+    if err != nil {
+        writeServiceError(w, err)
+        return
+    }
+    utils.WriteJSON(w, http.StatusOK, map[string]interface{}{
+        "token":              tokens.AccessToken,
+        "expires_at":         tokens.AccessExpiresAt,
+        "refresh_token":      tokens.RefreshToken,
+        "refresh_expires_at": tokens.RefreshExpiresAt,
+    })
+}
+
+// LogoutHandler revokes the session of the token used for this request
+func (h *UserHandler) LogoutHandler(w http.ResponseWriter, r *http.Request) {
+    principal := principalFrom(r)
+    if err := h.sessionService.Logout(principal.SessionID); err != nil {
+        writeServiceError(w, err)
+        return
+    }
+    utils.WriteJSON(w, http.StatusOK, map[string]string{"status": "logged out"})
+}
+
+// LogoutAllHandler revokes every session of the authenticated user
+func (h *UserHandler) LogoutAllHandler(w http.ResponseWriter, r *http.Request) {
+    userID := principalFrom(r).UserID
+    if err := h.sessionService.LogoutAll(userID); err != nil {
+        writeServiceError(w, err)
+        return
+    }
+    utils.WriteJSON(w, http.StatusOK, map[string]string{"status": "logged out everywhere"})
+}
+
+// ListSessionsHandler lists the authenticated user's active devices
+func (h *UserHandler) ListSessionsHandler(w http.ResponseWriter, r *http.Request) {
+    principal := principalFrom(r)
+    sessions, err := h.sessionService.ListSessions(principal.UserID)
+    if err != nil {
+        writeServiceError(w, err)
+        return
+    }
+    result := make([]map[string]interface{}, 0, len(sessions))
+    for _, s := range sessions {
+        result = append(result, map[string]interface{}{
+            "id":           s.ID,
+            "user_agent":   s.UserAgent,
+            "ip_address":   s.IPAddress,
+            "created_at":   s.CreatedAt,
+            "last_used_at": s.LastUsedAt,
+            "expires_at":   s.ExpiresAt,
+            "current":      s.ID.String() == principal.SessionID,
+        })
+    }
+    utils.WriteJSON(w, http.StatusOK, result)
+}
+
+// RevokeSessionHandler revokes one of the authenticated user's sessions by ID
+func (h *UserHandler) RevokeSessionHandler(w http.ResponseWriter, r *http.Request) {
+    userID := principalFrom(r).UserID
+    if err := h.sessionService.RevokeSession(userID, mux.Vars(r)["id"]); err != nil {
+        writeServiceError(w, err)
+        return
+    }
+    utils.WriteJSON(w, http.StatusOK, map[string]string{"status": "revoked"})
+}
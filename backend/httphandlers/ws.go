@@ -1,12 +1,15 @@
 package httphandlers
 
 import (
+	"chatting-service-app/dto"
 	ws "chatting-service-app/websocket"
-	"chatting-service-app/utils"
-	"fmt"
+	"log"
 	"net/http"
 	websocket "github.com/gorilla/websocket"
 	"chatting-service-app/service"
+	"errors"
+
+	"github.com/google/uuid"
 )
 
 var (
@@ -16,55 +19,158 @@ var (
 			return true
 		},
 	}
-	messageServiceGlobal *service.MessageService
 )
 
-func ServeWs(hub *ws.Hub) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		// Extract JWT from query param instead of Authorization header
-		tokenStr := r.URL.Query().Get("token")
-		userID, err := utils.ExtractUserIDFromJWT(tokenStr)
+// RegisterWsHandlers installs the WebSocket frame handlers that need application services
+func RegisterWsHandlers(hub *ws.Hub, messageService *service.MessageService) {
+	// Chat messages are persisted like POST /messages; the sender is always the
+	// authenticated connection, never the payload
+	hub.Handle("message", func(c *ws.Client, env ws.Envelope) (interface{}, error) {
+		var payload ws.ChatMessagePayload
+		if err := ws.DecodePayload(env, &payload); err != nil {
+			return nil, err
+		}
+		senderID, err := uuid.Parse(c.ID)
 		if err != nil {
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
-			return
+			return nil, ws.NewProtocolError(ws.ErrCodeUnauthorized, "invalid sender")
+		}
+		msg, err := messageService.SendMessage(dto.SendMessageRequest{
+			SenderID:        senderID,
+			RecipientID:     payload.RecipientID,
+			ConversationID:  payload.ConversationID,
+			Content:         payload.Content,
+			MediaURL:        payload.MediaURL,
+			IsBroadcast:     payload.IsBroadcast,
+			ClientMessageID: payload.ClientMessageID,
+			ReplyToID:       payload.ReplyToID,
+			ThreadRootID:    payload.ThreadRootID,
+		}, c)
+		if err != nil {
+			return nil, wsServiceError(err)
+		}
+		return map[string]interface{}{
+			"id":            msg.ID,
+			"created_at":    msg.CreatedAt,
+			"client_msg_id": payload.ClientMessageID,
+		}, nil
+	})
+	// sync returns everything the user sent or received after a cursor, across all conversations
+	hub.Handle("sync", func(c *ws.Client, env ws.Envelope) (interface{}, error) {
+		var payload ws.SyncPayload
+		if err := ws.DecodePayload(env, &payload); err != nil {
+			return nil, err
+		}
+		since, err := dto.DecodeCursor(payload.Since)
+		if err != nil {
+			return nil, ws.NewProtocolError(ws.ErrCodeInvalidPayload, "since must be a message cursor")
 		}
+		if payload.Limit < 0 || payload.Limit > dto.MaxPageLimit {
+			return nil, ws.NewProtocolError(ws.ErrCodeInvalidPayload, "limit must be between 0 and %d; 0 means the default of %d", dto.MaxPageLimit, dto.DefaultPageLimit)
+		}
+		page, err := messageService.GetAllMessagesForUser(c.ID, c.ID, dto.PageRequest{After: since, Limit: payload.Limit})
+		if err != nil {
+			return nil, wsServiceError(err)
+		}
+		c.SendFrame("sync", env.ID, page)
+		return nil, nil
+	})
+	hub.Handle("delivered", func(c *ws.Client, env ws.Envelope) (interface{}, error) {
+		var payload ws.ReceiptPayload
+		if err := ws.DecodePayload(env, &payload); err != nil {
+			return nil, err
+		}
+		if err := messageService.SetDeliveredAt(payload.MessageID.String(), c.ID); err != nil {
+			return nil, wsServiceError(err)
+		}
+		return nil, nil
+	})
+	// read_up_to marks everything up to the message read with one frame
+	hub.Handle("read_up_to", func(c *ws.Client, env ws.Envelope) (interface{}, error) {
+		var payload ws.ReceiptPayload
+		if err := ws.DecodePayload(env, &payload); err != nil {
+			return nil, err
+		}
+		read, err := messageService.ReadUpTo(c.ID, payload.MessageID.String(), c)
+		if err != nil {
+			return nil, wsServiceError(err)
+		}
+		return map[string]interface{}{"message_ids": read}, nil
+	})
+	// typing_start and typing_stop are relayed to the chat's peers and never stored
+	hub.Handle("typing_start", func(c *ws.Client, env ws.Envelope) (interface{}, error) {
+		var payload ws.TypingPayload
+		if err := decodeTyping(c, env, &payload); err != nil {
+			return nil, err
+		}
+		err := c.StartTyping(payload, func() ([]string, error) {
+			return messageService.TypingPeers(c.ID, payload)
+		})
+		if err != nil {
+			return nil, wsServiceError(err)
+		}
+		return nil, nil
+	})
+	hub.Handle("typing_stop", func(c *ws.Client, env ws.Envelope) (interface{}, error) {
+		var payload ws.TypingPayload
+		if err := decodeTyping(c, env, &payload); err != nil {
+			return nil, err
+		}
+		c.StopTyping(payload)
+		return nil, nil
+	})
+	hub.Handle("read", func(c *ws.Client, env ws.Envelope) (interface{}, error) {
+		var payload ws.ReceiptPayload
+		if err := ws.DecodePayload(env, &payload); err != nil {
+			return nil, err
+		}
+		if err := messageService.SetReadAt(payload.MessageID.String(), c.ID, c); err != nil {
+			return nil, wsServiceError(err)
+		}
+		return nil, nil
+	})
+}
+
+// decodeTyping applies the typing rate limit before decoding the payload
+func decodeTyping(c *ws.Client, env ws.Envelope, payload *ws.TypingPayload) error {
+	if !c.AllowTyping() {
+		return ws.NewProtocolError(ws.ErrCodeRateLimited, "too many typing frames")
+	}
+	return ws.DecodePayload(env, payload)
+}
+
+// wsServiceError maps service sentinel errors to protocol error codes; other
+// errors are logged and reported as internal_error without their text
+func wsServiceError(err error) error {
+	var perr *ws.ProtocolError
+	switch {
+	case errors.As(err, &perr):
+		return perr
+	case errors.Is(err, service.ErrUnauthorized):
+		return ws.NewProtocolError(ws.ErrCodeUnauthorized, "%s", err.Error())
+	case errors.Is(err, service.ErrForbidden):
+		return ws.NewProtocolError(ws.ErrCodeForbidden, "%s", err.Error())
+	case errors.Is(err, service.ErrNotFound), errors.Is(err, service.ErrInvalid):
+		return ws.NewProtocolError(ws.ErrCodeInvalidPayload, "%s", err.Error())
+	}
+	log.Printf("ws: internal error: %v", err)
+	return ws.NewProtocolError(ws.ErrCodeInternal, "internal server error")
+}
+
+func ServeWs(hub *ws.Hub) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Authenticated by requireAuthQuery from the token query parameter
+		principal := principalFrom(r)
+		userID := principal.UserID
 
 		conn, err := upgrader.Upgrade(w, r, nil)
 		if err != nil {
 			http.Error(w, "Could not upgrade to websocket", http.StatusInternalServerError)
 			return
 		}
-		fmt.Println("WebSocket: Connection upgraded for userIDD:", userID)
 
-		client := &ws.Client{
-			Hub:  hub,
-			Conn: conn,
-			Send: make(chan []byte, 256),
-			ID:   userID,
-		}
+		client := hub.NewClient(conn, userID, principal.SessionID)
+		client.SetAuthExpiry(principal.ExpiresAt)
 		hub.Register(client)
-		fmt.Println("WebSocket: Client registered for userIDDD:", userID)
-
-		// Wire up delivery/read status callbacks
-		ws.OnMessageDelivered = func(messageID, recipientID string) {
-			if messageServiceGlobal != nil {
-				_ = messageServiceGlobal.SetDeliveredAt(messageID, recipientID)
-			}
-		}
-		ws.OnMessageRead = func(messageID, recipientID string) {
-			if messageServiceGlobal != nil {
-				_ = messageServiceGlobal.SetReadAt(messageID, recipientID)
-			}
-		}
-
-		defer func() {
-			if r := recover(); r != nil {
-				fmt.Printf("WebSocket: Panic recovered for userID %s: %v\n", userID, r)
-				if conn != nil {
-					conn.Close()
-				}
-			}
-		}()
 
 		// Start pumps
 		go client.WritePump()
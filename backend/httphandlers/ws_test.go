@@ -0,0 +1,143 @@
+package httphandlers
+
+import (
+	"errors"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"chatting-service-app/utils"
+	ws "chatting-service-app/websocket"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+)
+
+// newWsServer serves ServeWs from an in-process server, behind the same
+// token check as the /ws route
+func newWsServer(t *testing.T, cfg ws.ConnConfig) (*ws.Hub, string) {
+	t.Helper()
+	hub := ws.NewHub(nil)
+	hub.SetConnConfig(cfg)
+	go hub.Run()
+	srv := httptest.NewServer(requireAuthQuery(ServeWs(hub)))
+	t.Cleanup(srv.Close)
+	return hub, "ws" + strings.TrimPrefix(srv.URL, "http")
+}
+
+// dialWs connects as userID with a freshly signed access token
+func dialWs(t *testing.T, url, userID string) *websocket.Conn {
+	t.Helper()
+	token, err := utils.GenerateJWT(userID, "session", time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, _, err := websocket.DefaultDialer.Dial(url+"?token="+token, nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// readUntilError reads frames until the connection fails and returns that error
+func readUntilError(conn *websocket.Conn, timeout time.Duration) error {
+	_ = conn.SetReadDeadline(time.Now().Add(timeout))
+	for {
+		if _, _, err := conn.ReadMessage(); err != nil {
+			return err
+		}
+	}
+}
+
+// isTimeout reports whether err is our own read deadline passing, which means
+// the server kept the connection open
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+func TestServeWsKeepsPeersThatAnswerPings(t *testing.T) {
+	cfg := ws.DefaultConnConfig()
+	cfg.PongWait = 300 * time.Millisecond
+	cfg.PingPeriod = 100 * time.Millisecond
+	_, url := newWsServer(t, cfg)
+	conn := dialWs(t, url, uuid.NewString())
+
+	pings := make(chan struct{}, 64)
+	conn.SetPingHandler(func(data string) error {
+		pings <- struct{}{}
+		return conn.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(time.Second))
+	})
+	// The ping handler only runs while reading
+	if err := readUntilError(conn, 4*cfg.PongWait); !isTimeout(err) {
+		t.Fatalf("got %v, want the answering peer kept", err)
+	}
+	if len(pings) < 3 {
+		t.Fatalf("got %d pings, want at least 3", len(pings))
+	}
+}
+
+func TestServeWsDropsPeersThatStopAnsweringPings(t *testing.T) {
+	cfg := ws.DefaultConnConfig()
+	cfg.PongWait = 300 * time.Millisecond
+	cfg.PingPeriod = 100 * time.Millisecond
+	_, url := newWsServer(t, cfg)
+	conn := dialWs(t, url, uuid.NewString())
+
+	// A half-open peer: it receives pings but never answers them
+	conn.SetPingHandler(func(string) error { return nil })
+	start := time.Now()
+	if err := readUntilError(conn, 5*time.Second); isTimeout(err) {
+		t.Fatal("connection stayed open")
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("peer dropped after %s, want about the pong wait", elapsed)
+	}
+}
+
+func TestServeWsClosesOversizedFrames(t *testing.T) {
+	cfg := ws.DefaultConnConfig()
+	cfg.MaxMessageSize = 128
+	_, url := newWsServer(t, cfg)
+	conn := dialWs(t, url, uuid.NewString())
+
+	// Within the limit the frame is handled normally
+	small := `{"v":1,"type":"ping","id":"p1","ack":true}`
+	if err := conn.WriteMessage(websocket.TextMessage, []byte(small)); err != nil {
+		t.Fatal(err)
+	}
+	big := `{"v":1,"type":"ping","payload":{"pad":"` + strings.Repeat("x", 1024) + `"}}`
+	if err := conn.WriteMessage(websocket.TextMessage, []byte(big)); err != nil {
+		t.Fatal(err)
+	}
+	err := readUntilError(conn, 2*time.Second)
+	if !websocket.IsCloseError(err, websocket.CloseMessageTooBig) {
+		t.Fatalf("got %v, want close code %d", err, websocket.CloseMessageTooBig)
+	}
+}
+
+func TestServeWsDropsPeersThatStopReading(t *testing.T) {
+	cfg := ws.DefaultConnConfig()
+	cfg.WriteWait = 200 * time.Millisecond
+	hub, url := newWsServer(t, cfg)
+	alice := uuid.NewString()
+	conn := dialWs(t, url, alice)
+	// Let the hub register the connection before sending to it
+	time.Sleep(100 * time.Millisecond)
+
+	// Enough data to fill the socket buffers on both ends, but fewer frames
+	// than the send queue holds, so only the write timeout can end the connection
+	frame := ws.NewFrame("message", map[string]string{"content": strings.Repeat("x", 256*1024)})
+	for i := 0; i < 100; i++ {
+		hub.SendDirect(alice, frame)
+	}
+	// Well past the write wait
+	time.Sleep(time.Second)
+	// Reading now only drains what was buffered before the server gave up
+	if err := readUntilError(conn, 5*time.Second); isTimeout(err) {
+		t.Fatal("the stalled peer was kept")
+	}
+}
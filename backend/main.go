@@ -4,71 +4,127 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"os"
 
 	"github.com/gorilla/handlers"
 
 	"chatting-service-app/db"
 	"chatting-service-app/httphandlers"
-	"chatting-service-app/models"
 	"chatting-service-app/repository"
 	"chatting-service-app/service"
+	"chatting-service-app/storage"
+	"chatting-service-app/utils"
 	"chatting-service-app/websocket"
 )
 
-var messageServiceGlobal *service.MessageService
-
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		os.Exit(runMigrateCommand(os.Args[2:]))
+	}
+
 	// Connect to the database
 	_, err := db.ConnectDB()
 	if err != nil {
 		log.Fatal("Failed to connect to DB:", err)
 	}
 
-	// Drop all tables using GORM for testing and development purposes
-	err = db.DB.Migrator().DropTable(&models.MessageRecipient{}, &models.Message{}, &models.User{}, &models.Session{})
-	if err != nil {
-	    log.Fatal("Failed to drop tables:", err)
-	}
-
-	// GORM AutoMigrate for your models
-	err = db.DB.AutoMigrate(
-		&models.User{},
-		&models.Message{},
-		&models.MessageRecipient{},
-		&models.Session{},
-	)
-	if err != nil {
-		log.Fatal("Failed to migrate tables:", err)
+	// Apply pending schema migrations; set AUTO_MIGRATE=false to manage them
+	// exclusively through `main migrate up`
+	if os.Getenv("AUTO_MIGRATE") != "false" {
+		applied, err := db.MigrateUp()
+		if err != nil {
+			log.Fatal("Failed to migrate database:", err)
+		}
+		for _, m := range applied {
+			fmt.Printf("Applied migration %04d_%s\n", m.Version, m.Name)
+		}
 	}
 
 	// Set up repository, service, and handler
 	userRepo := repository.NewUserRepository()
 	userService := service.NewUserService(userRepo)
-	userHandler := httphandlers.NewUserHandler(userService)
 
 	// Message recipient repository and service
 	messageRecipientRepo := repository.NewMessageRecipientRepository()
 	messageRecipientService := service.NewMessageRecipientService(messageRecipientRepo, userRepo)
 
-	// Message repository, service, and handler
-	messageRepo := repository.NewMessageRepository()
-	messageService := service.NewMessageService(messageRepo, nil, messageRecipientService)
-	messageServiceGlobal = messageService
-	messageHandler := httphandlers.NewMessageHandler(messageService, messageRecipientService)
-
 	// Start the WebSocket hub
 	hub := websocket.NewHub(userService) // userService implements OnlineStatusSetter
+	hub.SetConnConfig(websocket.ConnConfigFromEnv())
+	broker, err := newHubBroker()
+	if err != nil {
+		log.Fatal("Failed to start hub broker:", err)
+	}
+	defer broker.Close()
+	if err := hub.SetBroker(broker); err != nil {
+		log.Fatal("Failed to subscribe hub broker:", err)
+	}
+	// Without a shared broker this is the only instance, so presence left by a
+	// previous run is cleared right away instead of when its leases expire
+	if err := hub.SetPresenceStore(userService, os.Getenv("HUB_BROKER") != "postgres"); err != nil {
+		log.Fatal("Failed to reconcile presence:", err)
+	}
 	go hub.Run()
 
-	router := httphandlers.SetupRouter(userHandler, hub, messageHandler, messageRecipientService)
+	// Session repository and service; every JWT is checked against its session
+	sessionRepo := repository.NewSessionRepository()
+	refreshTokenRepo := repository.NewRefreshTokenRepository()
+	sessionService := service.NewSessionService(sessionRepo, refreshTokenRepo, hub)
+	utils.SessionValidator = sessionService.ValidateSession
+	presenceService := service.NewPresenceService(userRepo, hub)
+	userHandler := httphandlers.NewUserHandler(userService, sessionService, presenceService)
+
+	// Group conversation repository, service, and handler
+	conversationRepo := repository.NewConversationRepository()
+	conversationService := service.NewConversationService(conversationRepo, userRepo, hub)
+	conversationHandler := httphandlers.NewConversationHandler(conversationService, userService)
+
+	// Message repository, service, and handler
+	messageRepo := repository.NewMessageRepository()
+	attachmentRepo := repository.NewAttachmentRepository()
+	policy := service.NewPolicy(messageRepo, attachmentRepo, userRepo, conversationService)
+	messageService := service.NewMessageService(messageRepo, userRepo, hub, messageRecipientService, conversationService, policy)
+	httphandlers.RegisterWsHandlers(hub, messageService)
+	hub.SetMessageStore(messageService)
+	messageHandler := httphandlers.NewMessageHandler(messageService)
+	objectStore, err := newObjectStore()
+	if err != nil {
+		log.Fatal("Failed to open object store:", err)
+	}
+	attachmentHandler := httphandlers.NewAttachmentHandler(service.NewAttachmentService(attachmentRepo, objectStore, policy))
+
+	router := httphandlers.SetupRouter(userHandler, hub, messageHandler, conversationHandler, attachmentHandler)
 
 	// Add CORS middleware
 	h := handlers.CORS(
 		handlers.AllowedOrigins([]string{"*"}),
-		handlers.AllowedMethods([]string{"GET", "POST", "PUT", "DELETE", "OPTIONS"}),
+		handlers.AllowedMethods([]string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"}),
 		handlers.AllowedHeaders([]string{"Authorization", "Content-Type"}),
 	)(router)
 
 	fmt.Println("Server running on http://localhost:8080")
 	log.Fatal(http.ListenAndServe(":8080", h))
 }
+
+// newHubBroker picks how hubs of several instances talk to each other:
+// HUB_BROKER=postgres uses LISTEN/NOTIFY, anything else stays in-process
+func newHubBroker() (websocket.Broker, error) {
+	if os.Getenv("HUB_BROKER") == "postgres" {
+		return websocket.NewPostgresBroker(db.DSN())
+	}
+	return websocket.NewMemoryBroker(), nil
+}
+
+// newObjectStore picks where attachments are kept: STORAGE_BACKEND=s3 uses an
+// S3-compatible bucket configured by the S3_* variables, anything else the
+// directory in STORAGE_DIR (default uploads)
+func newObjectStore() (storage.ObjectStore, error) {
+	if os.Getenv("STORAGE_BACKEND") == "s3" {
+		return storage.NewS3Store(storage.S3ConfigFromEnv(), nil)
+	}
+	dir := os.Getenv("STORAGE_DIR")
+	if dir == "" {
+		dir = "uploads"
+	}
+	return storage.NewLocalStore(dir)
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+
+	"chatting-service-app/db"
+)
+
+const migrateUsage = "usage: main migrate up | down [steps] | status"
+
+// runMigrateCommand handles `main migrate up|down|status` and returns the process exit code
+func runMigrateCommand(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, migrateUsage)
+		return 2
+	}
+
+	if _, err := db.ConnectDB(); err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to connect to DB:", err)
+		return 1
+	}
+
+	switch args[0] {
+	case "up":
+		applied, err := db.MigrateUp()
+		for _, m := range applied {
+			fmt.Printf("Applied %04d_%s\n", m.Version, m.Name)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		if len(applied) == 0 {
+			fmt.Println("No pending migrations")
+		}
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			n, err := strconv.Atoi(args[1])
+			if err != nil || n < 1 {
+				fmt.Fprintln(os.Stderr, "steps must be a positive integer")
+				return 2
+			}
+			steps = n
+		}
+		rolledBack, err := db.MigrateDown(steps)
+		for _, m := range rolledBack {
+			fmt.Printf("Rolled back %04d_%s\n", m.Version, m.Name)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		if len(rolledBack) == 0 {
+			fmt.Println("Nothing to roll back")
+		}
+	case "status":
+		statuses, err := db.MigrationStatuses()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		for _, s := range statuses {
+			if s.Applied {
+				fmt.Printf("[x] %04d_%s (applied %s)\n", s.Version, s.Name, s.AppliedAt.Format("2006-01-02 15:04:05"))
+			} else {
+				fmt.Printf("[ ] %04d_%s\n", s.Version, s.Name)
+			}
+		}
+	default:
+		fmt.Fprintln(os.Stderr, migrateUsage)
+		return 2
+	}
+	return 0
+}
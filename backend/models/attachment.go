@@ -0,0 +1,18 @@
+package models
+
+import (
+    "time"
+    "github.com/google/uuid"
+)
+
+// Attachment is an uploaded file kept in the object store under ObjectKey.
+// Its owner and the participants of the messages that carry it may download it.
+type Attachment struct {
+    ID          uuid.UUID `gorm:"type:uuid;default:gen_random_uuid();primaryKey"`
+    OwnerID     uuid.UUID `gorm:"type:uuid"`
+    ObjectKey   string    `json:"-"`
+    FileName    string
+    ContentType string
+    Size        int64
+    CreatedAt   time.Time
+}
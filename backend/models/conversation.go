@@ -0,0 +1,29 @@
+package models
+
+import (
+    "time"
+    "github.com/google/uuid"
+)
+
+// Roles a user can hold inside a group conversation
+const (
+    ConversationRoleOwner  = "owner"
+    ConversationRoleAdmin  = "admin"
+    ConversationRoleMember = "member"
+)
+
+type Conversation struct {
+    ID        uuid.UUID `gorm:"type:uuid;default:gen_random_uuid();primaryKey"`
+    Name      string    `gorm:"not null"`
+    CreatedBy uuid.UUID
+    CreatedAt time.Time
+    UpdatedAt time.Time
+}
+
+type ConversationMember struct {
+    ID             uuid.UUID `gorm:"type:uuid;default:gen_random_uuid();primaryKey"`
+    ConversationID uuid.UUID
+    UserID         uuid.UUID
+    Role           string
+    JoinedAt       time.Time
+}
@@ -6,11 +6,27 @@ import (
 )
 
 type Message struct {
-    ID          uuid.UUID `gorm:"type:uuid;default:gen_random_uuid();primaryKey"`
-    SenderID    uuid.UUID
-    RecipientID uuid.UUID // Add this field for 1:1 messaging
-    Content     string
-    MediaURL    string
-    IsBroadcast bool
-    CreatedAt   time.Time
+    ID              uuid.UUID  `gorm:"type:uuid;default:gen_random_uuid();primaryKey"`
+    SenderID        uuid.UUID
+    RecipientID     uuid.UUID  // Add this field for 1:1 messaging
+    ConversationID  *uuid.UUID `gorm:"type:uuid"` // Set for group conversation messages
+    ReplyToID       *uuid.UUID `gorm:"type:uuid"` // The quoted message, if any
+    ThreadRootID    *uuid.UUID `gorm:"type:uuid"` // The message whose thread this reply belongs to
+    Content         string
+    MediaURL        string     // /attachments/{id} of AttachmentID; clients exchange it for a signed URL
+    AttachmentID    *uuid.UUID `gorm:"type:uuid"`
+    IsBroadcast     bool
+    CreatedAt       time.Time
+    EditedAt        *time.Time
+    // DeletedAt marks a tombstone: the sender deleted the message for everyone and its content is gone
+    DeletedAt       *time.Time
+    // SenderHiddenAt is set when the sender deleted the message for themselves only; never sent to clients
+    SenderHiddenAt  *time.Time `json:"-"`
+    // ClientMessageID is the sender's idempotency key; retries with the same key return the original message
+    ClientMessageID *string    `gorm:"column:client_message_id"`
+    // Reactions, ReplyCount and Statuses are filled in for history responses only.
+    // Statuses lists every recipient to the sender and only their own row to a recipient.
+    Reactions       []ReactionCount   `gorm:"-"`
+    ReplyCount      int               `gorm:"-"`
+    Statuses        []RecipientStatus `gorm:"-"`
 }
@@ -0,0 +1,14 @@
+package models
+
+import (
+    "time"
+    "github.com/google/uuid"
+)
+
+// MessageEdit keeps the content a message had before one edit
+type MessageEdit struct {
+    ID              uuid.UUID `gorm:"type:uuid;default:gen_random_uuid();primaryKey"`
+    MessageID       uuid.UUID `gorm:"type:uuid"`
+    PreviousContent string
+    EditedAt        time.Time
+}
@@ -0,0 +1,24 @@
+package models
+
+import (
+    "time"
+    "github.com/google/uuid"
+)
+
+// MessageReaction is one user's emoji on a message; a user may add several
+// different emoji to the same message
+type MessageReaction struct {
+    ID        uuid.UUID `gorm:"type:uuid;default:gen_random_uuid();primaryKey"`
+    MessageID uuid.UUID `gorm:"type:uuid"`
+    UserID    uuid.UUID `gorm:"type:uuid"`
+    Emoji     string
+    CreatedAt time.Time
+}
+
+// ReactionCount aggregates one emoji on a message; Reacted tells whether the
+// user viewing the history is among those who added it
+type ReactionCount struct {
+    Emoji   string
+    Count   int
+    Reacted bool
+}
@@ -11,4 +11,13 @@ type MessageRecipient struct {
     RecipientID uuid.UUID
     DeliveredAt *time.Time
     ReadAt      *time.Time
+    // HiddenAt is set when the recipient deleted the message for themselves
+    HiddenAt    *time.Time
+}
+
+// RecipientStatus is one recipient's delivery and read state of a message
+type RecipientStatus struct {
+    RecipientID uuid.UUID
+    DeliveredAt *time.Time
+    ReadAt      *time.Time
 }
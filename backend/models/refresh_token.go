@@ -0,0 +1,20 @@
+package models
+
+import (
+    "time"
+    "github.com/google/uuid"
+)
+
+// RefreshToken is one link in a rotation chain. Every token issued for a login
+// shares the session ID as its family; only the SHA-256 hash of the opaque
+// token is stored.
+type RefreshToken struct {
+    ID        uuid.UUID  `gorm:"type:uuid;default:gen_random_uuid();primaryKey"`
+    SessionID uuid.UUID
+    TokenHash string     `gorm:"unique;not null"`
+    ParentID  *uuid.UUID `gorm:"type:uuid"`
+    ExpiresAt time.Time
+    CreatedAt time.Time
+    UsedAt    *time.Time
+    RevokedAt *time.Time
+}
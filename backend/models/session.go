@@ -5,10 +5,15 @@ import (
     "github.com/google/uuid"
 )
 
+// Session is one logged-in device. Its ID is embedded in the JWT as the jti
+// claim, so revoking the row invalidates the token before it expires.
 type Session struct {
-    ID        uuid.UUID `gorm:"type:uuid;default:gen_random_uuid();primaryKey"`
-    UserID    uuid.UUID
-    Token     string
-    ExpiresAt time.Time
-    CreatedAt time.Time
+    ID         uuid.UUID `gorm:"type:uuid;default:gen_random_uuid();primaryKey"`
+    UserID     uuid.UUID
+    UserAgent  string
+    IPAddress  string
+    ExpiresAt  time.Time
+    CreatedAt  time.Time
+    LastUsedAt *time.Time
+    RevokedAt  *time.Time
 }
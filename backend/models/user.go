@@ -5,11 +5,36 @@ import (
     "github.com/google/uuid"
 )
 
+// Statuses a user can choose; PresenceOffline is only ever shown, never chosen
+const (
+    PresenceOnline       = "online"
+    PresenceAway         = "away"
+    PresenceDoNotDisturb = "dnd"
+    PresenceInvisible    = "invisible"
+    PresenceOffline      = "offline"
+)
+
+// Who may see a user's last_seen_at
+const (
+    LastSeenEveryone = "everyone"
+    LastSeenContacts = "contacts"
+    LastSeenNobody   = "nobody"
+)
+
 type User struct {
-    ID        uuid.UUID `gorm:"type:uuid;default:gen_random_uuid();primaryKey"`
-    Username  string    `gorm:"unique;not null"`
-    Password  string    `gorm:"not null"`
-    Email     string    `gorm:"unique"`
-    IsOnline  bool
-    CreatedAt time.Time
+    ID                    uuid.UUID `gorm:"type:uuid;default:gen_random_uuid();primaryKey"`
+    Username              string    `gorm:"unique;not null"`
+    Password              string    `gorm:"not null"`
+    Email                 string    `gorm:"unique"`
+    IsOnline              bool
+    // IsIdle is set while every connection of an online user is idle
+    IsIdle                bool
+    // LastSeenAt is when the user last connected or disconnected; it does not move while invisible
+    LastSeenAt            *time.Time
+    // PresenceStatus is the status the user chose; invisible users appear offline
+    PresenceStatus        string     `gorm:"not null;default:online"`
+    CustomStatus          string
+    CustomStatusExpiresAt *time.Time
+    LastSeenVisibility    string     `gorm:"not null;default:everyone"`
+    CreatedAt             time.Time
 }
@@ -0,0 +1,28 @@
+package repository
+
+import (
+    "chatting-service-app/db"
+    "chatting-service-app/models"
+    "errors"
+
+    "gorm.io/gorm"
+)
+
+type AttachmentRepository struct{}
+
+func NewAttachmentRepository() *AttachmentRepository {
+    return &AttachmentRepository{}
+}
+
+func (r *AttachmentRepository) Create(attachment *models.Attachment) error {
+    return db.DB.Create(attachment).Error
+}
+
+func (r *AttachmentRepository) GetByID(id string) (*models.Attachment, error) {
+    var attachment models.Attachment
+    err := db.DB.Where("id = ?", id).First(&attachment).Error
+    if errors.Is(err, gorm.ErrRecordNotFound) {
+        return nil, nil
+    }
+    return &attachment, err
+}
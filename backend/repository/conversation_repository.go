@@ -0,0 +1,218 @@
+package repository
+
+import (
+    "chatting-service-app/dto"
+    "chatting-service-app/models"
+    "chatting-service-app/db"
+    "errors"
+    "github.com/google/uuid"
+    "gorm.io/gorm"
+    "time"
+)
+
+type ConversationRepository struct {}
+
+func NewConversationRepository() *ConversationRepository {
+    return &ConversationRepository{}
+}
+
+// Create stores the conversation and its initial members in one transaction
+func (r *ConversationRepository) Create(conv *models.Conversation, members []models.ConversationMember) error {
+    return db.DB.Transaction(func(tx *gorm.DB) error {
+        if err := tx.Create(conv).Error; err != nil {
+            return err
+        }
+        for i := range members {
+            members[i].ConversationID = conv.ID
+        }
+        if len(members) == 0 {
+            return nil
+        }
+        return tx.Create(&members).Error
+    })
+}
+
+func (r *ConversationRepository) GetByID(conversationID string) (*models.Conversation, error) {
+    var conv models.Conversation
+    err := db.DB.Where("id = ?", conversationID).First(&conv).Error
+    if errors.Is(err, gorm.ErrRecordNotFound) {
+        return nil, nil
+    }
+    return &conv, err
+}
+
+func (r *ConversationRepository) Rename(conversationID, name string) error {
+    return db.DB.Model(&models.Conversation{}).
+        Where("id = ?", conversationID).
+        Updates(map[string]interface{}{"name": name, "updated_at": time.Now()}).Error
+}
+
+func (r *ConversationRepository) GetMembers(conversationID string) ([]models.ConversationMember, error) {
+    var members []models.ConversationMember
+    err := db.DB.Where("conversation_id = ?", conversationID).Order("joined_at asc").Find(&members).Error
+    return members, err
+}
+
+func (r *ConversationRepository) GetMember(conversationID, userID string) (*models.ConversationMember, error) {
+    var member models.ConversationMember
+    err := db.DB.Where("conversation_id = ? AND user_id = ?", conversationID, userID).First(&member).Error
+    if errors.Is(err, gorm.ErrRecordNotFound) {
+        return nil, nil
+    }
+    return &member, err
+}
+
+func (r *ConversationRepository) AddMembers(members []models.ConversationMember) error {
+    if len(members) == 0 {
+        return nil
+    }
+    return db.DB.Create(&members).Error
+}
+
+func (r *ConversationRepository) RemoveMember(conversationID, userID string) error {
+    return db.DB.Where("conversation_id = ? AND user_id = ?", conversationID, userID).
+        Delete(&models.ConversationMember{}).Error
+}
+
+func (r *ConversationRepository) SetMemberRole(conversationID, userID, role string) error {
+    return db.DB.Model(&models.ConversationMember{}).
+        Where("conversation_id = ? AND user_id = ?", conversationID, userID).
+        Update("role", role).Error
+}
+
+// conversationSummaries lists the user's conversations: every direct chat they
+// have a visible message in, keyed by the peer, and every group they belong
+// to. The sender's own row of a broadcast has no recipient and is left out;
+// the per-recipient copies count as direct chats. chat_id narrows the list to
+// one conversation before anything is aggregated, so loading a single entry
+// does not scan the user's other chats; before continues a page.
+// Unread counts cover messages addressed to the user that they have not read,
+// hidden or seen deleted.
+const conversationSummaries = `
+WITH visible AS (
+    SELECT m.id, m.sender_id, m.conversation_id, m.created_at, m.deleted_at,
+        COALESCE(m.conversation_id, CASE WHEN m.sender_id = @user THEN m.recipient_id ELSE m.sender_id END) AS chat_id
+    FROM messages m
+    WHERE (m.conversation_id IN (SELECT conversation_id FROM conversation_members WHERE user_id = @user)
+            OR (m.conversation_id IS NULL AND (m.sender_id = @user OR m.recipient_id = @user)))
+        AND (m.conversation_id IS NOT NULL OR m.recipient_id <> '00000000-0000-0000-0000-000000000000')
+        AND NOT (m.sender_id = @user AND m.sender_hidden_at IS NOT NULL)
+        AND NOT EXISTS (SELECT 1 FROM message_recipients mr WHERE mr.message_id = m.id AND mr.recipient_id = @user AND mr.hidden_at IS NOT NULL)
+        AND (CAST(@chat_id AS uuid) IS NULL
+            OR m.conversation_id = @chat_id
+            OR (m.conversation_id IS NULL AND ((m.sender_id = @user AND m.recipient_id = @chat_id) OR (m.sender_id = @chat_id AND m.recipient_id = @user))))
+),
+latest AS (
+    SELECT DISTINCT ON (chat_id) chat_id, id, conversation_id, created_at
+    FROM visible
+    ORDER BY chat_id, created_at DESC, id DESC
+),
+unread AS (
+    SELECT v.chat_id, COUNT(*) AS unread_count
+    FROM visible v
+    JOIN message_recipients mr ON mr.message_id = v.id AND mr.recipient_id = @user
+    WHERE mr.read_at IS NULL AND v.sender_id <> @user AND v.deleted_at IS NULL
+    GROUP BY v.chat_id
+),
+chats AS (
+    SELECT CASE WHEN conversation_id IS NULL THEN 'direct' ELSE 'group' END AS type,
+        chat_id AS id, created_at AS last_activity_at, id AS last_message_id
+    FROM latest
+    UNION ALL
+    SELECT 'group', c.id, c.created_at, NULL
+    FROM conversations c
+    JOIN conversation_members cm ON cm.conversation_id = c.id AND cm.user_id = @user
+    WHERE NOT EXISTS (SELECT 1 FROM latest WHERE latest.chat_id = c.id)
+        AND (CAST(@chat_id AS uuid) IS NULL OR c.id = @chat_id)
+)
+SELECT chats.type, chats.id, COALESCE(c.name, u.username, '') AS name,
+    chats.last_activity_at, chats.last_message_id, COALESCE(unread.unread_count, 0) AS unread_count
+FROM chats
+LEFT JOIN conversations c ON chats.type = 'group' AND c.id = chats.id
+LEFT JOIN users u ON chats.type = 'direct' AND u.id = chats.id
+LEFT JOIN unread ON unread.chat_id = chats.id
+WHERE (CAST(@before_at AS timestamptz) IS NULL OR (chats.last_activity_at, chats.id) < (@before_at, @before_id))
+ORDER BY chats.last_activity_at DESC, chats.id DESC
+LIMIT @limit`
+
+// ListSummaries pages through the user's conversations, most recently active first
+func (r *ConversationRepository) ListSummaries(userID string, before *dto.Cursor, limit int) (dto.ConversationPage, error) {
+    if limit <= 0 || limit > dto.MaxPageLimit {
+        limit = dto.DefaultPageLimit
+    }
+    args := map[string]interface{}{"user": userID, "chat_id": nil, "before_at": nil, "before_id": nil, "limit": limit + 1}
+    if before != nil {
+        args["before_at"] = before.CreatedAt
+        args["before_id"] = before.ID
+    }
+    summaries, err := loadSummaries(args)
+    if err != nil {
+        return dto.ConversationPage{}, err
+    }
+    page := dto.ConversationPage{HasMore: len(summaries) > limit, Conversations: summaries}
+    if page.HasMore {
+        page.Conversations = summaries[:limit]
+    }
+    if n := len(page.Conversations); n > 0 {
+        last := page.Conversations[n-1]
+        page.NextCursor = dto.Cursor{CreatedAt: last.LastActivityAt, ID: last.ID}.Encode()
+    }
+    return page, nil
+}
+
+// GetSummary returns one entry of the user's conversation list, or nil if the
+// user takes no part in that conversation
+func (r *ConversationRepository) GetSummary(userID string, chatID uuid.UUID) (*dto.ConversationSummary, error) {
+    args := map[string]interface{}{"user": userID, "chat_id": chatID, "before_at": nil, "before_id": nil, "limit": 1}
+    summaries, err := loadSummaries(args)
+    if err != nil || len(summaries) == 0 {
+        return nil, err
+    }
+    return &summaries[0], nil
+}
+
+// loadSummaries runs conversationSummaries and fills in the last messages
+func loadSummaries(args map[string]interface{}) ([]dto.ConversationSummary, error) {
+    var rows []struct {
+        Type           string
+        ID             uuid.UUID
+        Name           string
+        LastActivityAt time.Time
+        LastMessageID  *uuid.UUID
+        UnreadCount    int
+    }
+    if err := db.DB.Raw(conversationSummaries, args).Scan(&rows).Error; err != nil {
+        return nil, err
+    }
+    var messageIDs []uuid.UUID
+    for _, row := range rows {
+        if row.LastMessageID != nil {
+            messageIDs = append(messageIDs, *row.LastMessageID)
+        }
+    }
+    messages := make(map[uuid.UUID]*models.Message, len(messageIDs))
+    if len(messageIDs) > 0 {
+        var found []models.Message
+        if err := db.DB.Where("id IN ?", messageIDs).Find(&found).Error; err != nil {
+            return nil, err
+        }
+        for i := range found {
+            messages[found[i].ID] = &found[i]
+        }
+    }
+    summaries := make([]dto.ConversationSummary, 0, len(rows))
+    for _, row := range rows {
+        summary := dto.ConversationSummary{
+            Type:           row.Type,
+            ID:             row.ID,
+            Name:           row.Name,
+            LastActivityAt: row.LastActivityAt,
+            UnreadCount:    row.UnreadCount,
+        }
+        if row.LastMessageID != nil {
+            summary.LastMessage = messages[*row.LastMessageID]
+        }
+        summaries = append(summaries, summary)
+    }
+    return summaries, nil
+}
@@ -4,6 +4,9 @@ import (
 	"chatting-service-app/models"
 	"chatting-service-app/db"
 	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
 )
 
 type MessageRecipientRepository struct {}
@@ -16,14 +19,51 @@ func (r *MessageRecipientRepository) Create(recipient *models.MessageRecipient)
 	return db.DB.Create(recipient).Error
 }
 
-func (r *MessageRecipientRepository) SetDeliveredAt(messageID, recipientID string, deliveredAt time.Time) error {
-	return db.DB.Model(&models.MessageRecipient{}).
-		Where("message_id = ? AND recipient_id = ?", messageID, recipientID).
-		Update("delivered_at", deliveredAt).Error
+// SetDeliveredAt records the first delivery of a message to the recipient. It
+// reports whether anything changed, so repeated acks do not notify the sender again.
+func (r *MessageRecipientRepository) SetDeliveredAt(messageID, recipientID string, deliveredAt time.Time) (bool, error) {
+	result := db.DB.Model(&models.MessageRecipient{}).
+		Where("message_id = ? AND recipient_id = ? AND delivered_at IS NULL", messageID, recipientID).
+		Update("delivered_at", deliveredAt)
+	return result.RowsAffected > 0, result.Error
+}
+
+// SetReadAt records the first read of a message, which also counts as its
+// delivery, and reports whether anything changed
+func (r *MessageRecipientRepository) SetReadAt(messageID, recipientID string, readAt time.Time) (bool, error) {
+	result := db.DB.Model(&models.MessageRecipient{}).
+		Where("message_id = ? AND recipient_id = ? AND read_at IS NULL", messageID, recipientID).
+		Updates(map[string]interface{}{
+			"read_at":      readAt,
+			"delivered_at": gorm.Expr("COALESCE(delivered_at, ?)", readAt),
+		})
+	return result.RowsAffected > 0, result.Error
+}
+
+// ReadMessage identifies a message marked read by MarkReadUpTo
+type ReadMessage struct {
+	MessageID uuid.UUID
+	SenderID  uuid.UUID
 }
 
-func (r *MessageRecipientRepository) SetReadAt(messageID, recipientID string, readAt time.Time) error {
-	return db.DB.Model(&models.MessageRecipient{}).
-		Where("message_id = ? AND recipient_id = ?", messageID, recipientID).
-		Update("read_at", readAt).Error
+// MarkReadUpTo marks read every unread message addressed to the recipient in
+// one conversation, up to and including the message at the cursor. A non-nil
+// conversationID selects a group; otherwise the 1:1 messages from peerID.
+func (r *MessageRecipientRepository) MarkReadUpTo(recipientID string, conversationID *uuid.UUID, peerID string, upTo time.Time, upToID uuid.UUID, readAt time.Time) ([]ReadMessage, error) {
+	scope := "m.conversation_id IS NULL AND m.sender_id = ?"
+	scopeArg := interface{}(peerID)
+	if conversationID != nil {
+		scope = "m.conversation_id = ?"
+		scopeArg = *conversationID
+	}
+	var rows []ReadMessage
+	err := db.DB.Raw(`UPDATE message_recipients mr
+		SET read_at = ?, delivered_at = COALESCE(mr.delivered_at, ?)
+		FROM messages m
+		WHERE mr.message_id = m.id AND mr.recipient_id = ? AND mr.read_at IS NULL
+			AND (m.created_at, m.id) <= (?, ?) AND `+scope+`
+		RETURNING m.id AS message_id, m.sender_id`,
+		readAt, readAt, recipientID, upTo, upToID, scopeArg,
+	).Scan(&rows).Error
+	return rows, err
 }
@@ -1,8 +1,13 @@
 package repository
 
 import (
+    "chatting-service-app/dto"
     "chatting-service-app/models"
     "chatting-service-app/db"
+    "errors"
+    "github.com/google/uuid"
+    "gorm.io/gorm"
+    "gorm.io/gorm/clause"
     "time"
 )
 
@@ -16,24 +21,433 @@ func (r *MessageRepository) SendMessage(msg *models.Message) error {
     return db.DB.Create(msg).Error
 }
 
-func (r *MessageRepository) GetMessagesBetweenUsers(user1ID, user2ID string) ([]models.Message, error) {
-    var messages []models.Message
-    err := db.DB.Where(
-        "(sender_id = ? AND recipient_id = ?) OR (sender_id = ? AND recipient_id = ?)", 
+// SendMessageTo stores the message and a recipient row for each of
+// recipientIDs in one transaction, so a message never lacks its recipients
+func (r *MessageRepository) SendMessageTo(msg *models.Message, recipientIDs []uuid.UUID) error {
+    return db.DB.Transaction(func(tx *gorm.DB) error {
+        if err := tx.Create(msg).Error; err != nil {
+            return err
+        }
+        if len(recipientIDs) == 0 {
+            return nil
+        }
+        recipients := make([]models.MessageRecipient, 0, len(recipientIDs))
+        for _, id := range recipientIDs {
+            recipients = append(recipients, models.MessageRecipient{MessageID: msg.ID, RecipientID: id})
+        }
+        return tx.Create(&recipients).Error
+    })
+}
+
+func (r *MessageRepository) GetByID(id string) (*models.Message, error) {
+    var msg models.Message
+    err := db.DB.Where("id = ?", id).First(&msg).Error
+    if errors.Is(err, gorm.ErrRecordNotFound) {
+        return nil, nil
+    }
+    return &msg, err
+}
+
+// UpdateContent replaces the message content and records the previous content
+// in message_edits, in one transaction
+func (r *MessageRepository) UpdateContent(msg *models.Message, content string, editedAt time.Time) error {
+    return db.DB.Transaction(func(tx *gorm.DB) error {
+        edit := &models.MessageEdit{MessageID: msg.ID, PreviousContent: msg.Content, EditedAt: editedAt}
+        if err := tx.Create(edit).Error; err != nil {
+            return err
+        }
+        if err := tx.Model(&models.Message{}).Where("id = ?", msg.ID).
+            Updates(map[string]interface{}{"content": content, "edited_at": editedAt}).Error; err != nil {
+            return err
+        }
+        msg.Content = content
+        msg.EditedAt = &editedAt
+        return nil
+    })
+}
+
+// GetEdits returns a message's edit history, oldest first
+func (r *MessageRepository) GetEdits(messageID string) ([]models.MessageEdit, error) {
+    var edits []models.MessageEdit
+    err := db.DB.Where("message_id = ?", messageID).Order("edited_at asc").Find(&edits).Error
+    return edits, err
+}
+
+// GetBySenderAndClientID finds a message by the sender's idempotency key
+func (r *MessageRepository) GetBySenderAndClientID(senderID, clientMessageID string) (*models.Message, error) {
+    var msg models.Message
+    err := db.DB.Where("sender_id = ? AND client_message_id = ?", senderID, clientMessageID).First(&msg).Error
+    if errors.Is(err, gorm.ErrRecordNotFound) {
+        return nil, nil
+    }
+    return &msg, err
+}
+
+// GetMessagesBetweenUsers returns the 1:1 history of two users without the
+// messages the viewer deleted for themselves
+func (r *MessageRepository) GetMessagesBetweenUsers(viewerID, user1ID, user2ID string, page dto.PageRequest) (dto.MessagePage, error) {
+    query := db.DB.Where(
+        "(sender_id = ? AND recipient_id = ?) OR (sender_id = ? AND recipient_id = ?)",
         user1ID, user2ID, user2ID, user1ID,
-    ).Order("created_at asc").Find(&messages).Error
-    return messages, err
+    )
+    return history(query, viewerID, page)
 }
 
-func (r *MessageRepository) GetAllMessagesForUser(userID string) ([]models.Message, error) {
+func (r *MessageRepository) GetAllMessagesForUser(userID string, page dto.PageRequest) (dto.MessagePage, error) {
+    query := db.DB.Where(
+        "sender_id = ? OR recipient_id = ? OR conversation_id IN (SELECT conversation_id FROM conversation_members WHERE user_id = ?)",
+        userID, userID, userID,
+    )
+    return history(query, userID, page)
+}
+
+func (r *MessageRepository) GetConversationMessages(viewerID, conversationID string, page dto.PageRequest) (dto.MessagePage, error) {
+    query := db.DB.Where("conversation_id = ?", conversationID)
+    return history(query, viewerID, page)
+}
+
+// history pages through the messages matched by query as the viewer sees
+// them: without the ones they hid and with reaction and reply counts attached
+func history(query *gorm.DB, viewerID string, page dto.PageRequest) (dto.MessagePage, error) {
+    result, err := paginateMessages(notHiddenFor(query, viewerID), page)
+    if err != nil {
+        return result, err
+    }
+    if err := attachReactions(result.Messages, viewerID); err != nil {
+        return result, err
+    }
+    if err := attachStatuses(result.Messages, viewerID); err != nil {
+        return result, err
+    }
+    err = attachReplyCounts(result.Messages)
+    return result, err
+}
+
+// GetThreadReplies pages through the replies posted in a message's thread
+func (r *MessageRepository) GetThreadReplies(viewerID, rootID string, page dto.PageRequest) (dto.MessagePage, error) {
+    query := db.DB.Where("thread_root_id = ?", rootID)
+    return history(query, viewerID, page)
+}
+
+// attachReplyCounts sets ReplyCount on messages that started a thread,
+// not counting replies deleted for everyone
+func attachReplyCounts(messages []models.Message) error {
+    if len(messages) == 0 {
+        return nil
+    }
+    ids := make([]uuid.UUID, len(messages))
+    for i, msg := range messages {
+        ids[i] = msg.ID
+    }
+    var rows []struct {
+        ThreadRootID uuid.UUID
+        Count        int
+    }
+    err := db.DB.Model(&models.Message{}).
+        Select("thread_root_id, COUNT(*) AS count").
+        Where("thread_root_id IN ? AND deleted_at IS NULL", ids).
+        Group("thread_root_id").
+        Scan(&rows).Error
+    if err != nil {
+        return err
+    }
+    counts := make(map[uuid.UUID]int, len(rows))
+    for _, row := range rows {
+        counts[row.ThreadRootID] = row.Count
+    }
+    for i := range messages {
+        messages[i].ReplyCount = counts[messages[i].ID]
+    }
+    return nil
+}
+
+// searchHeadline escapes the content before highlighting so the snippet is
+// safe to render as HTML with only the <mark> tags interpreted
+const searchHeadline = `ts_headline('simple',
+    replace(replace(replace(messages.content, '&', '&amp;'), '<', '&lt;'), '>', '&gt;'),
+    websearch_to_tsquery('simple', ?),
+    'StartSel=<mark>, StopSel=</mark>, MaxWords=30, MinWords=10, MaxFragments=2') AS snippet`
+
+// Search finds messages the viewer sent, received or can see as a group member
+// whose content matches the query, newest first. Messages the viewer hid and
+// tombstones are excluded.
+func (r *MessageRepository) Search(viewerID string, req dto.SearchRequest) (dto.SearchPage, error) {
+    limit := req.Limit
+    if limit <= 0 || limit > dto.MaxPageLimit {
+        limit = dto.DefaultPageLimit
+    }
+    visible := db.DB.Where(
+        "sender_id = ? OR recipient_id = ? OR conversation_id IN (SELECT conversation_id FROM conversation_members WHERE user_id = ?)",
+        viewerID, viewerID, viewerID,
+    )
+    query := db.DB.Model(&models.Message{}).
+        Select("messages.*, "+searchHeadline, req.Query).
+        Where(notHiddenFor(visible, viewerID)).
+        Where("content_tsv @@ websearch_to_tsquery('simple', ?)", req.Query).
+        Where("deleted_at IS NULL")
+    if req.PeerID != "" {
+        query = query.Where(
+            "conversation_id IS NULL AND ((sender_id = ? AND recipient_id = ?) OR (sender_id = ? AND recipient_id = ?))",
+            viewerID, req.PeerID, req.PeerID, viewerID,
+        )
+    }
+    if req.From != nil {
+        query = query.Where("created_at >= ?", *req.From)
+    }
+    if req.To != nil {
+        query = query.Where("created_at < ?", *req.To)
+    }
+    if req.HasMedia != nil {
+        if *req.HasMedia {
+            query = query.Where("media_url <> ''")
+        } else {
+            query = query.Where("media_url = ''")
+        }
+    }
+    if req.Before != nil {
+        query = query.Where("(created_at, id) < (?, ?)", req.Before.CreatedAt, req.Before.ID)
+    }
+
+    var rows []struct {
+        models.Message `gorm:"embedded"`
+        Snippet        string
+    }
+    if err := query.Order("created_at desc, id desc").Limit(limit + 1).Scan(&rows).Error; err != nil {
+        return dto.SearchPage{}, err
+    }
+    page := dto.SearchPage{HasMore: len(rows) > limit, Results: []dto.SearchResult{}}
+    if page.HasMore {
+        rows = rows[:limit]
+    }
+    for _, row := range rows {
+        page.Results = append(page.Results, dto.SearchResult{Message: row.Message, Snippet: row.Snippet})
+    }
+    if len(rows) > 0 {
+        last := rows[len(rows)-1].Message
+        page.NextCursor = dto.Cursor{CreatedAt: last.CreatedAt, ID: last.ID}.Encode()
+    }
+    return page, nil
+}
+
+// UserCanSeeAttachment reports whether a message the user sent, received or
+// can see as a group member carries the attachment and was not deleted for everyone
+func (r *MessageRepository) UserCanSeeAttachment(userID, attachmentID string) (bool, error) {
+    var count int64
+    err := db.DB.Model(&models.Message{}).
+        Where("attachment_id = ? AND deleted_at IS NULL", attachmentID).
+        Where(
+            "sender_id = ? OR recipient_id = ? OR conversation_id IN (SELECT conversation_id FROM conversation_members WHERE user_id = ?)",
+            userID, userID, userID,
+        ).
+        Count(&count).Error
+    return count > 0, err
+}
+
+// notHiddenFor drops messages the viewer deleted for themselves, either as the
+// sender or through their recipient row. Tombstones stay visible.
+func notHiddenFor(query *gorm.DB, viewerID string) *gorm.DB {
+    return db.DB.Where(query).
+        Where("NOT (messages.sender_id = ? AND messages.sender_hidden_at IS NOT NULL)", viewerID).
+        Where("NOT EXISTS (SELECT 1 FROM message_recipients mr WHERE mr.message_id = messages.id AND mr.recipient_id = ? AND mr.hidden_at IS NOT NULL)", viewerID)
+}
+
+// HideForSender deletes a message for its sender only
+func (r *MessageRepository) HideForSender(messageID string, hiddenAt time.Time) error {
+    return db.DB.Model(&models.Message{}).Where("id = ?", messageID).Update("sender_hidden_at", hiddenAt).Error
+}
+
+// HideForRecipient deletes a message for one recipient only. Members who
+// joined a conversation after the message was sent have no recipient row
+// yet, so one is created.
+func (r *MessageRepository) HideForRecipient(messageID, recipientID string, hiddenAt time.Time) error {
+    result := db.DB.Model(&models.MessageRecipient{}).
+        Where("message_id = ? AND recipient_id = ?", messageID, recipientID).
+        Update("hidden_at", hiddenAt)
+    if result.Error != nil || result.RowsAffected > 0 {
+        return result.Error
+    }
+    msgID, err := uuid.Parse(messageID)
+    if err != nil {
+        return err
+    }
+    userID, err := uuid.Parse(recipientID)
+    if err != nil {
+        return err
+    }
+    return db.DB.Create(&models.MessageRecipient{
+        MessageID:   msgID,
+        RecipientID: userID,
+        DeliveredAt: &hiddenAt,
+        HiddenAt:    &hiddenAt,
+    }).Error
+}
+
+// Tombstone deletes a message for everyone: its content, media, edit history
+// and reactions are erased and DeletedAt is set
+func (r *MessageRepository) Tombstone(msg *models.Message, deletedAt time.Time) error {
+    return db.DB.Transaction(func(tx *gorm.DB) error {
+        if err := tx.Where("message_id = ?", msg.ID).Delete(&models.MessageEdit{}).Error; err != nil {
+            return err
+        }
+        if err := tx.Where("message_id = ?", msg.ID).Delete(&models.MessageReaction{}).Error; err != nil {
+            return err
+        }
+        if err := tx.Model(&models.Message{}).Where("id = ?", msg.ID).
+            Updates(map[string]interface{}{"content": "", "media_url": "", "attachment_id": nil, "deleted_at": deletedAt}).Error; err != nil {
+            return err
+        }
+        msg.Content = ""
+        msg.MediaURL = ""
+        msg.AttachmentID = nil
+        msg.DeletedAt = &deletedAt
+        return nil
+    })
+}
+
+// GetUndeliveredForUser returns messages addressed to the user whose recipient
+// row has no DeliveredAt yet, oldest first
+func (r *MessageRepository) GetUndeliveredForUser(userID string, limit int) ([]models.Message, error) {
     var messages []models.Message
-    err := db.DB.Where(
-        "sender_id = ? OR recipient_id = ?",
-        userID, userID,
-    ).Order("created_at asc").Find(&messages).Error
+    err := db.DB.Joins("JOIN message_recipients mr ON mr.message_id = messages.id").
+        Where("mr.recipient_id = ? AND mr.delivered_at IS NULL AND mr.hidden_at IS NULL", userID).
+        Order("messages.created_at asc, messages.id asc").
+        Limit(limit).
+        Find(&messages).Error
     return messages, err
 }
 
+// paginateMessages applies keyset pagination on (created_at, id) to a filtered
+// query. It fetches one extra row to learn whether another page exists and
+// always returns the page in ascending order.
+func paginateMessages(query *gorm.DB, page dto.PageRequest) (dto.MessagePage, error) {
+    limit := page.Limit
+    if limit <= 0 || limit > dto.MaxPageLimit {
+        limit = dto.DefaultPageLimit
+    }
+    // Wrap the caller's filter so its ORs don't bind to the cursor condition
+    query = db.DB.Model(&models.Message{}).Where(query)
+    forward := page.After != nil
+    switch {
+    case page.After != nil:
+        query = query.Where("(created_at, id) > (?, ?)", page.After.CreatedAt, page.After.ID).
+            Order("created_at asc, id asc")
+    case page.Before != nil:
+        query = query.Where("(created_at, id) < (?, ?)", page.Before.CreatedAt, page.Before.ID).
+            Order("created_at desc, id desc")
+    default:
+        query = query.Order("created_at desc, id desc")
+    }
+
+    var messages []models.Message
+    if err := query.Limit(limit + 1).Find(&messages).Error; err != nil {
+        return dto.MessagePage{}, err
+    }
+    result := dto.MessagePage{HasMore: len(messages) > limit}
+    if result.HasMore {
+        messages = messages[:limit]
+    }
+    if !forward {
+        for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
+            messages[i], messages[j] = messages[j], messages[i]
+        }
+    }
+    if len(messages) > 0 {
+        // Continue from the far end of the page in the direction of travel
+        edge := messages[0]
+        if forward {
+            edge = messages[len(messages)-1]
+        }
+        result.NextCursor = dto.Cursor{CreatedAt: edge.CreatedAt, ID: edge.ID}.Encode()
+    }
+    if messages == nil {
+        messages = []models.Message{}
+    }
+    result.Messages = messages
+    return result, nil
+}
+
+// AddReaction stores the reaction and reports whether it was new
+func (r *MessageRepository) AddReaction(reaction *models.MessageReaction) (bool, error) {
+    result := db.DB.Clauses(clause.OnConflict{DoNothing: true}).Create(reaction)
+    return result.RowsAffected > 0, result.Error
+}
+
+// RemoveReaction deletes the reaction and reports whether it existed
+func (r *MessageRepository) RemoveReaction(messageID, userID, emoji string) (bool, error) {
+    result := db.DB.Where("message_id = ? AND user_id = ? AND emoji = ?", messageID, userID, emoji).
+        Delete(&models.MessageReaction{})
+    return result.RowsAffected > 0, result.Error
+}
+
+// attachReactions fills in Reactions for a page of messages with one grouped
+// query, listing each message's emoji in the order they were first used
+func attachReactions(messages []models.Message, viewerID string) error {
+    if len(messages) == 0 {
+        return nil
+    }
+    ids := make([]uuid.UUID, len(messages))
+    for i, msg := range messages {
+        ids[i] = msg.ID
+    }
+    var rows []struct {
+        MessageID uuid.UUID
+        Emoji     string
+        Count     int
+        Reacted   bool
+    }
+    err := db.DB.Model(&models.MessageReaction{}).
+        Select("message_id, emoji, COUNT(*) AS count, BOOL_OR(user_id = ?) AS reacted", viewerID).
+        Where("message_id IN ?", ids).
+        Group("message_id, emoji").
+        Order("MIN(created_at)").
+        Scan(&rows).Error
+    if err != nil {
+        return err
+    }
+    byMessage := make(map[uuid.UUID][]models.ReactionCount)
+    for _, row := range rows {
+        byMessage[row.MessageID] = append(byMessage[row.MessageID], models.ReactionCount{Emoji: row.Emoji, Count: row.Count, Reacted: row.Reacted})
+    }
+    for i := range messages {
+        messages[i].Reactions = byMessage[messages[i].ID]
+    }
+    return nil
+}
+
+// attachStatuses fills in the delivery and read state of each recipient the
+// viewer may see: all of them for the viewer's own messages, else their own row
+func attachStatuses(messages []models.Message, viewerID string) error {
+    if len(messages) == 0 {
+        return nil
+    }
+    ids := make([]uuid.UUID, len(messages))
+    for i, msg := range messages {
+        ids[i] = msg.ID
+    }
+    var rows []struct {
+        MessageID uuid.UUID
+        models.RecipientStatus `gorm:"embedded"`
+    }
+    err := db.DB.Model(&models.MessageRecipient{}).
+        Select("message_recipients.message_id, message_recipients.recipient_id, message_recipients.delivered_at, message_recipients.read_at").
+        Joins("JOIN messages ON messages.id = message_recipients.message_id").
+        Where("message_recipients.message_id IN ?", ids).
+        Where("messages.sender_id = ? OR message_recipients.recipient_id = ?", viewerID, viewerID).
+        Order("message_recipients.recipient_id").
+        Scan(&rows).Error
+    if err != nil {
+        return err
+    }
+    byMessage := make(map[uuid.UUID][]models.RecipientStatus)
+    for _, row := range rows {
+        byMessage[row.MessageID] = append(byMessage[row.MessageID], row.RecipientStatus)
+    }
+    for i := range messages {
+        messages[i].Statuses = byMessage[messages[i].ID]
+    }
+    return nil
+}
+
 func (r *MessageRepository) CreateMessageRecipient(recipient *models.MessageRecipient) error {
     return db.DB.Create(recipient).Error
 }
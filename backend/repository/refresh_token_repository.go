@@ -0,0 +1,51 @@
+package repository
+
+import (
+    "chatting-service-app/models"
+    "chatting-service-app/db"
+    "errors"
+    "gorm.io/gorm"
+    "time"
+)
+
+type RefreshTokenRepository struct {}
+
+func NewRefreshTokenRepository() *RefreshTokenRepository {
+    return &RefreshTokenRepository{}
+}
+
+func (r *RefreshTokenRepository) Create(token *models.RefreshToken) error {
+    return db.DB.Create(token).Error
+}
+
+func (r *RefreshTokenRepository) GetByHash(tokenHash string) (*models.RefreshToken, error) {
+    var token models.RefreshToken
+    err := db.DB.Where("token_hash = ?", tokenHash).First(&token).Error
+    if errors.Is(err, gorm.ErrRecordNotFound) {
+        return nil, nil
+    }
+    return &token, err
+}
+
+// MarkUsed atomically flags an unused, unrevoked token as used. It returns false
+// when the token was already consumed, which is how reuse is detected.
+func (r *RefreshTokenRepository) MarkUsed(tokenHash string, usedAt time.Time) (bool, error) {
+    result := db.DB.Model(&models.RefreshToken{}).
+        Where("token_hash = ? AND used_at IS NULL AND revoked_at IS NULL", tokenHash).
+        Update("used_at", usedAt)
+    return result.RowsAffected == 1, result.Error
+}
+
+// RevokeFamily revokes every outstanding token issued for the session
+func (r *RefreshTokenRepository) RevokeFamily(sessionID string, revokedAt time.Time) error {
+    return db.DB.Model(&models.RefreshToken{}).
+        Where("session_id = ? AND revoked_at IS NULL", sessionID).
+        Update("revoked_at", revokedAt).Error
+}
+
+// RevokeAllForUser revokes the tokens of every session belonging to the user
+func (r *RefreshTokenRepository) RevokeAllForUser(userID string, revokedAt time.Time) error {
+    return db.DB.Model(&models.RefreshToken{}).
+        Where("revoked_at IS NULL AND session_id IN (SELECT id FROM sessions WHERE user_id = ?)", userID).
+        Update("revoked_at", revokedAt).Error
+}
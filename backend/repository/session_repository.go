@@ -0,0 +1,60 @@
+package repository
+
+import (
+    "chatting-service-app/models"
+    "chatting-service-app/db"
+    "errors"
+    "gorm.io/gorm"
+    "time"
+)
+
+type SessionRepository struct {}
+
+func NewSessionRepository() *SessionRepository {
+    return &SessionRepository{}
+}
+
+func (r *SessionRepository) Create(session *models.Session) error {
+    return db.DB.Create(session).Error
+}
+
+func (r *SessionRepository) GetByID(sessionID string) (*models.Session, error) {
+    var session models.Session
+    err := db.DB.Where("id = ?", sessionID).First(&session).Error
+    if errors.Is(err, gorm.ErrRecordNotFound) {
+        return nil, nil
+    }
+    return &session, err
+}
+
+// GetActiveByUser returns the user's sessions that are neither revoked nor expired, newest first
+func (r *SessionRepository) GetActiveByUser(userID string) ([]models.Session, error) {
+    var sessions []models.Session
+    err := db.DB.Where("user_id = ? AND revoked_at IS NULL AND expires_at > ?", userID, time.Now()).
+        Order("created_at desc").Find(&sessions).Error
+    return sessions, err
+}
+
+func (r *SessionRepository) TouchLastUsed(sessionID string, usedAt time.Time) error {
+    return db.DB.Model(&models.Session{}).
+        Where("id = ?", sessionID).
+        Update("last_used_at", usedAt).Error
+}
+
+func (r *SessionRepository) Revoke(sessionID string, revokedAt time.Time) error {
+    return db.DB.Model(&models.Session{}).
+        Where("id = ? AND revoked_at IS NULL", sessionID).
+        Update("revoked_at", revokedAt).Error
+}
+
+func (r *SessionRepository) RevokeAllForUser(userID string, revokedAt time.Time) error {
+    return db.DB.Model(&models.Session{}).
+        Where("user_id = ? AND revoked_at IS NULL", userID).
+        Update("revoked_at", revokedAt).Error
+}
+
+func (r *SessionRepository) ExtendExpiry(sessionID string, expiresAt time.Time) error {
+    return db.DB.Model(&models.Session{}).
+        Where("id = ?", sessionID).
+        Update("expires_at", expiresAt).Error
+}
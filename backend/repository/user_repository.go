@@ -1,10 +1,12 @@
 package repository
 
 import (
+    "context"
     "chatting-service-app/models"
     "chatting-service-app/db"
     "errors"
     "gorm.io/gorm"
+    "time"
 )
 
 type UserRepository struct {}
@@ -42,18 +44,132 @@ func (r *UserRepository) GetAllUsersExcept(exceptID string) ([]models.User, erro
     return users, err
 }
 
+// SetOnlineStatus records a connect or disconnect and moves last_seen_at,
+// unless the user is invisible
 func (r *UserRepository) SetOnlineStatus(userID string, isOnline bool) error {
     return db.DB.Model(&models.User{}).
         Where("id = ?", userID).
-        Update("is_online", isOnline).Error
+        Updates(map[string]interface{}{
+            "is_online":    isOnline,
+            "is_idle":      false,
+            "last_seen_at": gorm.Expr("CASE WHEN presence_status = ? THEN last_seen_at ELSE ? END", models.PresenceInvisible, time.Now()),
+        }).Error
 }
 
+func (r *UserRepository) SetIdle(userID string, idle bool) error {
+    return db.DB.Model(&models.User{}).
+        Where("id = ?", userID).
+        Update("is_idle", idle).Error
+}
+
+// UpdatePresence saves the presence settings in fields, keyed by column
+func (r *UserRepository) UpdatePresence(userID string, fields map[string]interface{}) error {
+    return db.DB.Model(&models.User{}).
+        Where("id = ?", userID).
+        Updates(fields).Error
+}
+
+// RenewLeases records that the instance holds connections of the users until
+// ttl from now, measured on the database clock so instances may drift
+func (r *UserRepository) RenewLeases(ctx context.Context, instanceID string, userIDs []string, ttl time.Duration) error {
+    if len(userIDs) == 0 {
+        return nil
+    }
+    return db.DB.WithContext(ctx).Exec(`
+        INSERT INTO presence_leases (user_id, instance_id, renewed_at, expires_at)
+        SELECT id, ?, NOW(), NOW() + make_interval(secs => ?)
+        FROM users WHERE id IN ?
+        ON CONFLICT (user_id, instance_id)
+        DO UPDATE SET renewed_at = EXCLUDED.renewed_at, expires_at = EXCLUDED.expires_at`,
+        instanceID, ttl.Seconds(), userIDs,
+    ).Error
+}
+
+// ReleaseLease drops the instance's lease once the user's last connection there closed
+func (r *UserRepository) ReleaseLease(ctx context.Context, instanceID, userID string) error {
+    return db.DB.WithContext(ctx).Exec("DELETE FROM presence_leases WHERE user_id = ? AND instance_id = ?", userID, instanceID).Error
+}
+
+// DropLeases deletes the leases of every instance except instanceID
+func (r *UserRepository) DropLeases(ctx context.Context, instanceID string) error {
+    return db.DB.WithContext(ctx).Exec("DELETE FROM presence_leases WHERE instance_id <> ?", instanceID).Error
+}
+
+// SweepLeases deletes expired leases and brings is_online in line with the
+// live ones. Users left without a live lease go offline, last seen when their
+// lease was last renewed; it returns their IDs.
+func (r *UserRepository) SweepLeases(ctx context.Context) ([]string, error) {
+    var offline []string
+    err := db.DB.WithContext(ctx).Raw(`
+        WITH expired AS (
+            DELETE FROM presence_leases WHERE expires_at <= NOW()
+            RETURNING user_id, renewed_at
+        )
+        UPDATE users u
+        SET is_online = FALSE,
+            is_idle = FALSE,
+            last_seen_at = CASE WHEN u.presence_status = @invisible THEN u.last_seen_at
+                ELSE COALESCE((SELECT MAX(e.renewed_at) FROM expired e WHERE e.user_id = u.id), NOW()) END
+        WHERE u.is_online
+          AND NOT EXISTS (SELECT 1 FROM presence_leases l WHERE l.user_id = u.id AND l.expires_at > NOW())
+        RETURNING u.id`,
+        map[string]interface{}{"invisible": models.PresenceInvisible},
+    ).Scan(&offline).Error
+    if err != nil {
+        return nil, err
+    }
+    err = db.DB.WithContext(ctx).Exec(`
+        UPDATE users u SET is_online = TRUE
+        WHERE NOT u.is_online
+          AND EXISTS (SELECT 1 FROM presence_leases l WHERE l.user_id = u.id AND l.expires_at > NOW())`,
+    ).Error
+    return offline, err
+}
+
+// GetOnlineUsers returns connected users, leaving out the invisible ones
 func (r *UserRepository) GetOnlineUsers() ([]models.User, error) {
     var users []models.User
-    err := db.DB.Where("is_online = ?", true).Find(&users).Error
+    err := db.DB.Where("is_online = ? AND presence_status <> ?", true, models.PresenceInvisible).Find(&users).Error
     return users, err
 }
 
+// ContactIDs returns the users who share a direct chat or a group with the user
+func (r *UserRepository) ContactIDs(userID string) ([]string, error) {
+    var ids []string
+    err := db.DB.Raw(`
+        SELECT CASE WHEN sender_id = @user THEN recipient_id ELSE sender_id END
+        FROM messages
+        WHERE conversation_id IS NULL AND recipient_id IS NOT NULL
+          AND (sender_id = @user OR recipient_id = @user)
+        UNION
+        SELECT other.user_id
+        FROM conversation_members me
+        JOIN conversation_members other ON other.conversation_id = me.conversation_id
+        WHERE me.user_id = @user AND other.user_id <> @user`,
+        map[string]interface{}{"user": userID},
+    ).Scan(&ids).Error
+    return ids, err
+}
+
+// AreContacts reports whether two users share a direct chat or a group
+func (r *UserRepository) AreContacts(userID, otherID string) (bool, error) {
+    var shared bool
+    err := db.DB.Raw(`
+        SELECT EXISTS (
+            SELECT 1 FROM messages
+            WHERE conversation_id IS NULL
+              AND ((sender_id = @user AND recipient_id = @other) OR (sender_id = @other AND recipient_id = @user))
+        ) OR EXISTS (
+            SELECT 1
+            FROM conversation_members me
+            JOIN conversation_members other ON other.conversation_id = me.conversation_id
+            WHERE me.user_id = @user AND other.user_id = @other
+        )`,
+        map[string]interface{}{"user": userID, "other": otherID},
+    ).Scan(&shared).Error
+    return shared, err
+}
+
 func (r *UserRepository) GetUserByID(userID string) (*models.User, error) {
     var user models.User
     err := db.DB.Where("id = ?", userID).First(&user).Error
@@ -0,0 +1,136 @@
+package service
+
+import (
+	"chatting-service-app/dto"
+	"chatting-service-app/models"
+	"chatting-service-app/repository"
+	"chatting-service-app/storage"
+	"chatting-service-app/utils"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// defaultAttachmentURLTTL is how long a signed download URL stays valid;
+// override with ATTACHMENT_URL_TTL
+const defaultAttachmentURLTTL = 5 * time.Minute
+
+// safeExtension keeps object keys free of anything but a short alphanumeric extension
+var safeExtension = regexp.MustCompile(`^\.[a-z0-9]{1,10}$`)
+
+type AttachmentService struct {
+	repo   *repository.AttachmentRepository
+	store  storage.ObjectStore
+	policy *Policy
+	urlTTL time.Duration
+}
+
+func NewAttachmentService(repo *repository.AttachmentRepository, store storage.ObjectStore, policy *Policy) *AttachmentService {
+	return &AttachmentService{
+		repo:   repo,
+		store:  store,
+		policy: policy,
+		urlTTL: utils.DurationFromEnv("ATTACHMENT_URL_TTL", defaultAttachmentURLTTL),
+	}
+}
+
+// AttachmentURL is the media_url of a message carrying the attachment
+func AttachmentURL(id uuid.UUID) string {
+	return attachmentsPrefix + id.String()
+}
+
+// Upload stores the file under a generated key and records its owner. The
+// content type is sniffed from the data rather than trusted from the client.
+func (s *AttachmentService) Upload(ownerID string, file io.ReadSeeker, fileName string, size int64) (*models.Attachment, error) {
+	owner, err := uuid.Parse(ownerID)
+	if err != nil {
+		return nil, err
+	}
+	head := make([]byte, 512)
+	n, err := io.ReadFull(file, head)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	attachment := &models.Attachment{
+		ID:          uuid.New(),
+		OwnerID:     owner,
+		FileName:    filepath.Base(fileName),
+		ContentType: http.DetectContentType(head[:n]),
+		Size:        size,
+		CreatedAt:   time.Now(),
+	}
+	attachment.ObjectKey = attachment.ID.String()
+	if ext := strings.ToLower(filepath.Ext(fileName)); safeExtension.MatchString(ext) {
+		attachment.ObjectKey += ext
+	}
+	ctx := context.Background()
+	if err := s.store.Put(ctx, attachment.ObjectKey, file, size, attachment.ContentType); err != nil {
+		return nil, err
+	}
+	if err := s.repo.Create(attachment); err != nil {
+		_ = s.store.Delete(ctx, attachment.ObjectKey)
+		return nil, err
+	}
+	return attachment, nil
+}
+
+// Link returns a signed download URL for an attachment the user may see
+func (s *AttachmentService) Link(userID, attachmentID string) (dto.AttachmentLink, error) {
+	attachment, err := s.policy.VisibleAttachment(userID, attachmentID)
+	if err != nil {
+		return dto.AttachmentLink{}, err
+	}
+	expiresAt := time.Now().Add(s.urlTTL).Truncate(time.Second)
+	path := contentPath(attachment.ID.String())
+	query := url.Values{
+		"expires":   {strconv.FormatInt(expiresAt.Unix(), 10)},
+		"signature": {utils.SignPath(path, expiresAt)},
+	}
+	return dto.AttachmentLink{
+		URL:         path + "?" + query.Encode(),
+		ExpiresAt:   expiresAt,
+		FileName:    attachment.FileName,
+		ContentType: attachment.ContentType,
+		Size:        attachment.Size,
+	}, nil
+}
+
+// Open checks a signed download URL and opens the attachment it names
+func (s *AttachmentService) Open(ctx context.Context, attachmentID, expires, signature string) (*models.Attachment, io.ReadCloser, error) {
+	expiresUnix, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil || !utils.VerifyPathSignature(contentPath(attachmentID), expiresUnix, signature, time.Now()) {
+		return nil, nil, fmt.Errorf("download link is invalid or expired: %w", ErrForbidden)
+	}
+	attachment, err := s.repo.GetByID(attachmentID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if attachment == nil {
+		return nil, nil, fmt.Errorf("attachment not found: %w", ErrNotFound)
+	}
+	body, _, err := s.store.Get(ctx, attachment.ObjectKey)
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil, nil, fmt.Errorf("attachment not found: %w", ErrNotFound)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return attachment, body, nil
+}
+
+func contentPath(attachmentID string) string {
+	return attachmentsPrefix + attachmentID + "/content"
+}
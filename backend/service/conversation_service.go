@@ -0,0 +1,369 @@
+package service
+
+import (
+	"chatting-service-app/dto"
+	"chatting-service-app/models"
+	"chatting-service-app/repository"
+	"chatting-service-app/websocket"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type ConversationService struct {
+	repo     *repository.ConversationRepository
+	userRepo *repository.UserRepository
+	hub      *websocket.Hub
+}
+
+func NewConversationService(repo *repository.ConversationRepository, userRepo *repository.UserRepository, hub *websocket.Hub) *ConversationService {
+	return &ConversationService{repo: repo, userRepo: userRepo, hub: hub}
+}
+
+// CreateConversation creates a named group owned by the creator with the given members
+func (s *ConversationService) CreateConversation(creatorID uuid.UUID, name string, memberIDs []uuid.UUID) (*models.Conversation, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return nil, fmt.Errorf("name is required: %w", ErrInvalid)
+	}
+	now := time.Now()
+	members := []models.ConversationMember{{UserID: creatorID, Role: models.ConversationRoleOwner, JoinedAt: now}}
+	seen := map[uuid.UUID]bool{creatorID: true}
+	for _, id := range memberIDs {
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		if err := s.ensureUserExists(id); err != nil {
+			return nil, err
+		}
+		members = append(members, models.ConversationMember{UserID: id, Role: models.ConversationRoleMember, JoinedAt: now})
+	}
+	conv := &models.Conversation{Name: name, CreatedBy: creatorID, CreatedAt: now, UpdatedAt: now}
+	if err := s.repo.Create(conv, members); err != nil {
+		return nil, err
+	}
+	s.notifyMembers(conv.ID.String())
+	return conv, nil
+}
+
+// GetConversation returns the conversation and its members if the actor belongs to it
+func (s *ConversationService) GetConversation(actorID, conversationID string) (*models.Conversation, []models.ConversationMember, error) {
+	conv, err := s.getConversation(conversationID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if _, err := s.requireMember(conversationID, actorID); err != nil {
+		return nil, nil, err
+	}
+	members, err := s.repo.GetMembers(conversationID)
+	if err != nil {
+		return nil, nil, err
+	}
+	return conv, members, nil
+}
+
+func (s *ConversationService) Rename(actorID, conversationID, name string) error {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return fmt.Errorf("name is required: %w", ErrInvalid)
+	}
+	if _, err := s.getConversation(conversationID); err != nil {
+		return err
+	}
+	if _, err := s.requireManager(conversationID, actorID); err != nil {
+		return err
+	}
+	if err := s.repo.Rename(conversationID, name); err != nil {
+		return err
+	}
+	s.notifyMembers(conversationID)
+	return nil
+}
+
+// AddMembers adds users to the conversation; only owners and admins may do this
+func (s *ConversationService) AddMembers(actorID, conversationID string, userIDs []uuid.UUID) error {
+	if _, err := s.getConversation(conversationID); err != nil {
+		return err
+	}
+	if _, err := s.requireManager(conversationID, actorID); err != nil {
+		return err
+	}
+	var members []models.ConversationMember
+	seen := map[uuid.UUID]bool{}
+	for _, id := range userIDs {
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		existing, err := s.repo.GetMember(conversationID, id.String())
+		if err != nil {
+			return err
+		}
+		if existing != nil {
+			continue
+		}
+		if err := s.ensureUserExists(id); err != nil {
+			return err
+		}
+		convID, _ := uuid.Parse(conversationID)
+		members = append(members, models.ConversationMember{
+			ConversationID: convID,
+			UserID:         id,
+			Role:           models.ConversationRoleMember,
+			JoinedAt:       time.Now(),
+		})
+	}
+	if err := s.repo.AddMembers(members); err != nil {
+		return err
+	}
+	for _, m := range members {
+		s.notifyUpdated(m.UserID.String(), m.ConversationID)
+	}
+	return nil
+}
+
+// RemoveMember removes another user from the conversation. The owner cannot be
+// removed and admins can only remove plain members.
+func (s *ConversationService) RemoveMember(actorID, conversationID, userID string) error {
+	if actorID == userID {
+		return s.Leave(actorID, conversationID)
+	}
+	conv, err := s.getConversation(conversationID)
+	if err != nil {
+		return err
+	}
+	actor, err := s.requireManager(conversationID, actorID)
+	if err != nil {
+		return err
+	}
+	target, err := s.repo.GetMember(conversationID, userID)
+	if err != nil {
+		return err
+	}
+	if target == nil {
+		return fmt.Errorf("user is not a member: %w", ErrNotFound)
+	}
+	if target.Role == models.ConversationRoleOwner ||
+		(target.Role == models.ConversationRoleAdmin && actor.Role != models.ConversationRoleOwner) {
+		return fmt.Errorf("cannot remove a member with role %s: %w", target.Role, ErrForbidden)
+	}
+	if err := s.repo.RemoveMember(conversationID, userID); err != nil {
+		return err
+	}
+	s.notifyMembers(conversationID)
+	s.notifyRemoved(userID, conv)
+	return nil
+}
+
+// Leave removes the actor from the conversation. When the owner leaves, ownership
+// passes to the longest-standing admin, or failing that the longest-standing member.
+func (s *ConversationService) Leave(actorID, conversationID string) error {
+	conv, err := s.getConversation(conversationID)
+	if err != nil {
+		return err
+	}
+	member, err := s.requireMember(conversationID, actorID)
+	if err != nil {
+		return err
+	}
+	if err := s.repo.RemoveMember(conversationID, actorID); err != nil {
+		return err
+	}
+	if member.Role == models.ConversationRoleOwner {
+		if err := s.passOwnership(conversationID); err != nil {
+			return err
+		}
+	}
+	s.notifyMembers(conversationID)
+	s.notifyRemoved(actorID, conv)
+	return nil
+}
+
+// passOwnership makes the longest-standing admin, or failing that member, the owner
+func (s *ConversationService) passOwnership(conversationID string) error {
+	remaining, err := s.repo.GetMembers(conversationID)
+	if err != nil || len(remaining) == 0 {
+		return err
+	}
+	successor := remaining[0]
+	for _, m := range remaining {
+		if m.Role == models.ConversationRoleAdmin {
+			successor = m
+			break
+		}
+	}
+	return s.repo.SetMemberRole(conversationID, successor.UserID.String(), models.ConversationRoleOwner)
+}
+
+// SetMemberRole promotes or demotes a member; only the owner may change roles
+func (s *ConversationService) SetMemberRole(actorID, conversationID, userID, role string) error {
+	if role != models.ConversationRoleAdmin && role != models.ConversationRoleMember {
+		return fmt.Errorf("role must be admin or member: %w", ErrInvalid)
+	}
+	if _, err := s.getConversation(conversationID); err != nil {
+		return err
+	}
+	actor, err := s.requireMember(conversationID, actorID)
+	if err != nil {
+		return err
+	}
+	if actor.Role != models.ConversationRoleOwner {
+		return fmt.Errorf("only the owner can change roles: %w", ErrForbidden)
+	}
+	target, err := s.repo.GetMember(conversationID, userID)
+	if err != nil {
+		return err
+	}
+	if target == nil {
+		return fmt.Errorf("user is not a member: %w", ErrNotFound)
+	}
+	if target.Role == models.ConversationRoleOwner {
+		return fmt.Errorf("cannot change the owner's role: %w", ErrForbidden)
+	}
+	return s.repo.SetMemberRole(conversationID, userID, role)
+}
+
+// ListConversations returns a page of the user's direct chats and groups with
+// their last message and unread count, most recently active first
+func (s *ConversationService) ListConversations(userID string, page dto.PageRequest) (dto.ConversationPage, error) {
+	if page.After != nil {
+		return dto.ConversationPage{}, fmt.Errorf("conversations can only be paged with before: %w", ErrInvalid)
+	}
+	return s.repo.ListSummaries(userID, page.Before, page.Limit)
+}
+
+// NotifyMessage sends conversation_updated to each user about the conversation
+// a new or changed message belongs to, as that user sees it. The summaries are
+// loaded in the background so that the caller's reply does not wait for them.
+func (s *ConversationService) NotifyMessage(msg *models.Message, userIDs []string) {
+	if s.hub == nil {
+		return
+	}
+	chatIDs := make(map[string]uuid.UUID, len(userIDs))
+	for _, userID := range userIDs {
+		chatID := msg.SenderID
+		if msg.ConversationID != nil {
+			chatID = *msg.ConversationID
+		} else if msg.SenderID.String() == userID {
+			chatID = msg.RecipientID
+		}
+		chatIDs[userID] = chatID
+	}
+	go func() {
+		for userID, chatID := range chatIDs {
+			s.notifyUpdated(userID, chatID)
+		}
+	}()
+}
+
+// notifyMembers sends conversation_updated to every member of a group
+func (s *ConversationService) notifyMembers(conversationID string) {
+	if s.hub == nil {
+		return
+	}
+	members, err := s.repo.GetMembers(conversationID)
+	if err != nil {
+		return
+	}
+	for _, m := range members {
+		s.notifyUpdated(m.UserID.String(), m.ConversationID)
+	}
+}
+
+// notifyRemoved tells every device of a user who left or was removed from a
+// group to drop it from their list; the group no longer has a summary for them
+func (s *ConversationService) notifyRemoved(userID string, conv *models.Conversation) {
+	if s.hub == nil {
+		return
+	}
+	summary := dto.ConversationSummary{
+		Type:           dto.ConversationTypeGroup,
+		ID:             conv.ID,
+		Name:           conv.Name,
+		LastActivityAt: time.Now(),
+		Removed:        true,
+	}
+	s.hub.SendToUsers([]string{userID}, websocket.NewFrame("conversation_updated", summary))
+}
+
+// notifyUpdated pushes the user's current list entry for a conversation to all
+// of their devices
+func (s *ConversationService) notifyUpdated(userID string, chatID uuid.UUID) {
+	if s.hub == nil {
+		return
+	}
+	summary, err := s.repo.GetSummary(userID, chatID)
+	if err != nil || summary == nil {
+		return
+	}
+	s.hub.SendToUsers([]string{userID}, websocket.NewFrame("conversation_updated", summary))
+}
+
+// MemberIDs returns the user IDs of every member of the conversation
+func (s *ConversationService) MemberIDs(conversationID string) ([]uuid.UUID, error) {
+	members, err := s.repo.GetMembers(conversationID)
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]uuid.UUID, 0, len(members))
+	for _, m := range members {
+		ids = append(ids, m.UserID)
+	}
+	return ids, nil
+}
+
+// IsMember reports whether the user currently belongs to the conversation
+func (s *ConversationService) IsMember(conversationID, userID string) (bool, error) {
+	member, err := s.repo.GetMember(conversationID, userID)
+	return member != nil, err
+}
+
+func (s *ConversationService) getConversation(conversationID string) (*models.Conversation, error) {
+	if _, err := uuid.Parse(conversationID); err != nil {
+		return nil, fmt.Errorf("conversation not found: %w", ErrNotFound)
+	}
+	conv, err := s.repo.GetByID(conversationID)
+	if err != nil {
+		return nil, err
+	}
+	if conv == nil {
+		return nil, fmt.Errorf("conversation not found: %w", ErrNotFound)
+	}
+	return conv, nil
+}
+
+func (s *ConversationService) requireMember(conversationID, userID string) (*models.ConversationMember, error) {
+	member, err := s.repo.GetMember(conversationID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if member == nil {
+		return nil, fmt.Errorf("not a member of this conversation: %w", ErrForbidden)
+	}
+	return member, nil
+}
+
+func (s *ConversationService) requireManager(conversationID, userID string) (*models.ConversationMember, error) {
+	member, err := s.requireMember(conversationID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if member.Role != models.ConversationRoleOwner && member.Role != models.ConversationRoleAdmin {
+		return nil, fmt.Errorf("only owners and admins can manage this conversation: %w", ErrForbidden)
+	}
+	return member, nil
+}
+
+func (s *ConversationService) ensureUserExists(userID uuid.UUID) error {
+	user, err := s.userRepo.GetUserByID(userID.String())
+	if err != nil {
+		return err
+	}
+	if user == nil {
+		return fmt.Errorf("user %s does not exist: %w", userID, ErrInvalid)
+	}
+	return nil
+}
@@ -0,0 +1,13 @@
+package service
+
+import "errors"
+
+// Sentinel errors that handlers map to HTTP status codes with errors.Is
+var (
+	ErrUnauthorized = errors.New("unauthorized")
+	ErrNotFound     = errors.New("not found")
+	ErrForbidden    = errors.New("forbidden")
+	// ErrInvalid marks a request that failed validation; anything not wrapping
+	// one of these sentinels is a server fault
+	ErrInvalid = errors.New("invalid request")
+)
@@ -4,6 +4,8 @@ import (
 	"chatting-service-app/models"
 	"chatting-service-app/repository"
 	"time"
+
+	"github.com/google/uuid"
 )
 
 type MessageRecipientService struct {
@@ -19,10 +21,18 @@ func (s *MessageRecipientService) Create(recipient *models.MessageRecipient) err
 	return s.repo.Create(recipient)
 }
 
-func (s *MessageRecipientService) SetDeliveredAt(messageID, recipientID string) error {
-	return s.repo.SetDeliveredAt(messageID, recipientID, time.Now())
+// SetDeliveredAt reports whether the message was newly marked delivered
+func (s *MessageRecipientService) SetDeliveredAt(messageID, recipientID string, deliveredAt time.Time) (bool, error) {
+	return s.repo.SetDeliveredAt(messageID, recipientID, deliveredAt)
+}
+
+// SetReadAt reports whether the message was newly marked read
+func (s *MessageRecipientService) SetReadAt(messageID, recipientID string, readAt time.Time) (bool, error) {
+	return s.repo.SetReadAt(messageID, recipientID, readAt)
 }
 
-func (s *MessageRecipientService) SetReadAt(messageID, recipientID string) error {
-	return s.repo.SetReadAt(messageID, recipientID, time.Now())
+// MarkReadUpTo marks read every unread message the recipient got in one
+// conversation up to and including upToID and returns them
+func (s *MessageRecipientService) MarkReadUpTo(recipientID string, conversationID *uuid.UUID, peerID string, upTo time.Time, upToID uuid.UUID, readAt time.Time) ([]repository.ReadMessage, error) {
+	return s.repo.MarkReadUpTo(recipientID, conversationID, peerID, upTo, upToID, readAt)
 }
@@ -4,102 +4,788 @@ import (
     "chatting-service-app/dto"
     "chatting-service-app/models"
     "chatting-service-app/repository"
+    "chatting-service-app/utils"
     "chatting-service-app/websocket"
-    "encoding/json"
     "errors"
+    "fmt"
+    "log"
+    "strings"
     "time"
+    "unicode"
+
+    "github.com/google/uuid"
+)
+
+// Scopes accepted by DeleteMessage
+const (
+    DeleteScopeMe       = "me"
+    DeleteScopeEveryone = "everyone"
 )
 
+// defaultDeleteWindow is how long after sending a message its sender may delete
+// it for everyone; override with MESSAGE_DELETE_WINDOW
+const defaultDeleteWindow = time.Hour
+
+// MaxReplayMessages caps how many undelivered messages are pushed on reconnect
+const MaxReplayMessages = 500
+
 type MessageService struct {
-    repo              *repository.MessageRepository
-    hub               *websocket.Hub
-    recipientService *MessageRecipientService
+    repo                *repository.MessageRepository
+    hub                 *websocket.Hub
+    userRepo            *repository.UserRepository
+    recipientService    *MessageRecipientService
+    conversationService *ConversationService
+    policy              *Policy
 }
 
-func NewMessageService(repo *repository.MessageRepository, hub *websocket.Hub, recipientService *MessageRecipientService) *MessageService {
-    return &MessageService{repo: repo, hub: hub, recipientService: recipientService}
+func NewMessageService(repo *repository.MessageRepository, userRepo *repository.UserRepository, hub *websocket.Hub, recipientService *MessageRecipientService, conversationService *ConversationService, policy *Policy) *MessageService {
+    return &MessageService{repo: repo, userRepo: userRepo, hub: hub, recipientService: recipientService, conversationService: conversationService, policy: policy}
 }
 
-func (s *MessageService) SendMessage(req dto.SendMessageRequest) error {
+// SendMessage stores a message with its recipient rows and pushes it to
+// connected recipients. When the request carries a ClientMessageID that the
+// sender already used, the original message is returned and nothing is resent.
+// The message is also echoed to the sender's other devices; origin is the
+// connection it came from, or nil when it was sent over REST.
+func (s *MessageService) SendMessage(req dto.SendMessageRequest, origin *websocket.Client) (*models.Message, error) {
     if req.SenderID == (models.User{}).ID || req.Content == "" {
-        return errors.New("missing required fields")
+        return nil, fmt.Errorf("missing required fields: %w", ErrInvalid)
     }
-    // Always set CreatedAt to now if not set
-    if req.CreatedAt.IsZero() {
-        req.CreatedAt = time.Now()
+    if req.ClientMessageID != "" {
+        existing, err := s.repo.GetBySenderAndClientID(req.SenderID.String(), req.ClientMessageID)
+        if err != nil {
+            return nil, err
+        }
+        if existing != nil {
+            return existing, nil
+        }
     }
-    msg := &models.Message{
-        SenderID:    req.SenderID,
-        RecipientID: req.RecipientID,
-        Content:     req.Content,
-        MediaURL:    req.MediaURL,
-        IsBroadcast: req.IsBroadcast,
-        CreatedAt:   req.CreatedAt,
+    var memberIDs []uuid.UUID
+    if req.ConversationID != nil {
+        isMember, err := s.conversationService.IsMember(req.ConversationID.String(), req.SenderID.String())
+        if err != nil {
+            return nil, err
+        }
+        if !isMember {
+            return nil, fmt.Errorf("not a member of this conversation: %w", ErrForbidden)
+        }
+        memberIDs, err = s.conversationService.MemberIDs(req.ConversationID.String())
+        if err != nil {
+            return nil, err
+        }
+    } else if !req.IsBroadcast {
+        if err := s.policy.CanMessageDirectly(req.SenderID.String(), req.RecipientID); err != nil {
+            return nil, err
+        }
     }
-    err := s.repo.SendMessage(msg)
+    replyToID, threadRootID, err := s.resolveReferences(req)
     if err != nil {
-        return err
+        return nil, err
+    }
+    attachmentID, err := s.policy.CanAttach(req.SenderID.String(), req.MediaURL)
+    if err != nil {
+        return nil, err
+    }
+    msg := newMessage(req, attachmentID, replyToID, threadRootID)
+    // Recipient rows are written in the same transaction as the message:
+    // every other member of a group, or the recipient of a direct message.
+    // Broadcasts get their rows on the per-recipient copies below.
+    var recipientIDs []uuid.UUID
+    if req.ConversationID != nil {
+        for _, memberID := range memberIDs {
+            if memberID != req.SenderID {
+                recipientIDs = append(recipientIDs, memberID)
+            }
+        }
+    } else if !msg.IsBroadcast {
+        recipientIDs = []uuid.UUID{req.RecipientID}
+    }
+    err = s.repo.SendMessageTo(msg, recipientIDs)
+    if err != nil {
+        if req.ClientMessageID != "" {
+            // A concurrent retry with the same key may have won the insert
+            if existing, lookupErr := s.repo.GetBySenderAndClientID(req.SenderID.String(), req.ClientMessageID); lookupErr == nil && existing != nil {
+                return existing, nil
+            }
+        }
+        return nil, err
     }
-    msgBytes, _ := json.Marshal(req)
-    if req.IsBroadcast {
-        // Broadcast: create MessageRecipient and Message for all users except sender, send concurrently
-        users, err := s.recipientService.userRepo.GetAllUsersExcept(req.SenderID.String())
+    msgBytes := websocket.NewFrame("message", msg)
+    if req.ConversationID != nil {
+        // Group: push to every member except the sender
+        targets := make([]string, 0, len(recipientIDs))
+        for _, id := range recipientIDs {
+            targets = append(targets, id.String())
+        }
+        if s.hub != nil {
+            s.hub.SendToUsers(targets, msgBytes)
+        }
+    } else if req.IsBroadcast {
+        // Broadcast: store a copy with its recipient row for every user except the sender, concurrently
+        users, err := s.userRepo.GetAllUsersExcept(req.SenderID.String())
         if err != nil {
-            return err
+            return nil, err
         }
         for _, user := range users {
             go func(targetUser models.User) {
-                // Create a new message for each recipient
-                msgCopy := &models.Message{
-                    SenderID:    req.SenderID,
-                    RecipientID: targetUser.ID,
-                    Content:     req.Content,
-                    MediaURL:    req.MediaURL,
-                    IsBroadcast: true,
-                    CreatedAt:   req.CreatedAt,
-                }
-                _ = s.repo.SendMessage(msgCopy)
-                recipient := &models.MessageRecipient{
-                    MessageID:   msgCopy.ID,
-                    RecipientID: targetUser.ID,
-                    DeliveredAt: nil,
-                    ReadAt:      nil,
+                msgCopy := broadcastCopy(msg, targetUser.ID)
+                if err := s.repo.SendMessageTo(msgCopy, []uuid.UUID{targetUser.ID}); err != nil {
+                    log.Printf("broadcast %s to %s: %v", msg.ID, targetUser.ID, err)
+                    return
                 }
-                _ = s.recipientService.Create(recipient)
                 if s.hub != nil {
-                    msgBytes, _ := json.Marshal(msgCopy)
+                    msgBytes := websocket.NewFrame("message", msgCopy)
                     s.hub.SendDirect(targetUser.ID.String(), msgBytes)
+                    s.conversationService.NotifyMessage(msgCopy, []string{targetUser.ID.String(), req.SenderID.String()})
                 }
             }(user)
         }
     } else {
-        // 1:1: create MessageRecipient for recipient only
-        recipient := &models.MessageRecipient{
-            MessageID:   msg.ID,
-            RecipientID: req.RecipientID,
-            DeliveredAt: nil,
-            ReadAt:      nil,
-        }
-        _ = s.recipientService.Create(recipient)
+        // 1:1: push to the recipient
         if s.hub != nil {
             s.hub.SendDirect(req.RecipientID.String(), msgBytes)
         }
     }
+    if s.hub != nil {
+        s.hub.SendToUserExcept(req.SenderID.String(), origin, msgBytes)
+    }
+    if origin != nil {
+        // Sending ends the typing indicator the message was typed under
+        origin.StopTyping(websocket.TypingPayload{RecipientID: &msg.RecipientID, ConversationID: msg.ConversationID})
+    }
+    if !msg.IsBroadcast {
+        s.notifyConversation(msg)
+    }
+    return msg, nil
+}
+
+// newMessage builds the message a send request stores. The server always
+// stamps its time: created_at orders history pages and sync cursors and bounds
+// the delete-for-everyone window, so a client must not be able to choose it.
+func newMessage(req dto.SendMessageRequest, attachmentID, replyToID, threadRootID *uuid.UUID) *models.Message {
+    msg := &models.Message{
+        SenderID:       req.SenderID,
+        RecipientID:    req.RecipientID,
+        ConversationID: req.ConversationID,
+        Content:        req.Content,
+        MediaURL:       req.MediaURL,
+        AttachmentID:   attachmentID,
+        IsBroadcast:    req.IsBroadcast && req.ConversationID == nil,
+        CreatedAt:      time.Now(),
+        ReplyToID:      replyToID,
+        ThreadRootID:   threadRootID,
+    }
+    if req.ClientMessageID != "" {
+        clientMessageID := req.ClientMessageID
+        msg.ClientMessageID = &clientMessageID
+    }
+    return msg
+}
+
+// broadcastCopy is the copy of a broadcast stored for one recipient. It keeps
+// the original's attachment so the recipient may open it; client keys are
+// unique per sender, so each copy derives its own from the original's.
+func broadcastCopy(msg *models.Message, recipientID uuid.UUID) *models.Message {
+    msgCopy := &models.Message{
+        SenderID:     msg.SenderID,
+        RecipientID:  recipientID,
+        Content:      msg.Content,
+        MediaURL:     msg.MediaURL,
+        AttachmentID: msg.AttachmentID,
+        IsBroadcast:  true,
+        CreatedAt:    msg.CreatedAt,
+    }
+    if msg.ClientMessageID != nil {
+        clientMessageID := *msg.ClientMessageID + "/" + recipientID.String()
+        msgCopy.ClientMessageID = &clientMessageID
+    }
+    return msgCopy
+}
+
+// EditMessage replaces the content of the actor's own message, keeping the old
+// content in the edit history, and pushes message_edited to every participant
+func (s *MessageService) EditMessage(actorID, messageID, content string) (*models.Message, error) {
+    if strings.TrimSpace(content) == "" {
+        return nil, fmt.Errorf("content is required: %w", ErrInvalid)
+    }
+    msg, err := s.getVisibleMessage(actorID, messageID)
+    if err != nil {
+        return nil, err
+    }
+    if msg.SenderID.String() != actorID {
+        return nil, fmt.Errorf("only the sender can edit a message: %w", ErrForbidden)
+    }
+    if msg.DeletedAt != nil {
+        return nil, fmt.Errorf("message was deleted: %w", ErrForbidden)
+    }
+    if msg.Content == content {
+        return msg, nil
+    }
+    if err := s.repo.UpdateContent(msg, content, time.Now()); err != nil {
+        return nil, err
+    }
+    s.notifyParticipants(msg, websocket.NewFrame("message_edited", msg))
+    s.notifyConversation(msg)
+    return msg, nil
+}
+
+// DeleteMessage removes a message for the actor only (DeleteScopeMe) or, for
+// its sender within the delete window, tombstones it for everyone
+// (DeleteScopeEveryone). Either way a message_deleted frame tells the affected
+// devices to update.
+func (s *MessageService) DeleteMessage(actorID, messageID, scope string) error {
+    msg, err := s.getVisibleMessage(actorID, messageID)
+    if err != nil {
+        return err
+    }
+    now := time.Now()
+    switch scope {
+    case DeleteScopeMe:
+        if msg.SenderID.String() == actorID {
+            err = s.repo.HideForSender(messageID, now)
+        } else {
+            err = s.repo.HideForRecipient(messageID, actorID, now)
+        }
+        if err != nil {
+            return err
+        }
+        if s.hub != nil {
+            s.hub.SendDirect(actorID, deletedFrame(msg, scope, now))
+            s.conversationService.NotifyMessage(msg, []string{actorID})
+        }
+        return nil
+    case DeleteScopeEveryone:
+        if msg.SenderID.String() != actorID {
+            return fmt.Errorf("only the sender can delete a message for everyone: %w", ErrForbidden)
+        }
+        if msg.DeletedAt != nil {
+            return nil
+        }
+        window := utils.DurationFromEnv("MESSAGE_DELETE_WINDOW", defaultDeleteWindow)
+        if now.Sub(msg.CreatedAt) > window {
+            return fmt.Errorf("messages can only be deleted for everyone within %s of sending: %w", window, ErrForbidden)
+        }
+        if err := s.repo.Tombstone(msg, now); err != nil {
+            return err
+        }
+        s.notifyParticipants(msg, deletedFrame(msg, scope, now))
+        s.notifyConversation(msg)
+        return nil
+    }
+    return fmt.Errorf("scope must be %s or %s: %w", DeleteScopeMe, DeleteScopeEveryone, ErrInvalid)
+}
+
+func deletedFrame(msg *models.Message, scope string, deletedAt time.Time) []byte {
+    return websocket.NewFrame("message_deleted", map[string]interface{}{
+        "message_id": msg.ID,
+        "scope":      scope,
+        "deleted_at": deletedAt,
+    })
+}
+
+// maxEmojiLength bounds a reaction in bytes; enough for multi-codepoint emoji
+// such as flags or skin-tone variants
+const maxEmojiLength = 32
+
+// emojiPictographs are code points shown as emoji on their own: the
+// pictograph blocks, misc symbols and dingbats, and the few technical and
+// arrow symbols with emoji presentation. Regional indicators and skin tone
+// modifiers are in the range but only valid in flags and after a base.
+var emojiPictographs = &unicode.RangeTable{
+    R16: []unicode.Range16{
+        {Lo: 0x231A, Hi: 0x231B, Stride: 1},
+        {Lo: 0x23E9, Hi: 0x23EC, Stride: 1},
+        {Lo: 0x23F0, Hi: 0x23F3, Stride: 3},
+        {Lo: 0x2600, Hi: 0x27BF, Stride: 1},
+        {Lo: 0x2B1B, Hi: 0x2B1C, Stride: 1},
+        {Lo: 0x2B50, Hi: 0x2B55, Stride: 5},
+    },
+    R32: []unicode.Range32{
+        {Lo: 0x1F000, Hi: 0x1F1E5, Stride: 1},
+        {Lo: 0x1F200, Hi: 0x1F3FA, Stride: 1},
+        {Lo: 0x1F400, Hi: 0x1FAFF, Stride: 1},
+    },
+}
+
+// emojiTextSymbols are symbols such as © that render as text unless a
+// variation selector 16 asks for the emoji presentation
+var emojiTextSymbols = &unicode.RangeTable{
+    R16: []unicode.Range16{
+        {Lo: 0x00A9, Hi: 0x00AE, Stride: 5},
+        {Lo: 0x203C, Hi: 0x2049, Stride: 13},
+        {Lo: 0x2122, Hi: 0x2139, Stride: 23},
+        {Lo: 0x2194, Hi: 0x2199, Stride: 1},
+        {Lo: 0x21A9, Hi: 0x21AA, Stride: 1},
+        {Lo: 0x2328, Hi: 0x23CF, Stride: 167},
+        {Lo: 0x23ED, Hi: 0x23EF, Stride: 1},
+        {Lo: 0x23F1, Hi: 0x23F2, Stride: 1},
+        {Lo: 0x23F8, Hi: 0x23FA, Stride: 1},
+        {Lo: 0x24C2, Hi: 0x24C2, Stride: 1},
+        {Lo: 0x25AA, Hi: 0x25AB, Stride: 1},
+        {Lo: 0x25B6, Hi: 0x25C0, Stride: 10},
+        {Lo: 0x25FB, Hi: 0x25FE, Stride: 1},
+        {Lo: 0x2934, Hi: 0x2935, Stride: 1},
+        {Lo: 0x2B05, Hi: 0x2B07, Stride: 1},
+        {Lo: 0x3030, Hi: 0x303D, Stride: 13},
+        {Lo: 0x3297, Hi: 0x3299, Stride: 2},
+    },
+    LatinOffset: 1,
+}
+
+const (
+    zeroWidthJoiner = '\u200D'
+    variationEmoji  = '\uFE0F'
+    combiningKeycap = '\u20E3'
+    blackFlag       = 0x1F3F4
+    cancelTag       = 0xE007F
+)
+
+func isRegionalIndicator(r rune) bool { return r >= 0x1F1E6 && r <= 0x1F1FF }
+
+func isSkinTone(r rune) bool { return r >= 0x1F3FB && r <= 0x1F3FF }
+
+func isTag(r rune) bool { return r >= 0xE0020 && r <= 0xE007E }
+
+// isEmoji reports whether s is exactly one emoji: a base emoji refined by a
+// variation selector, skin tone or subdivision tag sequence, a zero-width
+// joiner sequence of those, a flag of two regional indicators, or a keycap
+// such as 1️⃣. Text symbols such as © only count with a variation selector,
+// and two emoji side by side are rejected.
+func isEmoji(s string) bool {
+    rs := []rune(s)
+    if isKeycap(rs) || (len(rs) == 2 && isRegionalIndicator(rs[0]) && isRegionalIndicator(rs[1])) {
+        return true
+    }
+    i := 0
+    for {
+        n := emojiElement(rs[i:])
+        if n == 0 {
+            return false
+        }
+        i += n
+        if i == len(rs) {
+            return true
+        }
+        if rs[i] != zeroWidthJoiner {
+            return false
+        }
+        i++
+    }
+}
+
+// isKeycap reports whether rs is a digit, # or * followed by an optional
+// variation selector and the combining keycap
+func isKeycap(rs []rune) bool {
+    if len(rs) < 2 || len(rs) > 3 || rs[len(rs)-1] != combiningKeycap {
+        return false
+    }
+    if len(rs) == 3 && rs[1] != variationEmoji {
+        return false
+    }
+    return rs[0] == '#' || rs[0] == '*' || (rs[0] >= '0' && rs[0] <= '9')
+}
+
+// emojiElement returns how many runes at the start of rs make up one base
+// emoji with its modifiers, or 0 when rs does not start with one
+func emojiElement(rs []rune) int {
+    if len(rs) == 0 {
+        return 0
+    }
+    base := rs[0]
+    switch {
+    case isRegionalIndicator(base), isSkinTone(base):
+        return 0
+    case unicode.Is(emojiPictographs, base):
+    case unicode.Is(emojiTextSymbols, base):
+        if len(rs) < 2 || rs[1] != variationEmoji {
+            return 0
+        }
+    default:
+        return 0
+    }
+    i := 1
+    if i < len(rs) && rs[i] == variationEmoji {
+        i++
+    }
+    if i < len(rs) && isSkinTone(rs[i]) {
+        i++
+    }
+    if base == blackFlag && i < len(rs) && isTag(rs[i]) {
+        for i < len(rs) && isTag(rs[i]) {
+            i++
+        }
+        if i == len(rs) || rs[i] != cancelTag {
+            return 0
+        }
+        i++
+    }
+    return i
+}
+
+// AddReaction adds the actor's emoji to a message they can see and pushes
+// reaction_added to the participants. Adding the same emoji twice is a no-op.
+func (s *MessageService) AddReaction(actorID, messageID, emoji string) error {
+    msg, actor, err := s.reactionTarget(actorID, messageID, emoji)
+    if err != nil {
+        return err
+    }
+    added, err := s.repo.AddReaction(&models.MessageReaction{MessageID: msg.ID, UserID: actor, Emoji: emoji, CreatedAt: time.Now()})
+    if err != nil || !added {
+        return err
+    }
+    s.notifyParticipants(msg, reactionFrame("reaction_added", msg, actorID, emoji))
+    return nil
+}
+
+// RemoveReaction takes back the actor's emoji and pushes reaction_removed
+func (s *MessageService) RemoveReaction(actorID, messageID, emoji string) error {
+    msg, _, err := s.reactionTarget(actorID, messageID, emoji)
+    if err != nil {
+        return err
+    }
+    removed, err := s.repo.RemoveReaction(messageID, actorID, emoji)
+    if err != nil || !removed {
+        return err
+    }
+    s.notifyParticipants(msg, reactionFrame("reaction_removed", msg, actorID, emoji))
     return nil
 }
 
+func (s *MessageService) reactionTarget(actorID, messageID, emoji string) (*models.Message, uuid.UUID, error) {
+    if len(emoji) > maxEmojiLength || !isEmoji(emoji) {
+        return nil, uuid.Nil, fmt.Errorf("emoji must be a single non-empty emoji: %w", ErrInvalid)
+    }
+    actor, err := uuid.Parse(actorID)
+    if err != nil {
+        return nil, uuid.Nil, fmt.Errorf("invalid user id: %w", ErrInvalid)
+    }
+    msg, err := s.getVisibleMessage(actorID, messageID)
+    if err != nil {
+        return nil, uuid.Nil, err
+    }
+    if msg.DeletedAt != nil {
+        return nil, uuid.Nil, fmt.Errorf("message was deleted: %w", ErrForbidden)
+    }
+    return msg, actor, nil
+}
+
+func reactionFrame(msgType string, msg *models.Message, userID, emoji string) []byte {
+    return websocket.NewFrame(msgType, map[string]interface{}{
+        "message_id": msg.ID,
+        "user_id":    userID,
+        "emoji":      emoji,
+    })
+}
+
+// GetMessageEdits returns the edit history of a message the user can see
+func (s *MessageService) GetMessageEdits(userID, messageID string) ([]models.MessageEdit, error) {
+    if _, err := s.getVisibleMessage(userID, messageID); err != nil {
+        return nil, err
+    }
+    return s.repo.GetEdits(messageID)
+}
+
+// getVisibleMessage loads a message the user may see; see Policy.VisibleMessage
+func (s *MessageService) getVisibleMessage(userID, messageID string) (*models.Message, error) {
+    return s.policy.VisibleMessage(userID, messageID)
+}
+
+// participantIDs lists the users who see a message: the conversation members
+// for group messages, otherwise the sender and the recipient
+func (s *MessageService) participantIDs(msg *models.Message) ([]string, error) {
+    if msg.ConversationID != nil {
+        memberIDs, err := s.conversationService.MemberIDs(msg.ConversationID.String())
+        if err != nil {
+            return nil, err
+        }
+        ids := make([]string, 0, len(memberIDs))
+        for _, id := range memberIDs {
+            ids = append(ids, id.String())
+        }
+        return ids, nil
+    }
+    ids := []string{msg.SenderID.String()}
+    if msg.RecipientID != uuid.Nil {
+        ids = append(ids, msg.RecipientID.String())
+    }
+    return ids, nil
+}
+
+// notifyParticipants pushes a frame to every device of every participant
+func (s *MessageService) notifyParticipants(msg *models.Message, frame []byte) {
+    if s.hub == nil {
+        return
+    }
+    ids, err := s.participantIDs(msg)
+    if err != nil {
+        return
+    }
+    s.hub.SendToUsers(ids, frame)
+}
+
+// TypingPeers lists who sees the user typing: the recipient of a direct chat
+// the user already has, or the other members of a group the user belongs to
+func (s *MessageService) TypingPeers(userID string, payload websocket.TypingPayload) ([]string, error) {
+    if payload.ConversationID == nil {
+        if payload.RecipientID == nil {
+            return nil, fmt.Errorf("recipient_id or conversation_id is required: %w", ErrInvalid)
+        }
+        if err := s.policy.CanSignalTyping(userID, *payload.RecipientID); err != nil {
+            return nil, err
+        }
+        return []string{payload.RecipientID.String()}, nil
+    }
+    conversationID := payload.ConversationID.String()
+    isMember, err := s.conversationService.IsMember(conversationID, userID)
+    if err != nil {
+        return nil, err
+    }
+    if !isMember {
+        return nil, fmt.Errorf("not a member of this conversation: %w", ErrForbidden)
+    }
+    memberIDs, err := s.conversationService.MemberIDs(conversationID)
+    if err != nil {
+        return nil, err
+    }
+    peers := make([]string, 0, len(memberIDs))
+    for _, id := range memberIDs {
+        if id.String() != userID {
+            peers = append(peers, id.String())
+        }
+    }
+    return peers, nil
+}
+
+// notifyConversation sends every participant their updated conversation list
+// entry for the message's conversation
+func (s *MessageService) notifyConversation(msg *models.Message) {
+    if s.hub == nil {
+        return
+    }
+    ids, err := s.participantIDs(msg)
+    if err != nil {
+        return
+    }
+    s.conversationService.NotifyMessage(msg, ids)
+}
+
+// resolveReferences validates the quoted message and thread root of a new
+// message: both must be visible to the sender and belong to the conversation
+// the message is sent to. Naming a reply as thread root joins its thread.
+func (s *MessageService) resolveReferences(req dto.SendMessageRequest) (*uuid.UUID, *uuid.UUID, error) {
+    if req.ReplyToID == nil && req.ThreadRootID == nil {
+        return nil, nil, nil
+    }
+    if req.IsBroadcast && req.ConversationID == nil {
+        return nil, nil, fmt.Errorf("broadcast messages cannot reply to other messages: %w", ErrInvalid)
+    }
+    var replyToID, threadRootID *uuid.UUID
+    if req.ReplyToID != nil {
+        ref, err := s.referencedMessage(req, *req.ReplyToID)
+        if err != nil {
+            return nil, nil, err
+        }
+        replyToID = &ref.ID
+    }
+    if req.ThreadRootID != nil {
+        ref, err := s.referencedMessage(req, *req.ThreadRootID)
+        if err != nil {
+            return nil, nil, err
+        }
+        root := ref.ID
+        if ref.ThreadRootID != nil {
+            root = *ref.ThreadRootID
+        }
+        threadRootID = &root
+    }
+    return replyToID, threadRootID, nil
+}
+
+func (s *MessageService) referencedMessage(req dto.SendMessageRequest, id uuid.UUID) (*models.Message, error) {
+    ref, err := s.getVisibleMessage(req.SenderID.String(), id.String())
+    if errors.Is(err, ErrNotFound) {
+        return nil, fmt.Errorf("referenced message %s not found: %w", id, ErrInvalid)
+    }
+    if err != nil {
+        return nil, err
+    }
+    if !sameConversation(ref, req) {
+        return nil, fmt.Errorf("referenced message %s is not part of this conversation: %w", id, ErrInvalid)
+    }
+    return ref, nil
+}
+
+// sameConversation reports whether a new message goes to the same group, or
+// the same pair of users, as an existing one
+func sameConversation(msg *models.Message, req dto.SendMessageRequest) bool {
+    if req.ConversationID != nil || msg.ConversationID != nil {
+        return req.ConversationID != nil && msg.ConversationID != nil && *req.ConversationID == *msg.ConversationID
+    }
+    return (msg.SenderID == req.SenderID && msg.RecipientID == req.RecipientID) ||
+        (msg.SenderID == req.RecipientID && msg.RecipientID == req.SenderID)
+}
+
+// GetThreadReplies returns a page of the replies in a message's thread, oldest first
+func (s *MessageService) GetThreadReplies(userID, messageID string, page dto.PageRequest) (dto.MessagePage, error) {
+    if _, err := s.getVisibleMessage(userID, messageID); err != nil {
+        return dto.MessagePage{}, err
+    }
+    return s.repo.GetThreadReplies(userID, messageID, page)
+}
+
+// UndeliveredMessages returns the messages a user has not acknowledged as
+// delivered, capped at MaxReplayMessages; clients page through the rest with sync.
+// It lets the hub replay them when the user reconnects.
+func (s *MessageService) UndeliveredMessages(userID string) ([]models.Message, error) {
+    return s.repo.GetUndeliveredForUser(userID, MaxReplayMessages)
+}
+
+// Statuses carried by message_status frames
+const (
+    StatusDelivered = "delivered"
+    StatusRead      = "read"
+)
+
+// SetDeliveredAt records that a recipient's device received the message and
+// tells the sender's devices with a message_status frame
 func (s *MessageService) SetDeliveredAt(messageID, recipientID string) error {
-    return s.recipientService.SetDeliveredAt(messageID, recipientID)
+    msg, err := s.policy.CanAcknowledge(recipientID, messageID)
+    if err != nil {
+        return err
+    }
+    deliveredAt := time.Now()
+    changed, err := s.recipientService.SetDeliveredAt(messageID, recipientID, deliveredAt)
+    if err != nil || !changed {
+        return err
+    }
+    s.notifyStatus(msg.SenderID.String(), recipientID, StatusDelivered, deliveredAt, []string{messageID})
+    return nil
+}
+
+// SetReadAt marks the message read for the recipient, tells the sender's
+// devices with a message_status frame and the recipient's other devices with
+// a read_state frame so their unread state stays in sync
+func (s *MessageService) SetReadAt(messageID, recipientID string, origin *websocket.Client) error {
+    msg, err := s.policy.CanAcknowledge(recipientID, messageID)
+    if err != nil {
+        return err
+    }
+    readAt := time.Now()
+    changed, err := s.recipientService.SetReadAt(messageID, recipientID, readAt)
+    if err != nil || !changed {
+        return err
+    }
+    s.notifyStatus(msg.SenderID.String(), recipientID, StatusRead, readAt, []string{messageID})
+    s.notifyReadState(recipientID, origin, readAt, messageID, []string{messageID})
+    s.conversationService.NotifyMessage(msg, []string{recipientID})
+    return nil
 }
 
-func (s *MessageService) SetReadAt(messageID, recipientID string) error {
-    return s.recipientService.SetReadAt(messageID, recipientID)
+// ReadUpTo marks read, in one step, every message the user received in the
+// conversation of messageID up to and including it. It returns the IDs of
+// the messages that were unread.
+func (s *MessageService) ReadUpTo(userID, messageID string, origin *websocket.Client) ([]string, error) {
+    msg, err := s.getVisibleMessage(userID, messageID)
+    if err != nil {
+        return nil, err
+    }
+    peerID := msg.SenderID.String()
+    if peerID == userID {
+        peerID = msg.RecipientID.String()
+    }
+    readAt := time.Now()
+    rows, err := s.recipientService.MarkReadUpTo(userID, msg.ConversationID, peerID, msg.CreatedAt, msg.ID, readAt)
+    if err != nil {
+        return nil, err
+    }
+    read := make([]string, 0, len(rows))
+    bySender := make(map[string][]string)
+    for _, row := range rows {
+        read = append(read, row.MessageID.String())
+        bySender[row.SenderID.String()] = append(bySender[row.SenderID.String()], row.MessageID.String())
+    }
+    for senderID, ids := range bySender {
+        s.notifyStatus(senderID, userID, StatusRead, readAt, ids)
+    }
+    if len(read) > 0 {
+        s.notifyReadState(userID, origin, readAt, messageID, read)
+        s.conversationService.NotifyMessage(msg, []string{userID})
+    }
+    return read, nil
+}
+
+// notifyStatus tells every device of a sender that a recipient received or read messages
+func (s *MessageService) notifyStatus(senderID, recipientID, status string, at time.Time, messageIDs []string) {
+    if s.hub == nil {
+        return
+    }
+    s.hub.SendToUsers([]string{senderID}, websocket.NewFrame("message_status", map[string]interface{}{
+        "message_ids":  messageIDs,
+        "recipient_id": recipientID,
+        "status":       status,
+        "at":           at,
+    }))
+}
+
+// notifyReadState tells the reader's other devices which messages were read;
+// message_id is the newest one, up to which the conversation is read
+func (s *MessageService) notifyReadState(userID string, origin *websocket.Client, readAt time.Time, upToID string, messageIDs []string) {
+    if s.hub == nil {
+        return
+    }
+    s.hub.SendToUserExcept(userID, origin, websocket.NewFrame("read_state", map[string]interface{}{
+        "message_id":  upToID,
+        "message_ids": messageIDs,
+        "read_at":     readAt,
+    }))
 }
 
-func (s *MessageService) GetMessagesBetweenUsers(user1ID, user2ID string) ([]models.Message, error) {
-    return s.repo.GetMessagesBetweenUsers(user1ID, user2ID)
+// GetMessagesBetweenUsers returns the 1:1 history of two users as seen by the
+// viewer, who must be one of them
+func (s *MessageService) GetMessagesBetweenUsers(viewerID, user1ID, user2ID string, page dto.PageRequest) (dto.MessagePage, error) {
+    if err := s.policy.CanViewDirectHistory(viewerID, user1ID, user2ID); err != nil {
+        return dto.MessagePage{}, err
+    }
+    return s.repo.GetMessagesBetweenUsers(viewerID, user1ID, user2ID, page)
+}
+
+// GetAllMessagesForUser returns everything a user sent or received; only that
+// user may read it
+func (s *MessageService) GetAllMessagesForUser(viewerID, userID string, page dto.PageRequest) (dto.MessagePage, error) {
+    if err := s.policy.CanViewUserHistory(viewerID, userID); err != nil {
+        return dto.MessagePage{}, err
+    }
+    return s.repo.GetAllMessagesForUser(userID, page)
+}
+
+// GetConversationMessages returns a group conversation's history if the user is a member
+func (s *MessageService) GetConversationMessages(userID, conversationID string, page dto.PageRequest) (dto.MessagePage, error) {
+    isMember, err := s.conversationService.IsMember(conversationID, userID)
+    if err != nil {
+        return dto.MessagePage{}, err
+    }
+    if !isMember {
+        return dto.MessagePage{}, fmt.Errorf("not a member of this conversation: %w", ErrForbidden)
+    }
+    return s.repo.GetConversationMessages(userID, conversationID, page)
 }
 
-func (s *MessageService) GetAllMessagesForUser(userID string) ([]models.Message, error) {
-    return s.repo.GetAllMessagesForUser(userID)
+// SearchMessages runs a full-text search over the messages the user can see
+func (s *MessageService) SearchMessages(userID string, req dto.SearchRequest) (dto.SearchPage, error) {
+    req.Query = strings.TrimSpace(req.Query)
+    if req.Query == "" {
+        return dto.SearchPage{}, fmt.Errorf("q is required: %w", ErrInvalid)
+    }
+    if len(req.Query) > dto.MaxSearchQueryLength {
+        return dto.SearchPage{}, fmt.Errorf("q must be at most %d characters: %w", dto.MaxSearchQueryLength, ErrInvalid)
+    }
+    if req.PeerID != "" {
+        if _, err := uuid.Parse(req.PeerID); err != nil {
+            return dto.SearchPage{}, fmt.Errorf("invalid peer: %w", ErrInvalid)
+        }
+    }
+    if req.From != nil && req.To != nil && !req.From.Before(*req.To) {
+        return dto.SearchPage{}, fmt.Errorf("from must be before to: %w", ErrInvalid)
+    }
+    return s.repo.Search(userID, req)
 }
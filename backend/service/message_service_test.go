@@ -0,0 +1,118 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"chatting-service-app/dto"
+	"chatting-service-app/models"
+
+	"github.com/google/uuid"
+)
+
+func TestBroadcastCopyKeepsAttachmentAndClientID(t *testing.T) {
+	attachmentID := uuid.New()
+	clientMessageID := "key"
+	msg := &models.Message{
+		ID:              uuid.New(),
+		SenderID:        uuid.New(),
+		Content:         "to everyone",
+		MediaURL:        attachmentsPrefix + attachmentID.String(),
+		AttachmentID:    &attachmentID,
+		IsBroadcast:     true,
+		CreatedAt:       time.Now(),
+		ClientMessageID: &clientMessageID,
+	}
+	bob := uuid.New()
+	msgCopy := broadcastCopy(msg, bob)
+	if msgCopy.RecipientID != bob || !msgCopy.IsBroadcast || msgCopy.Content != msg.Content {
+		t.Fatalf("copy = %+v, want bob's copy of the broadcast", msgCopy)
+	}
+	// Recipients may only open attachments of messages that reference them
+	if msgCopy.AttachmentID == nil || *msgCopy.AttachmentID != attachmentID {
+		t.Fatalf("copy attachment = %v, want %s", msgCopy.AttachmentID, attachmentID)
+	}
+	if want := "key/" + bob.String(); msgCopy.ClientMessageID == nil || *msgCopy.ClientMessageID != want {
+		t.Fatalf("copy client key = %v, want %s", msgCopy.ClientMessageID, want)
+	}
+	if !msgCopy.CreatedAt.Equal(msg.CreatedAt) {
+		t.Fatalf("copy created at %s, want the original's %s", msgCopy.CreatedAt, msg.CreatedAt)
+	}
+
+	msg.ClientMessageID = nil
+	if msgCopy := broadcastCopy(msg, bob); msgCopy.ClientMessageID != nil {
+		t.Fatalf("copy client key = %s, want none without the original's", *msgCopy.ClientMessageID)
+	}
+}
+
+// decodeSend decodes a send request body the way the message endpoints do
+func decodeSend(t *testing.T, createdAt string) dto.SendMessageRequest {
+	t.Helper()
+	body := `{"sender_id":"` + uuid.NewString() + `","recipient_id":"` + uuid.NewString() +
+		`","content":"hi","created_at":"` + createdAt + `"}`
+	var req dto.SendMessageRequest
+	if err := json.Unmarshal([]byte(body), &req); err != nil {
+		t.Fatal(err)
+	}
+	return req
+}
+
+func TestNewMessageIgnoresClientCreatedAt(t *testing.T) {
+	tests := []struct {
+		name      string
+		createdAt string
+	}{
+		{"backdated", "2001-01-01T00:00:00Z"},
+		// Would keep delete-for-everyone open for good
+		{"future", "2999-01-01T00:00:00Z"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := decodeSend(t, tt.createdAt)
+			before := time.Now()
+			msg := newMessage(req, nil, nil, nil)
+			if msg.CreatedAt.Before(before) || msg.CreatedAt.After(time.Now()) {
+				t.Fatalf("created at %s, want the server's time", msg.CreatedAt)
+			}
+		})
+	}
+}
+
+// afterCursor is the keyset condition of an after page: (created_at, id) > cursor
+func afterCursor(msg *models.Message, cursor *dto.Cursor) bool {
+	if !msg.CreatedAt.Equal(cursor.CreatedAt) {
+		return msg.CreatedAt.After(cursor.CreatedAt)
+	}
+	return bytes.Compare(msg.ID[:], cursor.ID[:]) > 0
+}
+
+func TestSkewedMessagesPageAfterHeldCursors(t *testing.T) {
+	tests := []struct {
+		name          string
+		first, second string
+		firstIsSkewed bool
+	}{
+		// A backdated message would land behind the cursor and never be synced
+		{"backdated", "", "2001-01-01T00:00:00Z", false},
+		// A future-dated message would push the cursor past everything sent after it
+		{"future", "2999-01-01T00:00:00Z", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			first := newMessage(decodeSend(t, tt.first), nil, nil, nil)
+			first.ID = uuid.New()
+			// The client pages up to the first message and holds its cursor
+			cursor, err := dto.DecodeCursor(dto.Cursor{CreatedAt: first.CreatedAt, ID: first.ID}.Encode())
+			if err != nil {
+				t.Fatal(err)
+			}
+			second := newMessage(decodeSend(t, tt.second), nil, nil, nil)
+			second.ID = uuid.New()
+			if !afterCursor(second, cursor) {
+				t.Fatalf("message sent at %s is behind the cursor at %s, so the next page skips it", second.CreatedAt, cursor.CreatedAt)
+			}
+		})
+	}
+}
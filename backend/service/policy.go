@@ -0,0 +1,181 @@
+package service
+
+import (
+	"chatting-service-app/models"
+	"chatting-service-app/repository"
+	"fmt"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// attachmentsPrefix starts the media_url of messages that carry an attachment
+const attachmentsPrefix = "/attachments/"
+
+// messageLookup, attachmentLookup, userLookup and memberLookup are the reads
+// Policy needs; the repositories and ConversationService provide them in
+// production
+type messageLookup interface {
+	GetByID(id string) (*models.Message, error)
+	UserCanSeeAttachment(userID, attachmentID string) (bool, error)
+}
+
+type attachmentLookup interface {
+	GetByID(id string) (*models.Attachment, error)
+}
+
+type userLookup interface {
+	GetUserByID(id string) (*models.User, error)
+	AreContacts(userID, otherID string) (bool, error)
+}
+
+type memberLookup interface {
+	IsMember(conversationID, userID string) (bool, error)
+}
+
+// Policy decides what a user may do with messages and files. Only the
+// participants of a message may read it, acknowledge it or download its attachment;
+// other users get ErrNotFound or ErrForbidden.
+type Policy struct {
+	messages      messageLookup
+	attachments   attachmentLookup
+	users         userLookup
+	conversations memberLookup
+}
+
+func NewPolicy(messages *repository.MessageRepository, attachments *repository.AttachmentRepository, users *repository.UserRepository, conversations *ConversationService) *Policy {
+	return &Policy{messages: messages, attachments: attachments, users: users, conversations: conversations}
+}
+
+// VisibleMessage loads a message the actor sent, received or can see as a
+// member of its conversation. Anything else is reported as not found.
+func (p *Policy) VisibleMessage(actorID, messageID string) (*models.Message, error) {
+	if _, err := uuid.Parse(messageID); err != nil {
+		return nil, fmt.Errorf("message not found: %w", ErrNotFound)
+	}
+	msg, err := p.messages.GetByID(messageID)
+	if err != nil {
+		return nil, err
+	}
+	if msg == nil {
+		return nil, fmt.Errorf("message not found: %w", ErrNotFound)
+	}
+	if msg.SenderID.String() == actorID || msg.RecipientID.String() == actorID {
+		return msg, nil
+	}
+	if msg.ConversationID != nil {
+		isMember, err := p.conversations.IsMember(msg.ConversationID.String(), actorID)
+		if err != nil {
+			return nil, err
+		}
+		if isMember {
+			return msg, nil
+		}
+	}
+	return nil, fmt.Errorf("message not found: %w", ErrNotFound)
+}
+
+// CanMessageDirectly allows a direct message to any user that exists
+func (p *Policy) CanMessageDirectly(actorID string, recipientID uuid.UUID) error {
+	if recipientID == uuid.Nil {
+		return fmt.Errorf("recipient_id or conversation_id is required: %w", ErrInvalid)
+	}
+	recipient, err := p.users.GetUserByID(recipientID.String())
+	if err != nil {
+		return err
+	}
+	if recipient == nil {
+		return fmt.Errorf("recipient not found: %w", ErrNotFound)
+	}
+	return nil
+}
+
+// CanSignalTyping allows typing indicators in a direct chat only towards a
+// user the actor may message and already shares a chat or group with, so
+// they cannot be used to reach strangers
+func (p *Policy) CanSignalTyping(actorID string, recipientID uuid.UUID) error {
+	if recipientID.String() == actorID {
+		return fmt.Errorf("recipient_id or conversation_id is required: %w", ErrInvalid)
+	}
+	if err := p.CanMessageDirectly(actorID, recipientID); err != nil {
+		return err
+	}
+	shared, err := p.users.AreContacts(actorID, recipientID.String())
+	if err != nil {
+		return err
+	}
+	if !shared {
+		return fmt.Errorf("no chat with this user yet: %w", ErrForbidden)
+	}
+	return nil
+}
+
+// CanViewDirectHistory allows the 1:1 history of two users only to one of them
+func (p *Policy) CanViewDirectHistory(actorID, user1ID, user2ID string) error {
+	if actorID != user1ID && actorID != user2ID {
+		return fmt.Errorf("only participants can read this conversation: %w", ErrForbidden)
+	}
+	return nil
+}
+
+// CanViewUserHistory allows the combined history of a user only to that user
+func (p *Policy) CanViewUserHistory(actorID, userID string) error {
+	if actorID != userID {
+		return fmt.Errorf("cannot read another user's messages: %w", ErrForbidden)
+	}
+	return nil
+}
+
+// CanAcknowledge allows delivery and read receipts only from a recipient of
+// the message, never from its sender or a user who cannot see it. It returns
+// the message.
+func (p *Policy) CanAcknowledge(actorID, messageID string) (*models.Message, error) {
+	msg, err := p.VisibleMessage(actorID, messageID)
+	if err != nil {
+		return nil, err
+	}
+	if msg.SenderID.String() == actorID {
+		return nil, fmt.Errorf("the sender cannot acknowledge their own message: %w", ErrForbidden)
+	}
+	return msg, nil
+}
+
+// CanAttach allows a message to carry an attachment its sender uploaded or
+// can already see in another message, so attaching cannot leak other files.
+// It returns the attachment ID behind mediaURL, or nil when there is none.
+func (p *Policy) CanAttach(actorID, mediaURL string) (*uuid.UUID, error) {
+	if mediaURL == "" {
+		return nil, nil
+	}
+	attachment, err := p.VisibleAttachment(actorID, strings.TrimPrefix(mediaURL, attachmentsPrefix))
+	if err != nil {
+		return nil, fmt.Errorf("media_url must be an attachment you uploaded or can see: %w", ErrForbidden)
+	}
+	return &attachment.ID, nil
+}
+
+// VisibleAttachment loads an attachment the actor uploaded or can see in a
+// message. Anything else is reported as not found.
+func (p *Policy) VisibleAttachment(actorID, attachmentID string) (*models.Attachment, error) {
+	if _, err := uuid.Parse(attachmentID); err != nil {
+		return nil, fmt.Errorf("attachment not found: %w", ErrNotFound)
+	}
+	attachment, err := p.attachments.GetByID(attachmentID)
+	if err != nil {
+		return nil, err
+	}
+	if attachment == nil {
+		return nil, fmt.Errorf("attachment not found: %w", ErrNotFound)
+	}
+	if attachment.OwnerID.String() == actorID {
+		return attachment, nil
+	}
+	visible, err := p.messages.UserCanSeeAttachment(actorID, attachmentID)
+	if err != nil {
+		return nil, err
+	}
+	if !visible {
+		return nil, fmt.Errorf("attachment not found: %w", ErrNotFound)
+	}
+	return attachment, nil
+}
@@ -0,0 +1,282 @@
+package service
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"chatting-service-app/models"
+
+	"github.com/google/uuid"
+)
+
+// fakeMessages, fakeAttachments, fakeUsers and fakeMembers answer the policy's lookups
+// from memory, the way the repositories answer them from the database
+type fakeMessages struct {
+	byID    map[string]*models.Message
+	members fakeMembers
+}
+
+func (f fakeMessages) GetByID(id string) (*models.Message, error) {
+	return f.byID[id], nil
+}
+
+func (f fakeMessages) UserCanSeeAttachment(userID, attachmentID string) (bool, error) {
+	for _, msg := range f.byID {
+		if msg.AttachmentID == nil || msg.AttachmentID.String() != attachmentID || msg.DeletedAt != nil {
+			continue
+		}
+		if msg.SenderID.String() == userID || msg.RecipientID.String() == userID {
+			return true, nil
+		}
+		if msg.ConversationID != nil {
+			if isMember, _ := f.members.IsMember(msg.ConversationID.String(), userID); isMember {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+type fakeAttachments map[string]*models.Attachment
+
+func (f fakeAttachments) GetByID(id string) (*models.Attachment, error) {
+	return f[id], nil
+}
+
+type fakeUsers struct {
+	known    map[string]bool
+	contacts map[string][]string
+}
+
+func (f fakeUsers) GetUserByID(id string) (*models.User, error) {
+	if !f.known[id] {
+		return nil, nil
+	}
+	return &models.User{ID: uuid.MustParse(id)}, nil
+}
+
+func (f fakeUsers) AreContacts(userID, otherID string) (bool, error) {
+	for _, id := range f.contacts[userID] {
+		if id == otherID {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+type fakeMembers map[string][]string
+
+func (f fakeMembers) IsMember(conversationID, userID string) (bool, error) {
+	for _, id := range f[conversationID] {
+		if id == userID {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// policyFixture is a direct message from alice to bob carrying alice's file,
+// and a group message from alice to bob and carol; eve takes part in neither
+type policyFixture struct {
+	policy                   *Policy
+	alice, bob, carol, eve   string
+	direct, group            string
+	file, deletedFile, other string
+}
+
+func newPolicyFixture() policyFixture {
+	alice, bob, carol, eve := uuid.New(), uuid.New(), uuid.New(), uuid.New()
+	conversationID := uuid.New()
+	file := &models.Attachment{ID: uuid.New(), OwnerID: alice, FileName: "plan.txt"}
+	deletedFile := &models.Attachment{ID: uuid.New(), OwnerID: alice, FileName: "old.txt"}
+	other := &models.Attachment{ID: uuid.New(), OwnerID: carol, FileName: "other.txt"}
+	deletedAt := time.Now()
+	direct := &models.Message{ID: uuid.New(), SenderID: alice, RecipientID: bob, AttachmentID: &file.ID}
+	group := &models.Message{ID: uuid.New(), SenderID: alice, ConversationID: &conversationID}
+	deleted := &models.Message{ID: uuid.New(), SenderID: alice, RecipientID: bob, AttachmentID: &deletedFile.ID, DeletedAt: &deletedAt}
+	members := fakeMembers{conversationID.String(): {alice.String(), bob.String(), carol.String()}}
+	messages := fakeMessages{
+		byID: map[string]*models.Message{
+			direct.ID.String():  direct,
+			group.ID.String():   group,
+			deleted.ID.String(): deleted,
+		},
+		members: members,
+	}
+	users := fakeUsers{
+		known: map[string]bool{alice.String(): true, bob.String(): true, carol.String(): true, eve.String(): true},
+		contacts: map[string][]string{
+			alice.String(): {bob.String(), carol.String()},
+			bob.String():   {alice.String(), carol.String()},
+			carol.String(): {alice.String(), bob.String()},
+		},
+	}
+	attachments := fakeAttachments{
+		file.ID.String():        file,
+		deletedFile.ID.String(): deletedFile,
+		other.ID.String():       other,
+	}
+	return policyFixture{
+		policy:      &Policy{messages: messages, attachments: attachments, users: users, conversations: members},
+		alice:       alice.String(),
+		bob:         bob.String(),
+		carol:       carol.String(),
+		eve:         eve.String(),
+		direct:      direct.ID.String(),
+		group:       group.ID.String(),
+		file:        file.ID.String(),
+		deletedFile: deletedFile.ID.String(),
+		other:       other.ID.String(),
+	}
+}
+
+func TestPolicyRefusesNonParticipants(t *testing.T) {
+	f := newPolicyFixture()
+	p := f.policy
+
+	tests := []struct {
+		name string
+		call func() error
+		want error
+	}{
+		{"direct message", func() error {
+			_, err := p.VisibleMessage(f.eve, f.direct)
+			return err
+		}, ErrNotFound},
+		{"group message", func() error {
+			_, err := p.VisibleMessage(f.eve, f.group)
+			return err
+		}, ErrNotFound},
+		{"malformed message id", func() error {
+			_, err := p.VisibleMessage(f.alice, "not-a-uuid")
+			return err
+		}, ErrNotFound},
+		{"unknown message", func() error {
+			_, err := p.VisibleMessage(f.alice, uuid.NewString())
+			return err
+		}, ErrNotFound},
+		{"direct history", func() error {
+			return p.CanViewDirectHistory(f.eve, f.alice, f.bob)
+		}, ErrForbidden},
+		{"user history", func() error {
+			return p.CanViewUserHistory(f.eve, f.alice)
+		}, ErrForbidden},
+		{"receipt from a stranger", func() error {
+			_, err := p.CanAcknowledge(f.eve, f.direct)
+			return err
+		}, ErrNotFound},
+		{"receipt from the sender", func() error {
+			_, err := p.CanAcknowledge(f.alice, f.direct)
+			return err
+		}, ErrForbidden},
+		{"attachment", func() error {
+			_, err := p.VisibleAttachment(f.eve, f.file)
+			return err
+		}, ErrNotFound},
+		{"attachment of a deleted message", func() error {
+			_, err := p.VisibleAttachment(f.bob, f.deletedFile)
+			return err
+		}, ErrNotFound},
+		{"malformed attachment id", func() error {
+			_, err := p.VisibleAttachment(f.alice, "../main.go")
+			return err
+		}, ErrNotFound},
+		{"message without a recipient", func() error {
+			return p.CanMessageDirectly(f.eve, uuid.Nil)
+		}, ErrInvalid},
+		{"message nobody", func() error {
+			return p.CanMessageDirectly(f.eve, uuid.New())
+		}, ErrNotFound},
+		{"typing to a stranger", func() error {
+			return p.CanSignalTyping(f.eve, uuid.MustParse(f.alice))
+		}, ErrForbidden},
+		{"typing to nobody", func() error {
+			return p.CanSignalTyping(f.eve, uuid.New())
+		}, ErrNotFound},
+		{"typing to oneself", func() error {
+			return p.CanSignalTyping(f.eve, uuid.MustParse(f.eve))
+		}, ErrInvalid},
+		{"attach another user's file", func() error {
+			_, err := p.CanAttach(f.eve, attachmentsPrefix+f.file)
+			return err
+		}, ErrForbidden},
+		{"attach an unshared file", func() error {
+			_, err := p.CanAttach(f.alice, attachmentsPrefix+f.other)
+			return err
+		}, ErrForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.call(); !errors.Is(err, tt.want) {
+				t.Fatalf("got %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestPolicyAllowsParticipants(t *testing.T) {
+	f := newPolicyFixture()
+	p := f.policy
+
+	tests := []struct {
+		name string
+		call func() error
+	}{
+		{"sender reads", func() error {
+			_, err := p.VisibleMessage(f.alice, f.direct)
+			return err
+		}},
+		{"recipient reads", func() error {
+			_, err := p.VisibleMessage(f.bob, f.direct)
+			return err
+		}},
+		{"member reads the group", func() error {
+			_, err := p.VisibleMessage(f.carol, f.group)
+			return err
+		}},
+		{"direct history", func() error {
+			return p.CanViewDirectHistory(f.bob, f.alice, f.bob)
+		}},
+		{"own history", func() error {
+			return p.CanViewUserHistory(f.alice, f.alice)
+		}},
+		{"recipient acknowledges", func() error {
+			_, err := p.CanAcknowledge(f.bob, f.direct)
+			return err
+		}},
+		{"member acknowledges", func() error {
+			_, err := p.CanAcknowledge(f.carol, f.group)
+			return err
+		}},
+		{"owner opens the attachment", func() error {
+			_, err := p.VisibleAttachment(f.alice, f.file)
+			return err
+		}},
+		{"recipient opens the attachment", func() error {
+			_, err := p.VisibleAttachment(f.bob, f.file)
+			return err
+		}},
+		{"recipient forwards a file they were sent", func() error {
+			_, err := p.CanAttach(f.bob, attachmentsPrefix+f.file)
+			return err
+		}},
+		{"message any user", func() error {
+			return p.CanMessageDirectly(f.eve, uuid.MustParse(f.alice))
+		}},
+		{"typing in a shared chat", func() error {
+			return p.CanSignalTyping(f.bob, uuid.MustParse(f.alice))
+		}},
+		{"no attachment", func() error {
+			_, err := p.CanAttach(f.eve, "")
+			return err
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.call(); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
@@ -0,0 +1,139 @@
+package service
+
+import (
+	"chatting-service-app/dto"
+	"chatting-service-app/models"
+	"chatting-service-app/repository"
+	"chatting-service-app/websocket"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// PresenceService manages the statuses users choose and how users appear to each other
+type PresenceService struct {
+	repo *repository.UserRepository
+	hub  *websocket.Hub
+}
+
+func NewPresenceService(repo *repository.UserRepository, hub *websocket.Hub) *PresenceService {
+	return &PresenceService{repo: repo, hub: hub}
+}
+
+// Settings returns the user's own presence choices
+func (s *PresenceService) Settings(userID string) (dto.PresenceSettings, error) {
+	user, err := s.getUser(userID)
+	if err != nil {
+		return dto.PresenceSettings{}, err
+	}
+	settings := dto.PresenceSettings{
+		Status:             user.PresenceStatus,
+		LastSeenVisibility: user.LastSeenVisibility,
+	}
+	if user.CustomStatusExpiresAt == nil || user.CustomStatusExpiresAt.After(time.Now()) {
+		settings.CustomStatus = user.CustomStatus
+		settings.CustomStatusExpiresAt = user.CustomStatusExpiresAt
+	}
+	return settings, nil
+}
+
+// UpdateSettings changes the user's status, custom status or last-seen privacy
+// and re-announces their presence. Changing status while connected moves
+// last_seen_at, so going invisible shows the moment the user seemed to leave.
+func (s *PresenceService) UpdateSettings(userID string, req dto.UpdatePresenceRequest) (dto.PresenceSettings, error) {
+	user, err := s.getUser(userID)
+	if err != nil {
+		return dto.PresenceSettings{}, err
+	}
+	fields := map[string]interface{}{}
+	if req.Status != nil {
+		switch *req.Status {
+		case models.PresenceOnline, models.PresenceAway, models.PresenceDoNotDisturb, models.PresenceInvisible:
+		default:
+			return dto.PresenceSettings{}, fmt.Errorf("status must be online, away, dnd or invisible: %w", ErrInvalid)
+		}
+		if *req.Status != user.PresenceStatus {
+			fields["presence_status"] = *req.Status
+			if user.IsOnline {
+				fields["last_seen_at"] = time.Now()
+			}
+		}
+	}
+	if req.CustomStatusExpiresAt != nil && req.CustomStatus == nil {
+		return dto.PresenceSettings{}, fmt.Errorf("custom_status_expires_at requires custom_status: %w", ErrInvalid)
+	}
+	if req.CustomStatus != nil {
+		text := strings.TrimSpace(*req.CustomStatus)
+		if utf8.RuneCountInString(text) > dto.MaxCustomStatusLength {
+			return dto.PresenceSettings{}, fmt.Errorf("custom_status must be at most %d characters: %w", dto.MaxCustomStatusLength, ErrInvalid)
+		}
+		if req.CustomStatusExpiresAt != nil && !req.CustomStatusExpiresAt.After(time.Now()) {
+			return dto.PresenceSettings{}, fmt.Errorf("custom_status_expires_at must be in the future: %w", ErrInvalid)
+		}
+		fields["custom_status"] = text
+		fields["custom_status_expires_at"] = nil
+		if text != "" && req.CustomStatusExpiresAt != nil {
+			fields["custom_status_expires_at"] = *req.CustomStatusExpiresAt
+		}
+	}
+	if req.LastSeenVisibility != nil {
+		switch *req.LastSeenVisibility {
+		case models.LastSeenEveryone, models.LastSeenContacts, models.LastSeenNobody:
+		default:
+			return dto.PresenceSettings{}, fmt.Errorf("last_seen_visibility must be everyone, contacts or nobody: %w", ErrInvalid)
+		}
+		fields["last_seen_visibility"] = *req.LastSeenVisibility
+	}
+	if len(fields) > 0 {
+		if err := s.repo.UpdatePresence(userID, fields); err != nil {
+			return dto.PresenceSettings{}, err
+		}
+		if s.hub != nil {
+			s.hub.RefreshPresence(userID)
+		}
+	}
+	return s.Settings(userID)
+}
+
+// Describe returns how each user appears to the viewer, showing last_seen_at
+// only where the user's privacy setting allows it
+func (s *PresenceService) Describe(viewerID string, users []models.User) ([]dto.UserPresence, error) {
+	var contacts map[string]bool
+	result := make([]dto.UserPresence, 0, len(users))
+	for i := range users {
+		user := &users[i]
+		show := user.ID.String() == viewerID
+		switch user.LastSeenVisibility {
+		case models.LastSeenEveryone:
+			show = true
+		case models.LastSeenContacts:
+			if contacts == nil {
+				// Sharing a chat is symmetric, so the viewer's contacts are
+				// exactly the users who count the viewer as a contact
+				ids, err := s.repo.ContactIDs(viewerID)
+				if err != nil {
+					return nil, err
+				}
+				contacts = make(map[string]bool, len(ids))
+				for _, id := range ids {
+					contacts[id] = true
+				}
+			}
+			show = show || contacts[user.ID.String()]
+		}
+		result = append(result, dto.NewUserPresence(user, show))
+	}
+	return result, nil
+}
+
+func (s *PresenceService) getUser(userID string) (*models.User, error) {
+	user, err := s.repo.GetUserByID(userID)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, fmt.Errorf("user not found: %w", ErrNotFound)
+	}
+	return user, nil
+}
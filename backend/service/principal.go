@@ -0,0 +1,30 @@
+package service
+
+import (
+	"chatting-service-app/utils"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Principal is the authenticated user a request acts for
+type Principal struct {
+	UserID    string
+	SessionID string
+	ExpiresAt time.Time
+}
+
+// Authenticate verifies an access token and its session and returns who it
+// acts for. Handlers use the principal instead of any user ID carried in the
+// request itself.
+func Authenticate(tokenStr string) (Principal, error) {
+	claims, err := utils.AuthenticateJWT(tokenStr)
+	if err != nil {
+		return Principal{}, fmt.Errorf("invalid or missing token: %w", ErrUnauthorized)
+	}
+	if _, err := uuid.Parse(claims.UserID); err != nil {
+		return Principal{}, fmt.Errorf("invalid token subject: %w", ErrUnauthorized)
+	}
+	return Principal{UserID: claims.UserID, SessionID: claims.SessionID, ExpiresAt: claims.ExpiresAt}, nil
+}
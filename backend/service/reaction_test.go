@@ -0,0 +1,44 @@
+package service
+
+import "testing"
+
+func TestIsEmoji(t *testing.T) {
+	tests := []struct {
+		emoji string
+		want  bool
+	}{
+		{"👍", true},
+		{"❤️", true},
+		{"👍🏽", true},
+		{"👨‍👩‍👧", true},
+		{"🇩🇪", true},
+		{"🏴󠁧󠁢󠁥󠁮󠁧󠁿", true},
+		{"1️⃣", true},
+		{"#️⃣", true},
+		{"", false},
+		{"lol", false},
+		{"1", false},
+		{"👍 👍", false},
+		{"a👍", false},
+		{"‍", false},
+		{"<script>", false},
+		{"©", false},
+		{"°", false},
+		{"👍👍", false},
+		{"🇩", false},
+		{"🏽", false},
+		{"👍‍", false},
+		{"👍‍👍‍", false},
+		{"1⃣⃣", false},
+		{"🏴󠁧󠁢", false},
+		{"©️", true},
+		{"☝🏽", true},
+		{"🏃‍♀️", true},
+		{"🧑🏽‍💻", true},
+	}
+	for _, tt := range tests {
+		if got := isEmoji(tt.emoji); got != tt.want {
+			t.Errorf("isEmoji(%q) = %v, want %v", tt.emoji, got, tt.want)
+		}
+	}
+}
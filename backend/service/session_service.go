@@ -0,0 +1,218 @@
+package service
+
+import (
+	"chatting-service-app/models"
+	"chatting-service-app/repository"
+	"chatting-service-app/utils"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// lastUsedResolution limits how often LastUsedAt is written for a busy session
+const lastUsedResolution = time.Minute
+
+type SessionService struct {
+	repo        *repository.SessionRepository
+	refreshRepo *repository.RefreshTokenRepository
+	hub         SessionDisconnector
+}
+
+// ErrRefreshTokenReused is returned when an already rotated refresh token is
+// presented again; the whole token family has been revoked as a precaution.
+var ErrRefreshTokenReused = fmt.Errorf("refresh token reuse detected: %w", ErrUnauthorized)
+
+// TokenPair is what a login or refresh hands back to the client
+type TokenPair struct {
+	AccessToken      string
+	AccessExpiresAt  time.Time
+	RefreshToken     string
+	RefreshExpiresAt time.Time
+}
+
+// SessionDisconnector closes live connections when sessions are revoked;
+// *websocket.Hub implements it.
+type SessionDisconnector interface {
+	DisconnectSession(sessionID string)
+	DisconnectUser(userID string)
+}
+
+func NewSessionService(repo *repository.SessionRepository, refreshRepo *repository.RefreshTokenRepository, hub SessionDisconnector) *SessionService {
+	return &SessionService{repo: repo, refreshRepo: refreshRepo, hub: hub}
+}
+
+// CreateSession records a new device login and returns its first token pair
+func (s *SessionService) CreateSession(userID uuid.UUID, userAgent, ipAddress string) (*models.Session, *TokenPair, error) {
+	now := time.Now()
+	session := &models.Session{
+		UserID:     userID,
+		UserAgent:  userAgent,
+		IPAddress:  ipAddress,
+		ExpiresAt:  now.Add(utils.RefreshTokenTTL()),
+		CreatedAt:  now,
+		LastUsedAt: &now,
+	}
+	if err := s.repo.Create(session); err != nil {
+		return nil, nil, err
+	}
+	pair, err := s.issueTokens(session, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	return session, pair, nil
+}
+
+// Refresh rotates a refresh token: the presented token is consumed and a new
+// access/refresh pair is issued in the same family. Presenting a consumed
+// token revokes the family and disconnects the session.
+func (s *SessionService) Refresh(refreshToken string) (*TokenPair, error) {
+	now := time.Now()
+	tokenHash := utils.HashRefreshToken(refreshToken)
+	consumed, err := s.refreshRepo.MarkUsed(tokenHash, now)
+	if err != nil {
+		return nil, err
+	}
+	stored, err := s.refreshRepo.GetByHash(tokenHash)
+	if err != nil {
+		return nil, err
+	}
+	if stored == nil {
+		return nil, fmt.Errorf("invalid refresh token: %w", ErrUnauthorized)
+	}
+	if !consumed {
+		if stored.RevokedAt == nil {
+			// A legitimately rotated token came back: assume it was stolen
+			_ = s.revokeFamily(stored.SessionID.String())
+			return nil, ErrRefreshTokenReused
+		}
+		return nil, fmt.Errorf("refresh token revoked: %w", ErrUnauthorized)
+	}
+	if now.After(stored.ExpiresAt) {
+		return nil, fmt.Errorf("refresh token expired: %w", ErrUnauthorized)
+	}
+	session, err := s.repo.GetByID(stored.SessionID.String())
+	if err != nil {
+		return nil, err
+	}
+	if session == nil || session.RevokedAt != nil {
+		return nil, fmt.Errorf("session revoked: %w", ErrUnauthorized)
+	}
+	session.ExpiresAt = now.Add(utils.RefreshTokenTTL())
+	if err := s.repo.ExtendExpiry(session.ID.String(), session.ExpiresAt); err != nil {
+		return nil, err
+	}
+	return s.issueTokens(session, &stored.ID)
+}
+
+func (s *SessionService) issueTokens(session *models.Session, parentID *uuid.UUID) (*TokenPair, error) {
+	now := time.Now()
+	accessExpiresAt := now.Add(utils.AccessTokenTTL())
+	if accessExpiresAt.After(session.ExpiresAt) {
+		accessExpiresAt = session.ExpiresAt
+	}
+	accessToken, err := utils.GenerateJWT(session.UserID.String(), session.ID.String(), accessExpiresAt)
+	if err != nil {
+		return nil, err
+	}
+	refreshToken, refreshHash, err := utils.GenerateRefreshToken()
+	if err != nil {
+		return nil, err
+	}
+	stored := &models.RefreshToken{
+		SessionID: session.ID,
+		TokenHash: refreshHash,
+		ParentID:  parentID,
+		ExpiresAt: session.ExpiresAt,
+		CreatedAt: now,
+	}
+	if err := s.refreshRepo.Create(stored); err != nil {
+		return nil, err
+	}
+	return &TokenPair{
+		AccessToken:      accessToken,
+		AccessExpiresAt:  accessExpiresAt,
+		RefreshToken:     refreshToken,
+		RefreshExpiresAt: session.ExpiresAt,
+	}, nil
+}
+
+func (s *SessionService) revokeFamily(sessionID string) error {
+	now := time.Now()
+	if err := s.refreshRepo.RevokeFamily(sessionID, now); err != nil {
+		return err
+	}
+	if err := s.repo.Revoke(sessionID, now); err != nil {
+		return err
+	}
+	if s.hub != nil {
+		s.hub.DisconnectSession(sessionID)
+	}
+	return nil
+}
+
+// ValidateSession implements utils.SessionValidator: the session must exist,
+// belong to the user, and be neither revoked nor expired
+func (s *SessionService) ValidateSession(sessionID, userID string) error {
+	if _, err := uuid.Parse(sessionID); err != nil {
+		return errors.New("invalid session")
+	}
+	session, err := s.repo.GetByID(sessionID)
+	if err != nil {
+		return err
+	}
+	if session == nil || session.UserID.String() != userID {
+		return errors.New("invalid session")
+	}
+	now := time.Now()
+	if session.RevokedAt != nil {
+		return fmt.Errorf("session revoked: %w", ErrUnauthorized)
+	}
+	if now.After(session.ExpiresAt) {
+		return errors.New("session expired")
+	}
+	if session.LastUsedAt == nil || now.Sub(*session.LastUsedAt) > lastUsedResolution {
+		_ = s.repo.TouchLastUsed(sessionID, now)
+	}
+	return nil
+}
+
+func (s *SessionService) ListSessions(userID string) ([]models.Session, error) {
+	return s.repo.GetActiveByUser(userID)
+}
+
+// Logout revokes the current session with its refresh tokens and closes its WebSocket connections
+func (s *SessionService) Logout(sessionID string) error {
+	return s.revokeFamily(sessionID)
+}
+
+// LogoutAll revokes every session of the user and closes all their connections
+func (s *SessionService) LogoutAll(userID string) error {
+	now := time.Now()
+	if err := s.refreshRepo.RevokeAllForUser(userID, now); err != nil {
+		return err
+	}
+	if err := s.repo.RevokeAllForUser(userID, now); err != nil {
+		return err
+	}
+	if s.hub != nil {
+		s.hub.DisconnectUser(userID)
+	}
+	return nil
+}
+
+// RevokeSession revokes one of the user's own sessions, e.g. a lost device
+func (s *SessionService) RevokeSession(userID, sessionID string) error {
+	if _, err := uuid.Parse(sessionID); err != nil {
+		return fmt.Errorf("session not found: %w", ErrNotFound)
+	}
+	session, err := s.repo.GetByID(sessionID)
+	if err != nil {
+		return err
+	}
+	if session == nil || session.UserID.String() != userID {
+		return fmt.Errorf("session not found: %w", ErrNotFound)
+	}
+	return s.Logout(sessionID)
+}
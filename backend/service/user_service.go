@@ -1,10 +1,13 @@
 package service
 
 import (
+    "context"
     "errors"
+    "fmt"
     "chatting-service-app/models"
     "chatting-service-app/repository"
     "chatting-service-app/utils"
+    "time"
 )
 
 type UserService struct {
@@ -17,17 +20,17 @@ func NewUserService(repo *repository.UserRepository) *UserService {
 
 func (s *UserService) SignUp(username, email, password string) error {
     if username == "" || email == "" || password == "" {
-        return errors.New("all fields are required")
+        return fmt.Errorf("all fields are required: %w", ErrInvalid)
     }
 
     existingUser, _ := s.repo.GetUserByUsername(username)
     if existingUser != nil {
-        return errors.New("username already taken")
+        return fmt.Errorf("username already taken: %w", ErrInvalid)
     }
 
     existingEmail, _ := s.repo.GetUserByEmail(email)
     if existingEmail != nil {
-        return errors.New("email already registered")
+        return fmt.Errorf("email already registered: %w", ErrInvalid)
     }
 
     hashedPassword, err := utils.HashPassword(password)
@@ -55,36 +58,43 @@ func (s *UserService) Authenticate(email, password string) (*models.User, error)
     return user, nil
 }
 
-func (s *UserService) SignUpAndToken(username, email, password string) (string, error) {
-    err := s.SignUp(username, email, password)
-    if err != nil {
-        return "", err
-    }
-    user, err := s.Authenticate(email, password)
-    if err != nil || user == nil {
-        return "", err
-    }
-    token, err := utils.GenerateJWT(user.ID.String())
-    if err != nil {
-        return "", err
-    }
-    return token, nil
+func (s *UserService) SetOnlineStatus(userID string, isOnline bool) error {
+    return s.repo.SetOnlineStatus(userID, isOnline)
 }
 
-func (s *UserService) LoginAndToken(email, password string) (string, error) {
-    user, err := s.Authenticate(email, password)
-    if err != nil || user == nil {
-        return "", err
-    }
-    token, err := utils.GenerateJWT(user.ID.String())
-    if err != nil {
-        return "", err
-    }
-    return token, nil
+func (s *UserService) SetIdle(userID string, idle bool) error {
+    return s.repo.SetIdle(userID, idle)
 }
 
-func (s *UserService) SetOnlineStatus(userID string, isOnline bool) error {
-    return s.repo.SetOnlineStatus(userID, isOnline)
+// RenewLeases, ReleaseLease, DropLeases and SweepLeases let the hub keep
+// is_online honest across crashes and instances
+func (s *UserService) RenewLeases(ctx context.Context, instanceID string, userIDs []string, ttl time.Duration) error {
+    return s.repo.RenewLeases(ctx, instanceID, userIDs, ttl)
+}
+
+func (s *UserService) ReleaseLease(ctx context.Context, instanceID, userID string) error {
+    return s.repo.ReleaseLease(ctx, instanceID, userID)
+}
+
+func (s *UserService) DropLeases(ctx context.Context, instanceID string) error {
+    return s.repo.DropLeases(ctx, instanceID)
+}
+
+func (s *UserService) SweepLeases(ctx context.Context) ([]string, error) {
+    return s.repo.SweepLeases(ctx)
+}
+
+// LastSeenAudience reports who may see the user's last_seen_at under their
+// privacy setting: everyone, their contacts, or nobody but themselves
+func (s *UserService) LastSeenAudience(user *models.User) (bool, []string, error) {
+    switch user.LastSeenVisibility {
+    case models.LastSeenContacts:
+        ids, err := s.repo.ContactIDs(user.ID.String())
+        return false, ids, err
+    case models.LastSeenNobody:
+        return false, nil, nil
+    }
+    return true, nil, nil
 }
 
 func (s *UserService) GetOnlineUsers() ([]models.User, error) {
@@ -0,0 +1,27 @@
+// Package storage keeps uploaded files in a pluggable object store.
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+)
+
+// ErrNotFound is returned by Get when no object has the key
+var ErrNotFound = errors.New("object not found")
+
+// ObjectStore stores opaque objects by key. Keys are generated by the
+// application and never contain user input.
+type ObjectStore interface {
+	// Put stores size bytes read from body under key, replacing any existing object
+	Put(ctx context.Context, key string, body io.Reader, size int64, contentType string) error
+	// Get opens the object; the caller closes the returned reader
+	Get(ctx context.Context, key string) (io.ReadCloser, ObjectInfo, error)
+	Delete(ctx context.Context, key string) error
+}
+
+// ObjectInfo describes a stored object
+type ObjectInfo struct {
+	Size        int64
+	ContentType string
+}
@@ -1,10 +1,16 @@
 package utils
 
 import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"errors"
 	"github.com/golang-jwt/jwt/v5"
 	"golang.org/x/crypto/bcrypt"
 	"os"
+	"strconv"
 	"time"
 )
 
@@ -18,41 +24,132 @@ func CheckPasswordHash(password, hash string) bool {
 	return err == nil
 }
 
-func GenerateJWT(userID string) (string, error) {
+// Default lifetimes; override with ACCESS_TOKEN_TTL and REFRESH_TOKEN_TTL (e.g. "10m", "720h")
+const (
+	defaultAccessTokenTTL  = 15 * time.Minute
+	defaultRefreshTokenTTL = 30 * 24 * time.Hour
+)
+
+// AccessTokenTTL is how long an issued JWT stays valid
+func AccessTokenTTL() time.Duration {
+	return DurationFromEnv("ACCESS_TOKEN_TTL", defaultAccessTokenTTL)
+}
+
+// RefreshTokenTTL is how long a refresh token (and the session it renews) stays valid
+func RefreshTokenTTL() time.Duration {
+	return DurationFromEnv("REFRESH_TOKEN_TTL", defaultRefreshTokenTTL)
+}
+
+// GenerateRefreshToken returns a new opaque refresh token and the hash to store for it
+func GenerateRefreshToken() (string, string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(buf)
+	return token, HashRefreshToken(token), nil
+}
+
+// HashRefreshToken is the lookup key stored for a refresh token
+func HashRefreshToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// TokenClaims are the fields this app reads from a verified JWT
+type TokenClaims struct {
+	UserID    string
+	SessionID string
+	ExpiresAt time.Time
+}
+
+// SessionValidator is consulted for every token so revoked sessions are rejected
+// before the JWT itself expires. It is set by main to the session service.
+var SessionValidator func(sessionID, userID string) error
+
+func jwtSecret() []byte {
 	secret := os.Getenv("JWT_SECRET")
 	if secret == "" {
 		secret = "dev_secret"
 	}
+	return []byte(secret)
+}
+
+// urlSigningKey signs download URLs; URL_SIGNING_SECRET falls back to the JWT secret
+func urlSigningKey() []byte {
+	if secret := os.Getenv("URL_SIGNING_SECRET"); secret != "" {
+		return []byte(secret)
+	}
+	return jwtSecret()
+}
+
+// SignPath returns a signature that authorizes fetching path until expiresAt
+func SignPath(path string, expiresAt time.Time) string {
+	mac := hmac.New(sha256.New, urlSigningKey())
+	mac.Write([]byte(path + "\n" + strconv.FormatInt(expiresAt.Unix(), 10)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifyPathSignature checks a signature made by SignPath and that it has not expired
+func VerifyPathSignature(path string, expiresUnix int64, signature string, now time.Time) bool {
+	expiresAt := time.Unix(expiresUnix, 0)
+	if now.After(expiresAt) {
+		return false
+	}
+	return hmac.Equal([]byte(signature), []byte(SignPath(path, expiresAt)))
+}
+
+// GenerateJWT signs a token for the user with the session ID as its jti claim
+func GenerateJWT(userID, sessionID string, expiresAt time.Time) (string, error) {
 	claims := jwt.MapClaims{
 		"user_id": userID,
-		"exp":     time.Now().Add(24 * time.Hour).Unix(),
+		"jti":     sessionID,
+		"exp":     expiresAt.Unix(),
 	}
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString([]byte(secret))
+	return token.SignedString(jwtSecret())
 }
 
-// ExtractUserIDFromJWT extracts the user ID from a JWT token string
-func ExtractUserIDFromJWT(tokenStr string) (string, error) {
-	secret := os.Getenv("JWT_SECRET")
-	if secret == "" {
-		secret = "dev_secret"
-	}
+// ParseJWT verifies the token signature and expiry and returns its claims
+func ParseJWT(tokenStr string) (*TokenClaims, error) {
 	token, err := jwt.Parse(tokenStr, func(token *jwt.Token) (interface{}, error) {
 		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
 			return nil, errors.New("unexpected signing method")
 		}
-		return []byte(secret), nil
+		return jwtSecret(), nil
 	})
 	if err != nil || !token.Valid {
-		return "", errors.New("invalid token")
+		return nil, errors.New("invalid token")
 	}
 	claims, ok := token.Claims.(jwt.MapClaims)
 	if !ok {
-		return "", errors.New("invalid token claims")
+		return nil, errors.New("invalid token claims")
 	}
 	userID, ok := claims["user_id"].(string)
 	if !ok {
-		return "", errors.New("user_id not found in token")
+		return nil, errors.New("user_id not found in token")
+	}
+	sessionID, _ := claims["jti"].(string)
+	result := &TokenClaims{UserID: userID, SessionID: sessionID}
+	if exp, err := claims.GetExpirationTime(); err == nil && exp != nil {
+		result.ExpiresAt = exp.Time
+	}
+	return result, nil
+}
+
+// AuthenticateJWT parses the token and checks that its session is still active
+func AuthenticateJWT(tokenStr string) (*TokenClaims, error) {
+	claims, err := ParseJWT(tokenStr)
+	if err != nil {
+		return nil, err
+	}
+	if SessionValidator != nil {
+		if claims.SessionID == "" {
+			return nil, errors.New("token has no session")
+		}
+		if err := SessionValidator(claims.SessionID, claims.UserID); err != nil {
+			return nil, err
+		}
 	}
-	return userID, nil
+	return claims, nil
 }
@@ -0,0 +1,48 @@
+package utils
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSignedDownloadURLs(t *testing.T) {
+	t.Setenv("URL_SIGNING_SECRET", "test-secret")
+	now := time.Now()
+	path := "/attachments/1b4e28ba-2fa1-11d2-883f-0016d3cca427/content"
+	expiresAt := now.Add(5 * time.Minute)
+	signature := SignPath(path, expiresAt)
+	tampered := []byte(signature)
+	tampered[0] ^= 1
+
+	tests := []struct {
+		name      string
+		path      string
+		expires   int64
+		signature string
+		now       time.Time
+		want      bool
+	}{
+		{"valid", path, expiresAt.Unix(), signature, now, true},
+		{"at expiry", path, expiresAt.Unix(), signature, time.Unix(expiresAt.Unix(), 0), true},
+		{"expired", path, expiresAt.Unix(), signature, expiresAt.Add(time.Second), false},
+		{"other path", "/attachments/00000000-0000-0000-0000-000000000000/content", expiresAt.Unix(), signature, now, false},
+		{"extended expiry", path, expiresAt.Add(time.Hour).Unix(), signature, now, false},
+		{"tampered signature", path, expiresAt.Unix(), string(tampered), now, false},
+		{"empty signature", path, expiresAt.Unix(), "", now, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := VerifyPathSignature(tt.path, tt.expires, tt.signature, tt.now); got != tt.want {
+				t.Fatalf("VerifyPathSignature = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	t.Setenv("URL_SIGNING_SECRET", "rotated-secret")
+	if VerifyPathSignature(path, expiresAt.Unix(), signature, now) {
+		t.Fatal("signature still valid after the secret changed")
+	}
+	if got := SignPath(path, expiresAt); got == signature {
+		t.Fatalf("same signature %s under another secret", got)
+	}
+}
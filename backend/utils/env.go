@@ -0,0 +1,15 @@
+package utils
+
+import (
+	"os"
+	"time"
+)
+
+// DurationFromEnv parses a positive duration such as "15m" from the environment,
+// returning fallback when the variable is unset or invalid
+func DurationFromEnv(key string, fallback time.Duration) time.Duration {
+	if d, err := time.ParseDuration(os.Getenv(key)); err == nil && d > 0 {
+		return d
+	}
+	return fallback
+}
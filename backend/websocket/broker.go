@@ -0,0 +1,84 @@
+package websocket
+
+import (
+	"encoding/json"
+	"sync"
+)
+
+// Broker carries hub events between backend instances so that users connected
+// to different replicas can reach each other. Each hub delivers to its own
+// connections directly and publishes the event for the others; hubs ignore
+// events that carry their own instance ID.
+type Broker interface {
+	// Publish sends the event to every subscribed hub
+	Publish(event BrokerEvent) error
+	// Subscribe registers the callback that receives published events.
+	// It may be called only once per broker.
+	Subscribe(handler func(BrokerEvent)) error
+	Close() error
+}
+
+// Broker event kinds
+const (
+	EventDirect     = "direct"
+	EventBroadcast  = "broadcast"
+	EventPresence   = "presence"
+	EventDisconnect = "disconnect"
+	EventStatus     = "status"
+	EventHeartbeat  = "heartbeat"
+)
+
+// BrokerEvent is the unit exchanged between hubs. Which fields are set depends on Kind:
+//   - direct: UserID and Data
+//   - broadcast: Data, and ExceptUserID when the sender should be skipped
+//   - presence: UserID, Online, whether the instance has any connection of the
+//     user, and Idle, whether all of them are idle
+//   - disconnect: UserID or SessionID
+//   - status: UserID, whose presence settings changed
+//   - heartbeat: Presence, every user connected to the instance and whether
+//     all of their connections there are idle
+type BrokerEvent struct {
+	Instance     string          `json:"instance"`
+	Kind         string          `json:"kind"`
+	UserID       string          `json:"user_id,omitempty"`
+	ExceptUserID string          `json:"except_user_id,omitempty"`
+	SessionID    string          `json:"session_id,omitempty"`
+	Online       bool            `json:"online,omitempty"`
+	Idle         bool            `json:"idle,omitempty"`
+	Presence     map[string]bool `json:"presence,omitempty"`
+	Data         json.RawMessage `json:"data,omitempty"`
+}
+
+// MemoryBroker connects hubs living in the same process. With a single hub it
+// is a no-op, which is the default single-instance deployment.
+type MemoryBroker struct {
+	mu       sync.RWMutex
+	handlers []func(BrokerEvent)
+}
+
+func NewMemoryBroker() *MemoryBroker {
+	return &MemoryBroker{}
+}
+
+func (b *MemoryBroker) Publish(event BrokerEvent) error {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for _, handler := range b.handlers {
+		handler(event)
+	}
+	return nil
+}
+
+func (b *MemoryBroker) Subscribe(handler func(BrokerEvent)) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.handlers = append(b.handlers, handler)
+	return nil
+}
+
+func (b *MemoryBroker) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.handlers = nil
+	return nil
+}
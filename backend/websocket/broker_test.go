@@ -0,0 +1,39 @@
+package websocket
+
+import (
+	"testing"
+	"time"
+)
+
+// Two hubs whose run loops are stalled must not block each other through a
+// shared broker; overflowing events are dropped and counted instead
+func TestPublishNeverBlocksOnFullQueues(t *testing.T) {
+	broker := NewMemoryBroker()
+	a, b := NewHub(nil), NewHub(nil)
+	if err := a.SetBroker(broker); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.SetBroker(broker); err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 4*brokerQueueSize; i++ {
+			a.publish(BrokerEvent{Kind: EventDirect, UserID: "u"})
+			b.publish(BrokerEvent{Kind: EventDirect, UserID: "u"})
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("publish blocked on a full queue")
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for a.Stats().DroppedEvents+b.Stats().DroppedEvents == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("no dropped events counted")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
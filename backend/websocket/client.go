@@ -1,11 +1,10 @@
 package websocket
 
 import (
-	"encoding/json"
 	"github.com/gorilla/websocket"
-	"github.com/google/uuid"
-	"strings"
-	"chatting-service-app/models"
+	"sync"
+	"sync/atomic"
+	"chatting-service-app/utils"
 	"time"
 )
 
@@ -14,133 +13,127 @@ type Client struct {
 	Conn *websocket.Conn
 	Send chan []byte
 	ID   string // user ID or unique identifier
+	// SessionID is the jti of the token the connection authenticated with
+	SessionID string
+
+	authMu    sync.Mutex
+	authTimer *time.Timer
+
+	// idle is set once the connection saw no activity for IdleTimeout, or the
+	// client said the user is away
+	idle      atomic.Bool
+	idleTimer *time.Timer
+
+	typingMu     sync.Mutex
+	typing       map[string]*typingState
+	typingWindow time.Time
+	typingFrames int
+}
+
+// NewClient creates a client for an upgraded connection with a send buffer
+// sized by the hub's ConnConfig
+func (h *Hub) NewClient(conn *websocket.Conn, userID, sessionID string) *Client {
+	c := &Client{
+		Hub:       h,
+		Conn:      conn,
+		Send:      make(chan []byte, h.connConfig.SendBuffer),
+		ID:        userID,
+		SessionID: sessionID,
+	}
+	c.idleTimer = time.AfterFunc(h.connConfig.IdleTimeout, func() {
+		if c.idle.CompareAndSwap(false, true) {
+			h.idleChanged <- c
+		}
+	})
+	return c
 }
 
-// Add a callback type for delivery/read status
-// This should be set by the main app or handler to call the service
-var OnMessageDelivered func(messageID, recipientID string)
-var OnMessageRead func(messageID, recipientID string)
+// closeCodeTokenExpired tells the peer its access token ran out; it should
+// refresh and reconnect, or send an "auth" frame before this happens
+const closeCodeTokenExpired = 4001
+
+// SetAuthExpiry schedules the connection to close when its access token expires.
+// Calling it again with a renewed token's expiry pushes the deadline back; a zero
+// time cancels it.
+func (c *Client) SetAuthExpiry(expiresAt time.Time) {
+	c.authMu.Lock()
+	defer c.authMu.Unlock()
+	if c.authTimer != nil {
+		c.authTimer.Stop()
+		c.authTimer = nil
+	}
+	if expiresAt.IsZero() {
+		return
+	}
+	c.authTimer = time.AfterFunc(time.Until(expiresAt), func() {
+		msg := websocket.FormatCloseMessage(closeCodeTokenExpired, "token expired")
+		_ = c.Conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second))
+		c.Conn.Close()
+	})
+}
+
+// reauthenticate accepts a renewed access token for the same session so the
+// connection survives token rotation without reconnecting
+func (c *Client) reauthenticate(token string) (time.Time, error) {
+	claims, err := utils.AuthenticateJWT(token)
+	if err != nil || claims.UserID != c.ID || claims.SessionID != c.SessionID {
+		return time.Time{}, NewProtocolError(ErrCodeUnauthorized, "invalid token")
+	}
+	c.SetAuthExpiry(claims.ExpiresAt)
+	return claims.ExpiresAt, nil
+}
 
+// ReadPump reads frames from the connection and dispatches them by type until
+// the peer disconnects or stays silent for longer than PongWait
 func (c *Client) ReadPump() {
+	cfg := c.Hub.connConfig
 	defer func() {
+		c.SetAuthExpiry(time.Time{})
+		c.stopAllTyping()
 		c.Hub.unregister <- c
 		c.Conn.Close()
 	}()
+	c.Conn.SetReadLimit(cfg.MaxMessageSize)
+	_ = c.Conn.SetReadDeadline(time.Now().Add(cfg.PongWait))
+	c.Conn.SetPongHandler(func(string) error {
+		return c.Conn.SetReadDeadline(time.Now().Add(cfg.PongWait))
+	})
 	for {
 		_, message, err := c.Conn.ReadMessage()
 		if err != nil {
 			break
 		}
-		msgStr := string(message)
-		if strings.Contains(msgStr, "\"type\":\"delivered\"") {
-			idIdx := strings.Index(msgStr, "message_id")
-			if idIdx != -1 {
-				start := strings.Index(msgStr[idIdx:], ":") + idIdx + 2
-				end := strings.Index(msgStr[start:], "\"") + start
-				messageID := msgStr[start:end]
-				if OnMessageDelivered != nil {
-					OnMessageDelivered(messageID, c.ID)
-				}
-			}
-		} else if strings.Contains(msgStr, "\"type\":\"read\"") {
-			idIdx := strings.Index(msgStr, "message_id")
-			if idIdx != -1 {
-				start := strings.Index(msgStr[idIdx:], ":") + idIdx + 2
-				end := strings.Index(msgStr[start:], "\"") + start
-				messageID := msgStr[start:end]
-				if OnMessageRead != nil {
-					OnMessageRead(messageID, c.ID)
-				}
-			}
-		} else {
-			// Try to parse as wrapped message (with payload)
-			var envelope map[string]interface{}
-			if err := json.Unmarshal(message, &envelope); err == nil {
-				if envelope["type"] == "message" && envelope["payload"] != nil {
-					payloadBytes, _ := json.Marshal(envelope["payload"])
-					var raw map[string]interface{}
-					if err := json.Unmarshal(payloadBytes, &raw); err == nil {
-						var chatMsg models.Message
-						if sender, ok := raw["sender_id"].(string); ok {
-							if uuidVal, err := uuid.Parse(sender); err == nil {
-								chatMsg.SenderID = uuidVal
-							}
-						}
-						if recipient, ok := raw["recipient_id"].(string); ok {
-							if uuidVal, err := uuid.Parse(recipient); err == nil {
-								chatMsg.RecipientID = uuidVal
-							}
-						}
-						if content, ok := raw["content"].(string); ok {
-							chatMsg.Content = content
-						}
-						if isBroadcast, ok := raw["is_broadcast"].(bool); ok {
-							chatMsg.IsBroadcast = isBroadcast
-						}
-						if createdAt, ok := raw["created_at"].(string); ok {
-							if t, err := time.Parse(time.RFC3339, createdAt); err == nil {
-								chatMsg.CreatedAt = t
-							}
-						}
-						if mediaURL, ok := raw["media_url"].(string); ok {
-							chatMsg.MediaURL = mediaURL
-						}
-						msgBytes, _ := json.Marshal(chatMsg)
-						if chatMsg.Content != "" && chatMsg.RecipientID != uuid.Nil {
-							if chatMsg.IsBroadcast {
-								c.Hub.BroadcastExcept(c.ID, msgBytes)
-							} else {
-								c.Hub.SendDirect(chatMsg.RecipientID.String(), msgBytes)
-							}
-							continue
-						}
-					}
-				}
-			}
-			// Fallback: Try to parse as raw message (no envelope)
-			var raw map[string]interface{}
-			if err := json.Unmarshal(message, &raw); err == nil {
-				var chatMsg models.Message
-				if sender, ok := raw["sender_id"].(string); ok {
-					if uuidVal, err := uuid.Parse(sender); err == nil {
-						chatMsg.SenderID = uuidVal
-					}
-				}
-				if recipient, ok := raw["recipient_id"].(string); ok {
-					if uuidVal, err := uuid.Parse(recipient); err == nil {
-						chatMsg.RecipientID = uuidVal
-					}
-				}
-				if content, ok := raw["content"].(string); ok {
-					chatMsg.Content = content
-				}
-				if isBroadcast, ok := raw["is_broadcast"].(bool); ok {
-					chatMsg.IsBroadcast = isBroadcast
-				}
-				if mediaURL, ok := raw["media_url"].(string); ok {
-					chatMsg.MediaURL = mediaURL
-				}
-				msgBytes, _ := json.Marshal(chatMsg)
-				if chatMsg.Content != "" && chatMsg.RecipientID != uuid.Nil {
-					if chatMsg.IsBroadcast {
-						c.Hub.BroadcastExcept(c.ID, msgBytes)
-					} else {
-						c.Hub.SendDirect(chatMsg.RecipientID.String(), msgBytes)
-					}
-					continue
-				}
-			}
-			// If not a chat message, ignore or handle as needed
-		}
+		_ = c.Conn.SetReadDeadline(time.Now().Add(cfg.PongWait))
+		c.dispatch(message)
 	}
 }
 
+// WritePump writes queued frames and pings the peer every PingPeriod. It stops
+// when the hub closes Send or a write fails; closing the connection then ends
+// ReadPump, which unregisters the client.
 func (c *Client) WritePump() {
-	defer c.Conn.Close()
-	for msg := range c.Send {
-		err := c.Conn.WriteMessage(websocket.TextMessage, msg)
-		if err != nil {
-			break
+	cfg := c.Hub.connConfig
+	ticker := time.NewTicker(cfg.PingPeriod)
+	defer func() {
+		ticker.Stop()
+		c.Conn.Close()
+	}()
+	for {
+		select {
+		case msg, ok := <-c.Send:
+			_ = c.Conn.SetWriteDeadline(time.Now().Add(cfg.WriteWait))
+			if !ok {
+				_ = c.Conn.WriteMessage(websocket.CloseMessage, []byte{})
+				return
+			}
+			if err := c.Conn.WriteMessage(websocket.TextMessage, msg); err != nil {
+				return
+			}
+		case <-ticker.C:
+			_ = c.Conn.SetWriteDeadline(time.Now().Add(cfg.WriteWait))
+			if err := c.Conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
 		}
 	}
-}
\ No newline at end of file
+}
@@ -0,0 +1,126 @@
+package websocket
+
+import (
+	"chatting-service-app/utils"
+	"os"
+	"strconv"
+	"time"
+)
+
+// SlowConsumerPolicy decides what happens when a connection's send buffer is full
+type SlowConsumerPolicy string
+
+const (
+	// SlowConsumerDisconnect closes connections that cannot keep up; the client
+	// reconnects and catches up through replay and sync
+	SlowConsumerDisconnect SlowConsumerPolicy = "disconnect"
+	// SlowConsumerDropOldest discards the oldest queued frame to make room
+	SlowConsumerDropOldest SlowConsumerPolicy = "drop_oldest"
+)
+
+// ConnConfig tunes how connections are kept alive and bounded
+type ConnConfig struct {
+	// WriteWait is the time allowed to write one frame to the peer
+	WriteWait time.Duration
+	// PongWait is how long the peer may stay silent before it is considered
+	// gone; any frame or pong resets it
+	PongWait time.Duration
+	// PingPeriod is how often pings are sent; it must be shorter than PongWait
+	PingPeriod time.Duration
+	// MaxMessageSize is the largest inbound frame in bytes; bigger frames close the connection
+	MaxMessageSize int64
+	// SendBuffer is how many outbound frames may queue per connection
+	SendBuffer int
+	// SlowConsumer applies when the send buffer is full
+	SlowConsumer SlowConsumerPolicy
+	// TypingTimeout is how long a typing_start lasts unless the client repeats it
+	TypingTimeout time.Duration
+	// IdleTimeout is how long a connection may go without activity frames
+	// before it counts as idle
+	IdleTimeout time.Duration
+	// PresenceInterval is how often the hub renews its presence leases,
+	// heartbeats to other instances and sweeps expired presence
+	PresenceInterval time.Duration
+	// PresenceTTL is how long presence held by an instance survives without a
+	// renewal; it must cover several intervals
+	PresenceTTL time.Duration
+}
+
+// DefaultConnConfig returns the settings used when nothing is configured
+func DefaultConnConfig() ConnConfig {
+	return ConnConfig{
+		WriteWait:        10 * time.Second,
+		PongWait:         60 * time.Second,
+		PingPeriod:       54 * time.Second,
+		MaxMessageSize:   64 * 1024,
+		SendBuffer:       256,
+		SlowConsumer:     SlowConsumerDisconnect,
+		TypingTimeout:    6 * time.Second,
+		IdleTimeout:      5 * time.Minute,
+		PresenceInterval: 30 * time.Second,
+		PresenceTTL:      90 * time.Second,
+	}
+}
+
+// ConnConfigFromEnv reads WS_WRITE_WAIT, WS_PONG_WAIT, WS_PING_PERIOD,
+// WS_TYPING_TIMEOUT, WS_IDLE_TIMEOUT, WS_PRESENCE_INTERVAL, WS_PRESENCE_TTL
+// (durations), WS_MAX_MESSAGE_SIZE (bytes), WS_SEND_BUFFER
+// (frames) and WS_SLOW_CONSUMER (disconnect or drop_oldest), falling back to
+// the defaults
+func ConnConfigFromEnv() ConnConfig {
+	cfg := DefaultConnConfig()
+	cfg.WriteWait = utils.DurationFromEnv("WS_WRITE_WAIT", cfg.WriteWait)
+	cfg.PongWait = utils.DurationFromEnv("WS_PONG_WAIT", cfg.PongWait)
+	cfg.PingPeriod = utils.DurationFromEnv("WS_PING_PERIOD", cfg.PongWait*9/10)
+	cfg.TypingTimeout = utils.DurationFromEnv("WS_TYPING_TIMEOUT", cfg.TypingTimeout)
+	cfg.IdleTimeout = utils.DurationFromEnv("WS_IDLE_TIMEOUT", cfg.IdleTimeout)
+	cfg.PresenceInterval = utils.DurationFromEnv("WS_PRESENCE_INTERVAL", cfg.PresenceInterval)
+	cfg.PresenceTTL = utils.DurationFromEnv("WS_PRESENCE_TTL", cfg.PresenceTTL)
+	if n, err := strconv.ParseInt(os.Getenv("WS_MAX_MESSAGE_SIZE"), 10, 64); err == nil && n > 0 {
+		cfg.MaxMessageSize = n
+	}
+	if n, err := strconv.Atoi(os.Getenv("WS_SEND_BUFFER")); err == nil && n > 0 {
+		cfg.SendBuffer = n
+	}
+	if policy := SlowConsumerPolicy(os.Getenv("WS_SLOW_CONSUMER")); policy != "" {
+		cfg.SlowConsumer = policy
+	}
+	return cfg.normalized()
+}
+
+// normalized keeps pings frequent enough to beat the pong deadline, lets
+// presence survive a few missed renewals and replaces unusable settings with
+// the defaults
+func (cfg ConnConfig) normalized() ConnConfig {
+	if cfg.PongWait <= 0 {
+		cfg.PongWait = DefaultConnConfig().PongWait
+	}
+	if cfg.WriteWait <= 0 {
+		cfg.WriteWait = DefaultConnConfig().WriteWait
+	}
+	if cfg.MaxMessageSize <= 0 {
+		cfg.MaxMessageSize = DefaultConnConfig().MaxMessageSize
+	}
+	if cfg.PingPeriod <= 0 || cfg.PingPeriod >= cfg.PongWait {
+		cfg.PingPeriod = cfg.PongWait * 9 / 10
+	}
+	if cfg.TypingTimeout <= 0 {
+		cfg.TypingTimeout = DefaultConnConfig().TypingTimeout
+	}
+	if cfg.IdleTimeout <= 0 {
+		cfg.IdleTimeout = DefaultConnConfig().IdleTimeout
+	}
+	if cfg.PresenceInterval <= 0 {
+		cfg.PresenceInterval = DefaultConnConfig().PresenceInterval
+	}
+	if cfg.PresenceTTL < 2*cfg.PresenceInterval {
+		cfg.PresenceTTL = 3 * cfg.PresenceInterval
+	}
+	if cfg.SendBuffer <= 0 {
+		cfg.SendBuffer = DefaultConnConfig().SendBuffer
+	}
+	if cfg.SlowConsumer != SlowConsumerDropOldest {
+		cfg.SlowConsumer = SlowConsumerDisconnect
+	}
+	return cfg
+}
@@ -0,0 +1,27 @@
+package websocket
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNormalizedClampsUnusableDurations(t *testing.T) {
+	cfg := ConnConfig{}.normalized()
+	def := DefaultConnConfig()
+	if cfg.PongWait != def.PongWait || cfg.WriteWait != def.WriteWait || cfg.MaxMessageSize != def.MaxMessageSize {
+		t.Fatalf("zero config not clamped to defaults: %+v", cfg)
+	}
+	if cfg.PingPeriod <= 0 || cfg.PingPeriod >= cfg.PongWait {
+		t.Fatalf("ping period %s must be positive and below pong wait %s", cfg.PingPeriod, cfg.PongWait)
+	}
+	// time.NewTicker panics on a non-positive period
+	time.NewTicker(cfg.PingPeriod).Stop()
+	time.NewTicker(cfg.PresenceInterval).Stop()
+}
+
+func TestNormalizedKeepsPingBelowPongWait(t *testing.T) {
+	cfg := ConnConfig{PongWait: 10 * time.Second, PingPeriod: 20 * time.Second}.normalized()
+	if cfg.PingPeriod != 9*time.Second {
+		t.Fatalf("ping period = %s, want 9s", cfg.PingPeriod)
+	}
+}
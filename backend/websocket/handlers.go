@@ -0,0 +1,33 @@
+package websocket
+
+import "time"
+
+// registerBuiltinHandlers installs the frame handlers that only need the hub itself.
+// Handlers that persist data are registered by the application with Hub.Handle.
+func (h *Hub) registerBuiltinHandlers() {
+	h.Handle("ping", handlePing)
+	h.Handle("auth", handleAuth)
+	h.Handle("get_online_users", handleGetOnlineUsers)
+	h.Handle("activity", handleActivity)
+}
+
+func handlePing(c *Client, env Envelope) (interface{}, error) {
+	return map[string]interface{}{"server_time": time.Now()}, nil
+}
+
+func handleAuth(c *Client, env Envelope) (interface{}, error) {
+	var payload AuthPayload
+	if err := DecodePayload(env, &payload); err != nil {
+		return nil, err
+	}
+	expiresAt, err := c.reauthenticate(payload.Token)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{"expires_at": expiresAt}, nil
+}
+
+func handleGetOnlineUsers(c *Client, env Envelope) (interface{}, error) {
+	c.Hub.requestOnlineUsers(c)
+	return nil, nil
+}
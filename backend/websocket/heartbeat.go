@@ -0,0 +1,223 @@
+package websocket
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// PresenceStore persists which instance holds connections of which user as
+// leases that expire unless renewed, so users of an instance that crashed do
+// not stay online in the database. UserService implements it; it is optional.
+type PresenceStore interface {
+	// RenewLeases keeps the users online on the instance for ttl from now
+	RenewLeases(ctx context.Context, instanceID string, userIDs []string, ttl time.Duration) error
+	// ReleaseLease drops the instance's lease once the user left it
+	ReleaseLease(ctx context.Context, instanceID, userID string) error
+	// DropLeases deletes the leases of every other instance
+	DropLeases(ctx context.Context, instanceID string) error
+	// SweepLeases marks users without a live lease offline and returns them
+	SweepLeases(ctx context.Context) ([]string, error)
+}
+
+// leaseJobKind says what a leaseJob does with the presence store
+type leaseJobKind int
+
+const (
+	leaseRenew leaseJobKind = iota
+	leaseRelease
+	leaseSweep
+)
+
+// leaseJob is a presence store call the run loop hands to the lease worker
+type leaseJob struct {
+	kind    leaseJobKind
+	userIDs []string
+}
+
+// SetPresenceStore enables presence leases and reconciles presence left over
+// from before this start. With sole set no other instance shares the
+// database, so every lease but this hub's is stale and dropped at once;
+// otherwise leases of crashed instances are swept when they expire. Call it
+// once, before serving clients.
+func (h *Hub) SetPresenceStore(store PresenceStore, sole bool) error {
+	h.presence = store
+	ctx, cancel := context.WithTimeout(context.Background(), h.leaseTimeout())
+	defer cancel()
+	if sole {
+		if err := store.DropLeases(ctx, h.instanceID); err != nil {
+			return err
+		}
+	}
+	offline, err := store.SweepLeases(ctx)
+	if err != nil {
+		return err
+	}
+	if len(offline) > 0 {
+		log.Printf("hub: marked %d users offline on startup", len(offline))
+	}
+	go h.leaseWorker()
+	return nil
+}
+
+// leaseTimeout bounds each presence store call; a renewal that takes longer
+// than the interval is overtaken by the next one anyway
+func (h *Hub) leaseTimeout() time.Duration {
+	return h.connConfig.PresenceInterval
+}
+
+// leaseWorker runs lease jobs in order, so a user's lease is never released
+// before it was acquired, and posts the users each sweep took offline back to
+// the run loop
+func (h *Hub) leaseWorker() {
+	for range h.leaseJobs.ready {
+		for _, job := range h.leaseJobs.take() {
+			if offline := h.runLeaseJob(job); len(offline) > 0 {
+				h.swept <- offline
+			}
+		}
+	}
+}
+
+func (h *Hub) runLeaseJob(job leaseJob) []string {
+	ctx, cancel := context.WithTimeout(context.Background(), h.leaseTimeout())
+	defer cancel()
+	switch job.kind {
+	case leaseRenew:
+		if err := h.presence.RenewLeases(ctx, h.instanceID, job.userIDs, h.connConfig.PresenceTTL); err != nil {
+			log.Printf("hub: renewing presence leases: %v", err)
+		}
+	case leaseRelease:
+		for _, userID := range job.userIDs {
+			if err := h.presence.ReleaseLease(ctx, h.instanceID, userID); err != nil {
+				log.Printf("hub: releasing presence lease: %v", err)
+			}
+		}
+	case leaseSweep:
+		offline, err := h.presence.SweepLeases(ctx)
+		if err != nil {
+			log.Printf("hub: sweeping presence leases: %v", err)
+			return nil
+		}
+		if len(offline) > 0 {
+			log.Printf("hub: marked %d users with expired presence offline", len(offline))
+		}
+		return offline
+	}
+	return nil
+}
+
+// acquireLease records that the user's first connection here opened
+func (h *Hub) acquireLease(userID string) {
+	if h.presence != nil {
+		h.leaseJobs.push(leaseJob{kind: leaseRenew, userIDs: []string{userID}})
+	}
+}
+
+// releaseLease records that the user's last connection here closed
+func (h *Hub) releaseLease(userID string) {
+	if h.presence != nil {
+		h.leaseJobs.push(leaseJob{kind: leaseRelease, userIDs: []string{userID}})
+	}
+}
+
+// heartbeat runs every PresenceInterval: it tells the other instances who is
+// connected here, forgets instances that went quiet, and has the lease worker
+// renew this instance's leases and sweep expired ones
+func (h *Hub) heartbeat() {
+	h.publishHeartbeat()
+	h.expirePeers()
+	if h.presence == nil {
+		return
+	}
+	userIDs := make([]string, 0, len(h.clientsByID))
+	for id := range h.clientsByID {
+		userIDs = append(userIDs, id)
+	}
+	if len(userIDs) > 0 {
+		h.leaseJobs.push(leaseJob{kind: leaseRenew, userIDs: userIDs})
+	}
+	h.leaseJobs.push(leaseJob{kind: leaseSweep})
+}
+
+// applySweep handles users a sweep marked offline in the database. A user
+// connected here lost their lease, e.g. to a renewal that timed out, and gets
+// it back at once; a user only connected elsewhere is dropped, as their
+// instance stopped renewing for them.
+func (h *Hub) applySweep(userIDs []string) {
+	var renew []string
+	for _, userID := range userIDs {
+		if len(h.clientsByID[userID]) > 0 {
+			renew = append(renew, userID)
+			h.queueUserJob(userJob{kind: jobSetOnline, userID: userID, value: true})
+			continue
+		}
+		for instance := range h.remoteOnline[userID] {
+			h.setRemotePresence(userID, instance, false, false)
+		}
+	}
+	if len(renew) > 0 {
+		h.leaseJobs.push(leaseJob{kind: leaseRenew, userIDs: renew})
+	}
+}
+
+// publishHeartbeat shares the full presence of this instance, which repairs
+// presence events the broker lost and brings new instances up to date
+func (h *Hub) publishHeartbeat() {
+	presence := make(map[string]bool, len(h.clientsByID))
+	for id := range h.clientsByID {
+		presence[id] = h.localIdle[id]
+	}
+	h.publish(BrokerEvent{Kind: EventHeartbeat, Presence: presence})
+}
+
+// notePeer records that an instance is alive and reports whether it is new,
+// in which case it has yet to learn this instance's presence
+func (h *Hub) notePeer(instance string) bool {
+	_, known := h.peers[instance]
+	h.peers[instance] = time.Now()
+	return !known
+}
+
+// applyHeartbeat makes the users recorded for an instance match its heartbeat
+func (h *Hub) applyHeartbeat(event BrokerEvent) {
+	for userID, idle := range event.Presence {
+		if current, ok := h.remoteOnline[userID][event.Instance]; !ok || current != idle {
+			h.setRemotePresence(userID, event.Instance, true, idle)
+		}
+	}
+	for _, userID := range h.remoteUsersOf(event.Instance) {
+		if _, ok := event.Presence[userID]; !ok {
+			h.setRemotePresence(userID, event.Instance, false, false)
+		}
+	}
+}
+
+// expirePeers drops the presence of instances not heard from within
+// PresenceTTL, as they most likely crashed with their users connected
+func (h *Hub) expirePeers() {
+	for instance, seen := range h.peers {
+		if time.Since(seen) <= h.connConfig.PresenceTTL {
+			continue
+		}
+		delete(h.peers, instance)
+		users := h.remoteUsersOf(instance)
+		if len(users) > 0 {
+			log.Printf("hub: instance %s went quiet; dropping presence of %d users", instance, len(users))
+		}
+		for _, userID := range users {
+			h.setRemotePresence(userID, instance, false, false)
+		}
+	}
+}
+
+// remoteUsersOf lists the users another instance holds connections of
+func (h *Hub) remoteUsersOf(instance string) []string {
+	var userIDs []string
+	for userID, instances := range h.remoteOnline {
+		if _, ok := instances[instance]; ok {
+			userIDs = append(userIDs, userID)
+		}
+	}
+	return userIDs
+}
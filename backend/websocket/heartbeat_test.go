@@ -0,0 +1,150 @@
+package websocket
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeLeases is a presence store whose renewals hang until their context
+// ends, like a database that stopped answering, and whose sweeps return
+// whatever the test queued
+type fakeLeases struct {
+	mu       sync.Mutex
+	hang     bool
+	timedOut int
+	renewed  [][]string
+	sweeps   chan []string
+}
+
+func (f *fakeLeases) RenewLeases(ctx context.Context, instanceID string, userIDs []string, ttl time.Duration) error {
+	f.mu.Lock()
+	hang := f.hang
+	f.renewed = append(f.renewed, userIDs)
+	f.mu.Unlock()
+	if !hang {
+		return nil
+	}
+	<-ctx.Done()
+	f.mu.Lock()
+	f.timedOut++
+	f.mu.Unlock()
+	return ctx.Err()
+}
+
+func (f *fakeLeases) ReleaseLease(ctx context.Context, instanceID, userID string) error {
+	return nil
+}
+
+func (f *fakeLeases) DropLeases(ctx context.Context, instanceID string) error {
+	return nil
+}
+
+func (f *fakeLeases) SweepLeases(ctx context.Context) ([]string, error) {
+	select {
+	case offline := <-f.sweeps:
+		return offline, nil
+	default:
+		return nil, nil
+	}
+}
+
+func (f *fakeLeases) snapshot() (timedOut int, renewed [][]string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.timedOut, append([][]string(nil), f.renewed...)
+}
+
+func newLeaseHub(t *testing.T, store *fakeLeases, interval time.Duration) *Hub {
+	t.Helper()
+	h := NewHub(nil)
+	cfg := DefaultConnConfig()
+	cfg.PresenceInterval = interval
+	h.SetConnConfig(cfg)
+	if err := h.SetPresenceStore(store, true); err != nil {
+		t.Fatal(err)
+	}
+	go h.Run()
+	return h
+}
+
+func TestHubDeliversWhileLeasesStall(t *testing.T) {
+	store := &fakeLeases{hang: true, sweeps: make(chan []string, 1)}
+	h := newLeaseHub(t, store, 50*time.Millisecond)
+	c := h.NewClient(nil, "alice", "session")
+	h.Register(c)
+	// Let several renewals hang until they time out
+	time.Sleep(200 * time.Millisecond)
+	h.SendDirect("alice", NewFrame("message", map[string]string{"content": "hi"}))
+	if got := nextFrame(t, c, "message"); got["content"] != "hi" {
+		t.Fatalf("got %v, want the message", got)
+	}
+	waitUntil(t, "a renewal to time out", func() bool {
+		timedOut, _ := store.snapshot()
+		return timedOut > 0
+	})
+}
+
+func TestHubSweepResultsReachTheRunLoop(t *testing.T) {
+	store := &fakeLeases{sweeps: make(chan []string, 1)}
+	h := newLeaseHub(t, store, 50*time.Millisecond)
+	viewer := h.NewClient(nil, "viewer", "session")
+	h.Register(viewer)
+	addRemoteUser(t, h, viewer, "crashed", "ghost")
+	store.sweeps <- []string{"ghost"}
+	if got := nextFrame(t, viewer, "user_offline"); got["userId"] != "ghost" {
+		t.Fatalf("got %v, want ghost offline", got)
+	}
+}
+
+func TestHubAppliesSweep(t *testing.T) {
+	store := &fakeLeases{sweeps: make(chan []string, 1)}
+	// No heartbeat renews anything during the test
+	h := newLeaseHub(t, store, time.Hour)
+	viewer := h.NewClient(nil, "viewer", "session")
+	h.Register(viewer)
+	addRemoteUser(t, h, viewer, "crashed", "ghost")
+
+	// The crashed instance stopped renewing ghost, and a renewal that timed
+	// out cost the viewer their lease
+	h.swept <- []string{"ghost", "viewer"}
+	if got := nextFrame(t, viewer, "user_offline"); got["userId"] != "ghost" {
+		t.Fatalf("got %v, want ghost offline", got)
+	}
+	h.requestOnlineUsers(viewer)
+	for _, id := range nextFrame(t, viewer, "online_users")["userIds"].([]interface{}) {
+		if id == "ghost" {
+			t.Fatal("ghost still listed online")
+		}
+	}
+	// Renewed once on connect and again after the sweep
+	waitUntil(t, "the viewer's lease to be renewed", func() bool {
+		_, renewed := store.snapshot()
+		return len(renewed) == 2 && renewed[1][0] == "viewer"
+	})
+}
+
+// addRemoteUser has another instance report the user online and waits until
+// the viewer heard of it
+func addRemoteUser(t *testing.T, h *Hub, viewer *Client, instance, userID string) {
+	t.Helper()
+	h.remote <- BrokerEvent{Kind: EventHeartbeat, Instance: instance, Presence: map[string]bool{userID: false}}
+	for {
+		if nextFrame(t, viewer, "user_online")["userId"] == userID {
+			return
+		}
+	}
+}
+
+// waitUntil polls cond until it holds or two seconds pass
+func waitUntil(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -1,163 +1,659 @@
 package websocket
 
 import (
-	"encoding/json"
+	"chatting-service-app/dto"
 	"chatting-service-app/models"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
 )
 
+// brokerQueueSize bounds events waiting to be published to other instances
+const brokerQueueSize = 1024
+
 // Extend OnlineStatusSetter to include GetUserByID for user data fetch
 // This avoids import cycles and allows the hub to fetch user info
 type OnlineStatusSetter interface {
 	SetOnlineStatus(userID string, isOnline bool) error
 	GetUserByID(userID string) (*models.User, error)
+	// SetIdle records whether every connection of an online user is idle
+	SetIdle(userID string, idle bool) error
+	// LastSeenAudience reports who may see the user's last_seen_at: everyone,
+	// or only the listed users
+	LastSeenAudience(user *models.User) (everyone bool, userIDs []string, err error)
 }
 
+// MessageStore lets the hub replay messages a user missed while offline.
+// MessageService implements it; it is optional.
+type MessageStore interface {
+	UndeliveredMessages(userID string) ([]models.Message, error)
+}
+
+// DirectMessage is delivered to every connection of ToID except Except, which
+// lets the connection that caused an event skip its own echo
 type DirectMessage struct {
-	ToID string
-	Data []byte
+	ToID   string
+	Data   []byte
+	Except *Client
+}
+
+// clientMessage targets one specific connection rather than a user
+type clientMessage struct {
+	Client *Client
+	Data   []byte
+}
+
+// broadcastMessage goes to every connection except those of ExceptUserID
+type broadcastMessage struct {
+	Data         []byte
+	ExceptUserID string
+}
+
+// disconnectRequest selects clients to close by session or by user
+type disconnectRequest struct {
+	UserID    string
+	SessionID string
 }
 
+// Hub tracks every live connection. A user may be connected from several
+// devices at once and counts as online while any of them is. With a Broker,
+// several hubs on different instances share delivery and presence.
 type Hub struct {
-	clients     map[*Client]bool
-	clientsByID map[string]*Client
-	broadcast   chan []byte
-	direct      chan DirectMessage
-	register    chan *Client
-	unregister  chan *Client
-	userService OnlineStatusSetter // Use interface instead of concrete type
+	clients       map[*Client]bool
+	clientsByID   map[string]map[*Client]bool
+	broadcast     chan broadcastMessage
+	direct        chan DirectMessage
+	register      chan *Client
+	unregister    chan *Client
+	disconnect    chan disconnectRequest
+	toClient      chan clientMessage
+	listOnline    chan *Client
+	idleChanged   chan *Client
+	statusChanged chan string
+	userService   OnlineStatusSetter // Use interface instead of concrete type
+	store         MessageStore
+	presence      PresenceStore
+	connConfig    ConnConfig
+	// slow collects clients that overflowed under the disconnect policy; Run
+	// removes them after each event so only the run loop ever closes Send
+	slow  []*Client
+	stats hubCounters
+
+	// userJobs feeds the user worker, which posts what it loaded to lookups
+	userJobs *jobQueue[userJob]
+	lookups  chan userLookup
+	// leaseJobs feeds the lease worker, which posts users it swept to swept
+	leaseJobs *jobQueue[leaseJob]
+	swept     chan []string
+
+	instanceID string
+	broker     Broker
+	outbox     chan BrokerEvent
+	remote     chan BrokerEvent
+	// remoteOnline records which other instances hold connections of a user,
+	// and whether all of those connections are idle
+	remoteOnline map[string]map[string]bool
+	// peers records when each other instance was last heard from
+	peers map[string]time.Time
+
+	// away holds online users announced as idle, localIdle the idle state last
+	// published for a user's connections here and hidden the invisible users
+	away      map[string]bool
+	localIdle map[string]bool
+	hidden    map[string]bool
+
+	handlersMu sync.RWMutex
+	handlers   map[string]HandlerFunc
 }
 
 func NewHub(userService OnlineStatusSetter) *Hub {
-	return &Hub{
-		clients:     make(map[*Client]bool),
-		clientsByID: make(map[string]*Client),
-		broadcast:   make(chan []byte),
-		direct:      make(chan DirectMessage),
-		register:    make(chan *Client),
-		unregister:  make(chan *Client),
-		userService: userService,
+	h := &Hub{
+		clients:       make(map[*Client]bool),
+		clientsByID:   make(map[string]map[*Client]bool),
+		broadcast:     make(chan broadcastMessage),
+		direct:        make(chan DirectMessage),
+		register:      make(chan *Client),
+		unregister:    make(chan *Client),
+		disconnect:    make(chan disconnectRequest),
+		toClient:      make(chan clientMessage),
+		listOnline:    make(chan *Client),
+		idleChanged:   make(chan *Client),
+		statusChanged: make(chan string),
+		userService:   userService,
+		userJobs:      newJobQueue[userJob](),
+		lookups:       make(chan userLookup),
+		leaseJobs:     newJobQueue[leaseJob](),
+		swept:         make(chan []string),
+		connConfig:    DefaultConnConfig(),
+		handlers:      make(map[string]HandlerFunc),
+
+		instanceID:   uuid.NewString(),
+		remote:       make(chan BrokerEvent, brokerQueueSize),
+		remoteOnline: make(map[string]map[string]bool),
+		peers:        make(map[string]time.Time),
+		away:         make(map[string]bool),
+		localIdle:    make(map[string]bool),
+		hidden:       make(map[string]bool),
+	}
+	h.registerBuiltinHandlers()
+	return h
+}
+
+// SetMessageStore enables replay of undelivered messages on connect. Call it before serving clients.
+func (h *Hub) SetMessageStore(store MessageStore) {
+	h.store = store
+}
+
+// HubStats is a snapshot of the hub's gauges and counters
+type HubStats struct {
+	Connections     int64 `json:"connections"`
+	Users           int64 `json:"users"`
+	DroppedFrames   int64 `json:"dropped_frames"`
+	SlowDisconnects int64 `json:"slow_disconnects"`
+	// DroppedEvents counts broker events lost because a queue between the
+	// hub and its broker was full
+	DroppedEvents int64 `json:"dropped_broker_events"`
+}
+
+type hubCounters struct {
+	connections     atomic.Int64
+	users           atomic.Int64
+	droppedFrames   atomic.Int64
+	slowDisconnects atomic.Int64
+	droppedEvents   atomic.Int64
+}
+
+// Stats reports connection counts and how many frames were lost to slow
+// consumers or full broker queues
+func (h *Hub) Stats() HubStats {
+	return HubStats{
+		Connections:     h.stats.connections.Load(),
+		Users:           h.stats.users.Load(),
+		DroppedFrames:   h.stats.droppedFrames.Load(),
+		SlowDisconnects: h.stats.slowDisconnects.Load(),
+		DroppedEvents:   h.stats.droppedEvents.Load(),
+	}
+}
+
+// enqueue queues a frame for the client without ever blocking the run loop.
+// When the buffer is full the frame is counted as dropped and the slow-consumer
+// policy applies: either the oldest queued frame makes room, or the client is
+// scheduled for removal. It must only be called from Run.
+func (h *Hub) enqueue(client *Client, data []byte) {
+	if _, ok := h.clients[client]; !ok {
+		return
 	}
+	select {
+	case client.Send <- data:
+		return
+	default:
+	}
+	h.stats.droppedFrames.Add(1)
+	if h.connConfig.SlowConsumer == SlowConsumerDropOldest {
+		select {
+		case <-client.Send:
+		default:
+		}
+		select {
+		case client.Send <- data:
+		default:
+		}
+		return
+	}
+	h.slow = append(h.slow, client)
+}
+
+// evictSlow removes clients that overflowed their buffer; removing one may
+// queue frames that overflow others, so it runs until nothing is left
+func (h *Hub) evictSlow() {
+	for len(h.slow) > 0 {
+		client := h.slow[0]
+		h.slow = h.slow[1:]
+		if _, ok := h.clients[client]; ok {
+			h.stats.slowDisconnects.Add(1)
+			h.removeClient(client)
+		}
+	}
+}
+
+// SetConnConfig sets the keepalive and size limits for connections served from now on
+func (h *Hub) SetConnConfig(cfg ConnConfig) {
+	h.connConfig = cfg.normalized()
+}
+
+// SetBroker shares delivery and presence with the hubs of other instances.
+// Call it once, before serving clients.
+func (h *Hub) SetBroker(broker Broker) error {
+	h.broker = broker
+	h.outbox = make(chan BrokerEvent, brokerQueueSize)
+	go h.publishLoop()
+	return broker.Subscribe(func(event BrokerEvent) {
+		if event.Instance == h.instanceID {
+			return
+		}
+		// Never block the broker: a busy hub must not stall other hubs
+		select {
+		case h.remote <- event:
+		default:
+			h.stats.droppedEvents.Add(1)
+		}
+	})
+}
+
+// publish queues an event for other instances; publishing happens off the
+// run loop so a slow broker never stalls local delivery. When the queue is
+// full the event is dropped and counted; delivery between instances is best
+// effort and heartbeats repair lost presence.
+func (h *Hub) publish(event BrokerEvent) {
+	if h.broker == nil {
+		return
+	}
+	event.Instance = h.instanceID
+	select {
+	case h.outbox <- event:
+	default:
+		h.stats.droppedEvents.Add(1)
+	}
+}
+
+func (h *Hub) publishLoop() {
+	for event := range h.outbox {
+		if err := h.broker.Publish(event); err != nil {
+			log.Printf("hub: publishing %s event: %v", event.Kind, err)
+		}
+	}
+}
+
+// replayUndelivered pushes messages that arrived while the user was offline.
+// They stay undelivered until the client acknowledges them with "delivered" frames.
+func (h *Hub) replayUndelivered(client *Client) {
+	messages, err := h.store.UndeliveredMessages(client.ID)
+	if err != nil {
+		return
+	}
+	for _, msg := range messages {
+		h.SendToClient(client, NewFrame("message", msg))
+	}
+}
+
+// Handle registers the handler for an inbound frame type, replacing any existing one
+func (h *Hub) Handle(msgType string, handler HandlerFunc) {
+	h.handlersMu.Lock()
+	defer h.handlersMu.Unlock()
+	h.handlers[msgType] = handler
+}
+
+func (h *Hub) handler(msgType string) HandlerFunc {
+	h.handlersMu.RLock()
+	defer h.handlersMu.RUnlock()
+	return h.handlers[msgType]
+}
+
+// requestOnlineUsers asks the run loop to send the online user list to one client
+func (h *Hub) requestOnlineUsers(client *Client) {
+	h.listOnline <- client
 }
 
 func (h *Hub) Register(client *Client) {
 	h.register <- client
 }
 
+// SendToClient queues data for a single connection if it is still registered
+func (h *Hub) SendToClient(client *Client, data []byte) {
+	h.toClient <- clientMessage{Client: client, Data: data}
+}
+
+// DisconnectSession closes every connection that was opened with the given session
+func (h *Hub) DisconnectSession(sessionID string) {
+	h.disconnect <- disconnectRequest{SessionID: sessionID}
+	h.publish(BrokerEvent{Kind: EventDisconnect, SessionID: sessionID})
+}
+
+// DisconnectUser closes every connection belonging to the user
+func (h *Hub) DisconnectUser(userID string) {
+	h.disconnect <- disconnectRequest{UserID: userID}
+	h.publish(BrokerEvent{Kind: EventDisconnect, UserID: userID})
+}
+
+// SendDirect delivers data to every connection of the user
 func (h *Hub) SendDirect(toID string, data []byte) {
 	h.direct <- DirectMessage{ToID: toID, Data: data}
+	h.publish(BrokerEvent{Kind: EventDirect, UserID: toID, Data: data})
+}
+
+// SendToUserExcept delivers data to the user's connections other than except,
+// e.g. to sync a user's other devices; a nil except reaches all of them.
+// Devices connected to other instances always receive it.
+func (h *Hub) SendToUserExcept(userID string, except *Client, data []byte) {
+	h.direct <- DirectMessage{ToID: userID, Data: data, Except: except}
+	h.publish(BrokerEvent{Kind: EventDirect, UserID: userID, Data: data})
+}
+
+// SendToUsers delivers the same payload to every listed user that is connected
+func (h *Hub) SendToUsers(userIDs []string, data []byte) {
+	for _, id := range userIDs {
+		h.SendDirect(id, data)
+	}
 }
 
+// getOnlineUserIDs lists users connected here or on any other instance,
+// except invisible ones
 func (h *Hub) getOnlineUserIDs() []string {
-	userIDs := make([]string, 0, len(h.clientsByID))
+	userIDs := make([]string, 0, len(h.clientsByID)+len(h.remoteOnline))
 	for id := range h.clientsByID {
-		userIDs = append(userIDs, id)
+		if !h.hidden[id] {
+			userIDs = append(userIDs, id)
+		}
+	}
+	for id := range h.remoteOnline {
+		if _, local := h.clientsByID[id]; !local && !h.hidden[id] {
+			userIDs = append(userIDs, id)
+		}
 	}
 	return userIDs
 }
 
-func (h *Hub) broadcastUserOnline(userID string) {
-	// Fetch user data for the new online user
-	var userData map[string]interface{}
+func (h *Hub) isOnline(userID string) bool {
+	return len(h.clientsByID[userID]) > 0 || len(h.remoteOnline[userID]) > 0
+}
+
+// announceOnline announces a user who just came online. Until their settings
+// are loaded they count as invisible, so an invisible user never shows up in
+// online lists in the meantime.
+func (h *Hub) announceOnline(userID string) {
 	if h.userService != nil {
-		if user, err := h.userService.GetUserByID(userID); err == nil && user != nil {
-			userData = map[string]interface{}{
-				"id":       user.ID,
-				"username": user.Username,
-				"email":    user.Email,
-			}
-		}
+		h.hidden[userID] = true
+	}
+	h.broadcastUserOnline(userID)
+}
+
+// broadcastUserOnline announces that a user is online, or that their status
+// changed while online, once the user worker loaded their settings. Invisible
+// users are not announced.
+func (h *Hub) broadcastUserOnline(userID string) {
+	if !h.queueUserJob(userJob{kind: jobAnnounceOnline, userID: userID}) {
+		h.sendUserOnline(userID, nil)
 	}
+}
+
+// sendUserOnline sends user_online with the user's details, or only their ID
+// when they could not be loaded
+func (h *Hub) sendUserOnline(userID string, user *models.User) {
 	msg := map[string]interface{}{
-		"type":   "user_online",
 		"userId": userID,
+		"status": models.PresenceOnline,
 	}
-	if userData != nil {
-		msg["user"] = userData
+	if user != nil {
+		user.IsOnline = true
+		user.IsIdle = h.away[userID]
+		presence := dto.NewUserPresence(user, false)
+		msg["user"] = map[string]interface{}{
+			"id":       user.ID,
+			"username": user.Username,
+			"email":    user.Email,
+		}
+		msg["status"] = presence.Status
+		if presence.CustomStatus != "" {
+			msg["custom_status"] = presence.CustomStatus
+			msg["custom_status_expires_at"] = presence.CustomStatusExpiresAt
+		}
 	}
-	data, _ := json.Marshal(msg)
+	data := NewFrame("user_online", msg)
 	for client := range h.clients {
-		client.Send <- data
+		h.enqueue(client, data)
 	}
 }
 
+// broadcastUserOffline announces that a user went offline or invisible, once
+// the user worker loaded who may see their last_seen_at
 func (h *Hub) broadcastUserOffline(userID string) {
-	msg := map[string]interface{}{
-		"type":   "user_offline",
-		"userId": userID,
+	if !h.queueUserJob(userJob{kind: jobAnnounceOffline, userID: userID}) {
+		h.sendUserOffline(userLookup{userID: userID})
+	}
+}
+
+// sendUserOffline sends user_offline; only the viewers the user's privacy
+// settings allow receive last_seen_at
+func (h *Hub) sendUserOffline(lookup userLookup) {
+	userID := lookup.userID
+	msg := map[string]interface{}{"userId": userID}
+	data := NewFrame("user_offline", msg)
+	withLastSeen := data
+	audience := map[string]bool{userID: true}
+	if lookup.lastSeen {
+		for _, id := range lookup.audience {
+			audience[id] = true
+		}
+		msg["last_seen_at"] = lookup.user.LastSeenAt
+		withLastSeen = NewFrame("user_offline", msg)
 	}
-	data, _ := json.Marshal(msg)
 	for client := range h.clients {
-		client.Send <- data
+		if lookup.everyone || audience[client.ID] {
+			h.enqueue(client, withLastSeen)
+		} else {
+			h.enqueue(client, data)
+		}
 	}
 }
 
 func (h *Hub) sendOnlineUsersList(client *Client) {
-	msg := map[string]interface{}{
-		"type":    "online_users",
+	data := NewFrame("online_users", map[string]interface{}{
 		"userIds": h.getOnlineUserIDs(),
-	}
-	data, _ := json.Marshal(msg)
-	client.Send <- data
+	})
+	h.enqueue(client, data)
 }
 
-// BroadcastExcept sends a message to all connected clients except the sender (by user ID), using goroutines for concurrency.
+// BroadcastExcept sends a message to every connected client except the sender's (by user ID), on all instances
 func (h *Hub) BroadcastExcept(senderID string, data []byte) {
-	for id, client := range h.clientsByID {
-		if id == senderID {
+	h.broadcast <- broadcastMessage{Data: data, ExceptUserID: senderID}
+	h.publish(BrokerEvent{Kind: EventBroadcast, ExceptUserID: senderID, Data: data})
+}
+
+// addClient registers the connection and reports whether it is the user's first
+func (h *Hub) addClient(client *Client) bool {
+	h.clients[client] = true
+	conns, ok := h.clientsByID[client.ID]
+	if !ok {
+		conns = make(map[*Client]bool)
+		h.clientsByID[client.ID] = conns
+	}
+	conns[client] = true
+	h.stats.connections.Add(1)
+	if !ok {
+		h.stats.users.Add(1)
+	}
+	return !ok
+}
+
+// removeClient drops the client from the hub and closes its send channel. The
+// user is marked offline only when their last connection on any instance goes away.
+func (h *Hub) removeClient(client *Client) {
+	if _, ok := h.clients[client]; !ok {
+		return
+	}
+	delete(h.clients, client)
+	close(client.Send)
+	client.idleTimer.Stop()
+	h.stats.connections.Add(-1)
+	conns := h.clientsByID[client.ID]
+	delete(conns, client)
+	if len(conns) > 0 {
+		h.refreshIdle(client.ID)
+		return
+	}
+	delete(h.clientsByID, client.ID)
+	delete(h.localIdle, client.ID)
+	h.stats.users.Add(-1)
+	h.releaseLease(client.ID)
+	h.publish(BrokerEvent{Kind: EventPresence, UserID: client.ID, Online: false})
+	if h.isOnline(client.ID) {
+		h.refreshIdle(client.ID)
+		return
+	}
+	h.goOffline(client.ID)
+}
+
+// goOffline records that the user's last connection anywhere closed and tells
+// everyone, unless they were invisible and so already looked offline
+func (h *Hub) goOffline(userID string) {
+	hidden := h.hidden[userID]
+	delete(h.away, userID)
+	delete(h.hidden, userID)
+	h.queueUserJob(userJob{kind: jobSetOnline, userID: userID, value: false})
+	if !hidden {
+		h.broadcastUserOffline(userID)
+	}
+}
+
+// deliver sends data to the local connections of a user, skipping except
+func (h *Hub) deliver(userID string, except *Client, data []byte) {
+	for client := range h.clientsByID[userID] {
+		if client == except {
+			continue
+		}
+		h.enqueue(client, data)
+	}
+}
+
+func (h *Hub) broadcastLocal(msg broadcastMessage) {
+	for client := range h.clients {
+		if msg.ExceptUserID != "" && client.ID == msg.ExceptUserID {
 			continue
 		}
-		go func(c *Client) {
+		h.enqueue(client, msg.Data)
+	}
+}
+
+func (h *Hub) disconnectLocal(req disconnectRequest) {
+	revoked := NewFrame("session_revoked", nil)
+	for client := range h.clients {
+		if (req.SessionID != "" && client.SessionID == req.SessionID) ||
+			(req.UserID != "" && client.ID == req.UserID) {
+			// Best effort notice before the socket is closed
 			select {
-			case c.Send <- data:
+			case client.Send <- revoked:
 			default:
-				close(c.Send)
-				h.unregister <- c
 			}
-		}(client)
+			h.removeClient(client)
+		}
+	}
+}
+
+// setRemotePresence records whether another instance holds connections of the
+// user, and whether they are all idle, and announces the change when it flips
+// the user's overall presence
+func (h *Hub) setRemotePresence(userID, instance string, online, idle bool) {
+	wasOnline := h.isOnline(userID)
+	instances := h.remoteOnline[userID]
+	if online {
+		if instances == nil {
+			instances = make(map[string]bool)
+			h.remoteOnline[userID] = instances
+		}
+		instances[instance] = idle
+	} else {
+		delete(instances, instance)
+		if len(instances) == 0 {
+			delete(h.remoteOnline, userID)
+		}
+	}
+	switch isOnline := h.isOnline(userID); {
+	case isOnline && !wasOnline:
+		h.announceOnline(userID)
+	case !isOnline && wasOnline:
+		hidden := h.hidden[userID]
+		delete(h.away, userID)
+		delete(h.hidden, userID)
+		if !hidden {
+			h.broadcastUserOffline(userID)
+		}
+		return
+	}
+	h.refreshIdle(userID)
+}
+
+// applyRemote handles an event published by another instance. An instance
+// heard from for the first time gets a heartbeat so it learns who is here.
+func (h *Hub) applyRemote(event BrokerEvent) {
+	if h.notePeer(event.Instance) {
+		h.publishHeartbeat()
+	}
+	switch event.Kind {
+	case EventDirect:
+		h.deliver(event.UserID, nil, event.Data)
+	case EventBroadcast:
+		h.broadcastLocal(broadcastMessage{Data: event.Data, ExceptUserID: event.ExceptUserID})
+	case EventDisconnect:
+		h.disconnectLocal(disconnectRequest{UserID: event.UserID, SessionID: event.SessionID})
+	case EventPresence:
+		h.setRemotePresence(event.UserID, event.Instance, event.Online, event.Idle)
+	case EventStatus:
+		h.announceStatus(event.UserID)
+	case EventHeartbeat:
+		h.applyHeartbeat(event)
 	}
 }
 
 func (h *Hub) Run() {
+	ticker := time.NewTicker(h.connConfig.PresenceInterval)
+	defer ticker.Stop()
+	if h.userService != nil {
+		go h.userWorker()
+	}
+	// Announce this instance right away rather than a full interval from now
+	h.publishHeartbeat()
 	for {
 		select {
 		case client := <-h.register:
-			h.clients[client] = true
-			h.clientsByID[client.ID] = client
-			if h.userService != nil {
-				_ = h.userService.SetOnlineStatus(client.ID, true)
+			first := h.addClient(client)
+			announce := first && len(h.remoteOnline[client.ID]) == 0
+			if first {
+				h.acquireLease(client.ID)
+				h.publish(BrokerEvent{Kind: EventPresence, UserID: client.ID, Online: true})
+			}
+			if announce {
+				h.queueUserJob(userJob{kind: jobSetOnline, userID: client.ID, value: true})
 			}
 			h.sendOnlineUsersList(client)
-			h.broadcastUserOnline(client.ID)
+			if announce {
+				h.announceOnline(client.ID)
+			}
+			h.refreshIdle(client.ID)
+			if h.store != nil {
+				go h.replayUndelivered(client)
+			}
 		case client := <-h.unregister:
+			h.removeClient(client)
+		case req := <-h.disconnect:
+			h.disconnectLocal(req)
+		case msg := <-h.broadcast:
+			h.broadcastLocal(msg)
+		case client := <-h.listOnline:
 			if _, ok := h.clients[client]; ok {
-				delete(h.clients, client)
-				delete(h.clientsByID, client.ID)
-				close(client.Send)
-				if h.userService != nil {
-					_ = h.userService.SetOnlineStatus(client.ID, false)
-				}
-				h.broadcastUserOffline(client.ID)
+				h.sendOnlineUsersList(client)
 			}
-		case message := <-h.broadcast:
-			for client := range h.clients {
-				select {
-				case client.Send <- message:
-				default:
-					close(client.Send)
-					delete(h.clients, client)
-					delete(h.clientsByID, client.ID)
-				}
+		case client := <-h.idleChanged:
+			if _, ok := h.clients[client]; ok {
+				h.refreshIdle(client.ID)
 			}
+		case userID := <-h.statusChanged:
+			h.announceStatus(userID)
+		case cm := <-h.toClient:
+			h.enqueue(cm.Client, cm.Data)
 		case dm := <-h.direct:
-			if client, ok := h.clientsByID[dm.ToID]; ok {
-				select {
-				case client.Send <- dm.Data:
-				default:
-					close(client.Send)
-					delete(h.clients, client)
-					delete(h.clientsByID, client.ID)
-				}
-			}
+			h.deliver(dm.ToID, dm.Except, dm.Data)
+		case event := <-h.remote:
+			h.applyRemote(event)
+		case lookup := <-h.lookups:
+			h.applyLookup(lookup)
+		case offline := <-h.swept:
+			h.applySweep(offline)
+		case <-ticker.C:
+			h.heartbeat()
 		}
+		h.evictSlow()
 	}
-}
\ No newline at end of file
+}
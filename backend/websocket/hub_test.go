@@ -0,0 +1,163 @@
+package websocket
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newTestHub(t *testing.T, cfg ConnConfig) *Hub {
+	t.Helper()
+	h := NewHub(nil)
+	h.SetConnConfig(cfg)
+	go h.Run()
+	return h
+}
+
+// drainUntilClosed reads the client's queue until the hub closes it
+func drainUntilClosed(t *testing.T, c *Client) [][]byte {
+	t.Helper()
+	var frames [][]byte
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case frame, ok := <-c.Send:
+			if !ok {
+				return frames
+			}
+			frames = append(frames, frame)
+		case <-timeout:
+			t.Fatalf("send channel of %s never closed", c.ID)
+		}
+	}
+}
+
+// barrier returns once the run loop finished every event sent before it
+func barrier(h *Hub) {
+	h.direct <- DirectMessage{ToID: "nobody"}
+	h.direct <- DirectMessage{ToID: "nobody"}
+}
+
+func TestHubRegisterUnregisterChurn(t *testing.T) {
+	h := newTestHub(t, DefaultConnConfig())
+	frame := NewFrame("message", map[string]string{"content": "hi"})
+	var wg sync.WaitGroup
+	for g := 0; g < 20; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			userID := fmt.Sprintf("user-%d", g%4)
+			for i := 0; i < 50; i++ {
+				c := h.NewClient(nil, userID, "session")
+				h.Register(c)
+				h.SendDirect(userID, frame)
+				h.unregister <- c
+				drainUntilClosed(t, c)
+			}
+		}(g)
+	}
+	wg.Wait()
+	barrier(h)
+	if stats := h.Stats(); stats.Connections != 0 || stats.Users != 0 {
+		t.Fatalf("stats after churn = %+v, want no connections or users", stats)
+	}
+}
+
+func TestHubSlowConsumerDisconnect(t *testing.T) {
+	cfg := DefaultConnConfig()
+	cfg.SendBuffer = 2
+	cfg.SlowConsumer = SlowConsumerDisconnect
+	h := newTestHub(t, cfg)
+	slow := h.NewClient(nil, "slow", "session")
+	h.Register(slow)
+	for i := 0; i < 5; i++ {
+		h.SendDirect("slow", NewFrame("message", i))
+	}
+	frames := drainUntilClosed(t, slow)
+	if len(frames) > cfg.SendBuffer {
+		t.Fatalf("got %d queued frames, want at most %d", len(frames), cfg.SendBuffer)
+	}
+	barrier(h)
+	stats := h.Stats()
+	if stats.SlowDisconnects != 1 || stats.Connections != 0 || stats.DroppedFrames == 0 {
+		t.Fatalf("stats = %+v, want one slow disconnect and dropped frames", stats)
+	}
+}
+
+func TestHubSlowConsumerDropOldest(t *testing.T) {
+	cfg := DefaultConnConfig()
+	cfg.SendBuffer = 2
+	cfg.SlowConsumer = SlowConsumerDropOldest
+	h := newTestHub(t, cfg)
+	slow := h.NewClient(nil, "slow", "session")
+	h.Register(slow)
+	var sent [][]byte
+	for i := 0; i < 5; i++ {
+		frame := NewFrame("message", i)
+		sent = append(sent, frame)
+		h.SendDirect("slow", frame)
+	}
+	barrier(h)
+	stats := h.Stats()
+	if stats.Connections != 1 || stats.SlowDisconnects != 0 {
+		t.Fatalf("stats = %+v, want the slow client kept", stats)
+	}
+	// Presence frames and five messages went into a buffer of two
+	if stats.DroppedFrames < 4 {
+		t.Fatalf("dropped frames = %d, want at least 4", stats.DroppedFrames)
+	}
+	for _, want := range sent[3:] {
+		if got := <-slow.Send; string(got) != string(want) {
+			t.Fatalf("queued %s, want the newest frames", got)
+		}
+	}
+	h.unregister <- slow
+	drainUntilClosed(t, slow)
+}
+
+func TestHubBroadcastRacesUnregister(t *testing.T) {
+	cfg := DefaultConnConfig()
+	cfg.SendBuffer = 4
+	h := newTestHub(t, cfg)
+	clients := make([]*Client, 50)
+	for i := range clients {
+		clients[i] = h.NewClient(nil, fmt.Sprintf("user-%d", i), "session")
+		h.Register(clients[i])
+	}
+	var wg sync.WaitGroup
+	for _, c := range clients {
+		wg.Add(1)
+		go func(c *Client) {
+			defer wg.Done()
+			drainUntilClosed(t, c)
+		}(c)
+	}
+	stop := make(chan struct{})
+	var senders sync.WaitGroup
+	for g := 0; g < 4; g++ {
+		senders.Add(1)
+		go func(g int) {
+			defer senders.Done()
+			frame := NewFrame("message", g)
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+					h.BroadcastExcept(fmt.Sprintf("user-%d", g), frame)
+				}
+			}
+		}(g)
+	}
+	for _, c := range clients {
+		h.unregister <- c
+	}
+	wg.Wait()
+	close(stop)
+	senders.Wait()
+	barrier(h)
+	if stats := h.Stats(); stats.Connections != 0 || stats.Users != 0 {
+		t.Fatalf("stats = %+v, want every client gone", stats)
+	}
+}
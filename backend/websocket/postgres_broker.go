@@ -0,0 +1,155 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const (
+	// pgChannel is the LISTEN/NOTIFY channel shared by all instances
+	pgChannel = "hub_events"
+	// pgMaxNotifyPayload stays under PostgreSQL's 8000 byte NOTIFY limit;
+	// larger events are stored in hub_events and only their ID is notified
+	pgMaxNotifyPayload = 7900
+	// pgSpillRetention is how long spilled events are kept for slow listeners
+	pgSpillRetention = 5 * time.Minute
+	pgMaxBackoff     = 30 * time.Second
+)
+
+// PostgresBroker shares hub events between instances through PostgreSQL
+// LISTEN/NOTIFY. Delivery is at-most-once: events published while a listener
+// is reconnecting are lost, which clients recover from with sync frames.
+type PostgresBroker struct {
+	pool   *pgxpool.Pool
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	mu         sync.Mutex
+	subscribed bool
+}
+
+// NewPostgresBroker connects to the database described by dsn
+func NewPostgresBroker(dsn string) (*PostgresBroker, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	pool, err := pgxpool.New(ctx, dsn)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	if err := pool.Ping(ctx); err != nil {
+		pool.Close()
+		cancel()
+		return nil, err
+	}
+	return &PostgresBroker{pool: pool, ctx: ctx, cancel: cancel}, nil
+}
+
+func (b *PostgresBroker) Publish(event BrokerEvent) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	payload := string(data)
+	if len(payload) > pgMaxNotifyPayload {
+		var id int64
+		err := b.pool.QueryRow(b.ctx, "INSERT INTO hub_events (payload) VALUES ($1) RETURNING id", payload).Scan(&id)
+		if err != nil {
+			return err
+		}
+		payload = "#" + strconv.FormatInt(id, 10)
+		_, _ = b.pool.Exec(b.ctx, "DELETE FROM hub_events WHERE created_at < $1", time.Now().Add(-pgSpillRetention))
+	}
+	_, err = b.pool.Exec(b.ctx, "SELECT pg_notify($1, $2)", pgChannel, payload)
+	return err
+}
+
+func (b *PostgresBroker) Subscribe(handler func(BrokerEvent)) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.subscribed {
+		return errors.New("postgres broker already has a subscriber")
+	}
+	b.subscribed = true
+	b.wg.Add(1)
+	go b.listen(handler)
+	return nil
+}
+
+// listen holds a dedicated connection on LISTEN and reconnects with backoff
+// until the broker is closed
+func (b *PostgresBroker) listen(handler func(BrokerEvent)) {
+	defer b.wg.Done()
+	backoff := time.Second
+	for b.ctx.Err() == nil {
+		err := b.listenOnce(handler)
+		if b.ctx.Err() != nil {
+			return
+		}
+		log.Printf("hub broker: listener stopped: %v; reconnecting in %s", err, backoff)
+		select {
+		case <-time.After(backoff):
+		case <-b.ctx.Done():
+			return
+		}
+		if backoff *= 2; backoff > pgMaxBackoff {
+			backoff = pgMaxBackoff
+		}
+	}
+}
+
+func (b *PostgresBroker) listenOnce(handler func(BrokerEvent)) error {
+	pooled, err := b.pool.Acquire(b.ctx)
+	if err != nil {
+		return err
+	}
+	// The connection keeps LISTEN state, so never hand it back to the pool
+	conn := pooled.Hijack()
+	defer conn.Close(context.Background())
+	if _, err := conn.Exec(b.ctx, "LISTEN "+pgChannel); err != nil {
+		return err
+	}
+	for {
+		notification, err := conn.WaitForNotification(b.ctx)
+		if err != nil {
+			return err
+		}
+		event, err := b.decode(notification.Payload)
+		if err != nil {
+			log.Printf("hub broker: dropping event: %v", err)
+			continue
+		}
+		handler(event)
+	}
+}
+
+// decode parses a notification, loading spilled events from hub_events
+func (b *PostgresBroker) decode(payload string) (BrokerEvent, error) {
+	var event BrokerEvent
+	if ref, ok := strings.CutPrefix(payload, "#"); ok {
+		id, err := strconv.ParseInt(ref, 10, 64)
+		if err != nil {
+			return event, err
+		}
+		if err := b.pool.QueryRow(b.ctx, "SELECT payload FROM hub_events WHERE id = $1", id).Scan(&payload); err != nil {
+			return event, err
+		}
+	}
+	err := json.Unmarshal([]byte(payload), &event)
+	return event, err
+}
+
+func (b *PostgresBroker) Close() error {
+	b.cancel()
+	b.wg.Wait()
+	b.pool.Close()
+	return nil
+}
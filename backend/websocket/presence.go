@@ -0,0 +1,130 @@
+package websocket
+
+import "chatting-service-app/models"
+
+// ActivityPayload is the payload of an inbound "activity" frame. Clients send
+// it while the user interacts, or with Idle set when the user walks away.
+type ActivityPayload struct {
+	Idle bool `json:"idle,omitempty"`
+}
+
+// activityFrames are inbound frame types that show the user is active
+var activityFrames = map[string]bool{
+	"message":      true,
+	"typing_start": true,
+	"read":         true,
+	"read_up_to":   true,
+}
+
+func handleActivity(c *Client, env Envelope) (interface{}, error) {
+	var payload ActivityPayload
+	if err := DecodePayload(env, &payload); err != nil {
+		return nil, err
+	}
+	if payload.Idle {
+		c.markIdle()
+	} else {
+		c.markActive()
+	}
+	return nil, nil
+}
+
+// markActive restarts the connection's idle countdown and, if it was idle,
+// tells the hub
+func (c *Client) markActive() {
+	c.idleTimer.Reset(c.Hub.connConfig.IdleTimeout)
+	if c.idle.CompareAndSwap(true, false) {
+		c.Hub.idleChanged <- c
+	}
+}
+
+// markIdle marks the connection idle until its next activity
+func (c *Client) markIdle() {
+	c.idleTimer.Stop()
+	if c.idle.CompareAndSwap(false, true) {
+		c.Hub.idleChanged <- c
+	}
+}
+
+// RefreshPresence re-announces a user after they changed their status, custom
+// status or privacy settings, on every instance
+func (h *Hub) RefreshPresence(userID string) {
+	h.statusChanged <- userID
+	h.publish(BrokerEvent{Kind: EventStatus, UserID: userID})
+}
+
+// noteHidden remembers whether a loaded user is invisible. A failed lookup
+// leaves them visible, as there is nothing to hide them by.
+func (h *Hub) noteHidden(userID string, user *models.User) {
+	if user != nil && user.PresenceStatus == models.PresenceInvisible {
+		h.hidden[userID] = true
+	} else {
+		delete(h.hidden, userID)
+	}
+}
+
+// announceStatus tells everyone about a connected user's new settings once
+// they are loaded: going invisible looks like going offline, and coming back
+// like coming online
+func (h *Hub) announceStatus(userID string) {
+	if !h.isOnline(userID) {
+		return
+	}
+	h.queueUserJob(userJob{kind: jobRefreshStatus, userID: userID})
+}
+
+// localIdleOf reports whether the user has connections here and all are idle
+func (h *Hub) localIdleOf(userID string) bool {
+	conns := h.clientsByID[userID]
+	for client := range conns {
+		if !client.idle.Load() {
+			return false
+		}
+	}
+	return len(conns) > 0
+}
+
+// isIdle reports whether every connection of an online user, on any instance, is idle
+func (h *Hub) isIdle(userID string) bool {
+	if !h.isOnline(userID) {
+		return false
+	}
+	if len(h.clientsByID[userID]) > 0 && !h.localIdleOf(userID) {
+		return false
+	}
+	for _, idle := range h.remoteOnline[userID] {
+		if !idle {
+			return false
+		}
+	}
+	return true
+}
+
+// refreshIdle shares a change in the idle state of the user's connections here
+// with other instances, and announces the user as away or back when their
+// overall idle state flips
+func (h *Hub) refreshIdle(userID string) {
+	if len(h.clientsByID[userID]) > 0 {
+		if local := h.localIdleOf(userID); local != h.localIdle[userID] {
+			if local {
+				h.localIdle[userID] = true
+			} else {
+				delete(h.localIdle, userID)
+			}
+			h.publish(BrokerEvent{Kind: EventPresence, UserID: userID, Online: true, Idle: local})
+		}
+	}
+	idle := h.isIdle(userID)
+	if idle == h.away[userID] {
+		return
+	}
+	if idle {
+		h.away[userID] = true
+	} else {
+		delete(h.away, userID)
+	}
+	h.queueUserJob(userJob{kind: jobSetIdle, userID: userID, value: idle})
+	if !h.hidden[userID] {
+		h.broadcastUserOnline(userID)
+	}
+}
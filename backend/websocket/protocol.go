@@ -0,0 +1,196 @@
+package websocket
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/google/uuid"
+)
+
+// ProtocolVersion is the envelope version spoken by this server. Frames without
+// a version are treated as version 1. The wire format is documented in asyncapi.yaml.
+const ProtocolVersion = 1
+
+// Envelope is the frame format used in both directions.
+//
+// ID is chosen by the client and echoed back on the matching ack or error frame.
+// Ack asks the server to confirm a frame once its handler has succeeded.
+type Envelope struct {
+	V       int             `json:"v,omitempty"`
+	Type    string          `json:"type"`
+	ID      string          `json:"id,omitempty"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+	Ack     bool            `json:"ack,omitempty"`
+}
+
+// Frame types sent by the server
+const (
+	TypeAck   = "ack"
+	TypeError = "error"
+)
+
+// Error codes carried in error frames
+const (
+	ErrCodeInvalidJSON        = "invalid_json"
+	ErrCodeMissingType        = "missing_type"
+	ErrCodeUnsupportedVersion = "unsupported_version"
+	ErrCodeUnknownType        = "unknown_type"
+	ErrCodeInvalidPayload     = "invalid_payload"
+	ErrCodeUnauthorized       = "unauthorized"
+	ErrCodeForbidden          = "forbidden"
+	ErrCodeRateLimited        = "rate_limited"
+	ErrCodeInternal           = "internal_error"
+)
+
+// ProtocolError is returned by handlers to send a specific error code to the client
+type ProtocolError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *ProtocolError) Error() string {
+	return e.Code + ": " + e.Message
+}
+
+// NewProtocolError builds a ProtocolError with a formatted message
+func NewProtocolError(code, format string, args ...interface{}) *ProtocolError {
+	return &ProtocolError{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+// HandlerFunc handles one inbound frame type. A non-nil result is sent back as
+// the ack payload when the client asked for one; an error becomes an error frame.
+type HandlerFunc func(c *Client, env Envelope) (interface{}, error)
+
+// ChatMessagePayload is the payload of an inbound "message" frame. ClientMessageID
+// is an optional idempotency key: resending with the same key returns the
+// original message instead of storing a duplicate.
+type ChatMessagePayload struct {
+	RecipientID     uuid.UUID  `json:"recipient_id"`
+	ConversationID  *uuid.UUID `json:"conversation_id,omitempty"`
+	Content         string     `json:"content"`
+	MediaURL        string     `json:"media_url,omitempty"`
+	IsBroadcast     bool       `json:"is_broadcast,omitempty"`
+	ClientMessageID string     `json:"client_msg_id,omitempty"`
+	ReplyToID       *uuid.UUID `json:"reply_to_id,omitempty"`
+	ThreadRootID    *uuid.UUID `json:"thread_root_id,omitempty"`
+}
+
+// ReceiptPayload is the payload of inbound "delivered", "read" and "read_up_to" frames
+type ReceiptPayload struct {
+	MessageID uuid.UUID `json:"message_id"`
+}
+
+// TypingPayload is the payload of inbound "typing_start" and "typing_stop"
+// frames: the recipient of a direct chat or the group conversation
+type TypingPayload struct {
+	RecipientID    *uuid.UUID `json:"recipient_id,omitempty"`
+	ConversationID *uuid.UUID `json:"conversation_id,omitempty"`
+}
+
+// SyncPayload is the payload of an inbound "sync" frame: Since is a message
+// cursor (next_cursor from a previous sync or history page)
+type SyncPayload struct {
+	Since string `json:"since"`
+	Limit int    `json:"limit,omitempty"`
+}
+
+// AuthPayload is the payload of an inbound "auth" frame carrying a renewed access token
+type AuthPayload struct {
+	Token string `json:"token"`
+}
+
+// NewFrame encodes an outbound frame of the given type
+func NewFrame(msgType string, payload interface{}) []byte {
+	env := Envelope{V: ProtocolVersion, Type: msgType}
+	if payload != nil {
+		raw, err := json.Marshal(payload)
+		if err != nil {
+			return nil
+		}
+		env.Payload = raw
+	}
+	data, _ := json.Marshal(env)
+	return data
+}
+
+// DecodePayload unmarshals the frame payload into v, reporting failures as invalid_payload
+func DecodePayload(env Envelope, v interface{}) error {
+	if len(env.Payload) == 0 {
+		return NewProtocolError(ErrCodeInvalidPayload, "%s frame requires a payload", env.Type)
+	}
+	if err := json.Unmarshal(env.Payload, v); err != nil {
+		return NewProtocolError(ErrCodeInvalidPayload, "invalid %s payload: %v", env.Type, err)
+	}
+	return nil
+}
+
+// parseEnvelope decodes and validates an inbound frame. Legacy clients that put
+// fields next to "type" instead of inside "payload" are accepted by treating the
+// whole frame as the payload.
+func parseEnvelope(message []byte) (Envelope, error) {
+	var env Envelope
+	if err := json.Unmarshal(message, &env); err != nil {
+		return env, NewProtocolError(ErrCodeInvalidJSON, "frame is not valid JSON")
+	}
+	if env.Type == "" {
+		return env, NewProtocolError(ErrCodeMissingType, "frame has no type")
+	}
+	if env.V > ProtocolVersion {
+		return env, NewProtocolError(ErrCodeUnsupportedVersion, "protocol version %d is not supported (max %d)", env.V, ProtocolVersion)
+	}
+	if len(env.Payload) == 0 {
+		env.Payload = json.RawMessage(message)
+	}
+	return env, nil
+}
+
+// dispatch routes one inbound frame to its registered handler and replies with
+// an ack or error frame as needed
+func (c *Client) dispatch(message []byte) {
+	env, err := parseEnvelope(message)
+	if err != nil {
+		c.sendError(env.ID, err)
+		return
+	}
+	handler := c.Hub.handler(env.Type)
+	if handler == nil {
+		c.sendError(env.ID, NewProtocolError(ErrCodeUnknownType, "unknown frame type %q", env.Type))
+		return
+	}
+	if activityFrames[env.Type] {
+		c.markActive()
+	}
+	result, err := handler(c, env)
+	if err != nil {
+		c.sendError(env.ID, err)
+		return
+	}
+	if env.Ack && env.ID != "" {
+		c.sendFrame(Envelope{V: ProtocolVersion, Type: TypeAck, ID: env.ID}, result)
+	}
+}
+
+// SendFrame sends a frame to this connection only; id correlates it with a client frame
+func (c *Client) SendFrame(msgType, id string, payload interface{}) {
+	c.sendFrame(Envelope{V: ProtocolVersion, Type: msgType, ID: id}, payload)
+}
+
+func (c *Client) sendError(id string, err error) {
+	perr, ok := err.(*ProtocolError)
+	if !ok {
+		perr = &ProtocolError{Code: ErrCodeInternal, Message: err.Error()}
+	}
+	c.sendFrame(Envelope{V: ProtocolVersion, Type: TypeError, ID: id}, perr)
+}
+
+func (c *Client) sendFrame(env Envelope, payload interface{}) {
+	if payload != nil {
+		raw, err := json.Marshal(payload)
+		if err != nil {
+			return
+		}
+		env.Payload = raw
+	}
+	data, _ := json.Marshal(env)
+	c.Hub.SendToClient(c, data)
+}
@@ -0,0 +1,132 @@
+package websocket
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// A connection may send at most typingRateLimit typing frames per
+// typingRateWindow; the rest are rejected with rate_limited
+const (
+	typingRateLimit  = 20
+	typingRateWindow = 10 * time.Second
+)
+
+// TypingEvent is the payload of the typing_start and typing_stop frames relayed
+// to peers. ConversationID is set for groups; in a direct chat UserID is the chat.
+type TypingEvent struct {
+	UserID         string     `json:"user_id"`
+	ConversationID *uuid.UUID `json:"conversation_id,omitempty"`
+}
+
+// typingState is one chat the connection is typing in
+type typingState struct {
+	peers []string
+	event TypingEvent
+	timer *time.Timer
+}
+
+// typingKey names the chat of a typing frame: the group, else the recipient
+func typingKey(payload TypingPayload) string {
+	if payload.ConversationID != nil {
+		return payload.ConversationID.String()
+	}
+	if payload.RecipientID != nil {
+		return payload.RecipientID.String()
+	}
+	return ""
+}
+
+// AllowTyping counts a typing frame against the connection's rate limit and
+// reports whether it may be handled
+func (c *Client) AllowTyping() bool {
+	c.typingMu.Lock()
+	defer c.typingMu.Unlock()
+	now := time.Now()
+	if now.Sub(c.typingWindow) >= typingRateWindow {
+		c.typingWindow = now
+		c.typingFrames = 0
+	}
+	c.typingFrames++
+	return c.typingFrames <= typingRateLimit
+}
+
+// StartTyping tells the chat's peers that the user is typing. peers is only
+// called when the connection was not typing in that chat yet; repeating
+// typing_start just extends the indicator by TypingTimeout, after which it is
+// stopped for the peers as if typing_stop had been sent.
+func (c *Client) StartTyping(payload TypingPayload, peers func() ([]string, error)) error {
+	key := typingKey(payload)
+	if key == "" {
+		return NewProtocolError(ErrCodeInvalidPayload, "recipient_id or conversation_id is required")
+	}
+	timeout := c.Hub.connConfig.TypingTimeout
+	c.typingMu.Lock()
+	if state, ok := c.typing[key]; ok {
+		state.timer.Reset(timeout)
+		c.typingMu.Unlock()
+		return nil
+	}
+	c.typingMu.Unlock()
+
+	ids, err := peers()
+	if err != nil {
+		return err
+	}
+	state := &typingState{peers: ids, event: TypingEvent{UserID: c.ID, ConversationID: payload.ConversationID}}
+	c.typingMu.Lock()
+	if _, ok := c.typing[key]; ok {
+		c.typingMu.Unlock()
+		return nil
+	}
+	if c.typing == nil {
+		c.typing = make(map[string]*typingState)
+	}
+	c.typing[key] = state
+	state.timer = time.AfterFunc(timeout, func() { c.expireTyping(key, state) })
+	c.typingMu.Unlock()
+	c.Hub.SendToUsers(state.peers, NewFrame("typing_start", state.event))
+	return nil
+}
+
+// StopTyping tells the chat's peers that the user stopped typing; it does
+// nothing when the connection was not typing there
+func (c *Client) StopTyping(payload TypingPayload) {
+	key := typingKey(payload)
+	c.typingMu.Lock()
+	state, ok := c.typing[key]
+	if ok {
+		state.timer.Stop()
+		delete(c.typing, key)
+	}
+	c.typingMu.Unlock()
+	if ok {
+		c.Hub.SendToUsers(state.peers, NewFrame("typing_stop", state.event))
+	}
+}
+
+// expireTyping stops an indicator the client stopped refreshing
+func (c *Client) expireTyping(key string, state *typingState) {
+	c.typingMu.Lock()
+	current := c.typing[key] == state
+	if current {
+		delete(c.typing, key)
+	}
+	c.typingMu.Unlock()
+	if current {
+		c.Hub.SendToUsers(state.peers, NewFrame("typing_stop", state.event))
+	}
+}
+
+// stopAllTyping clears every indicator of a connection that is going away
+func (c *Client) stopAllTyping() {
+	c.typingMu.Lock()
+	states := c.typing
+	c.typing = nil
+	c.typingMu.Unlock()
+	for _, state := range states {
+		state.timer.Stop()
+		c.Hub.SendToUsers(state.peers, NewFrame("typing_stop", state.event))
+	}
+}
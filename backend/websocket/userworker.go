@@ -0,0 +1,156 @@
+package websocket
+
+import (
+	"chatting-service-app/models"
+	"log"
+	"sync"
+)
+
+// userJobKind says what a userJob does with the user service
+type userJobKind int
+
+const (
+	// jobSetOnline and jobSetIdle persist presence; nothing is posted back
+	jobSetOnline userJobKind = iota
+	jobSetIdle
+	// jobAnnounceOnline loads the user for a user_online frame
+	jobAnnounceOnline
+	// jobAnnounceOffline loads the user and who may see their last_seen_at
+	// for a user_offline frame
+	jobAnnounceOffline
+	// jobRefreshStatus reloads the settings of a user who changed them
+	jobRefreshStatus
+)
+
+// userJob is a user service call the run loop hands to the user worker, so
+// that a slow database never holds up delivery
+type userJob struct {
+	kind   userJobKind
+	userID string
+	value  bool
+}
+
+// userLookup is what the worker loaded for a job, posted back to the run
+// loop. User is nil when the lookup failed; Audience is only set with LastSeen.
+type userLookup struct {
+	kind     userJobKind
+	userID   string
+	user     *models.User
+	lastSeen bool
+	everyone bool
+	audience []string
+}
+
+// jobQueue is an unbounded FIFO of jobs for a worker goroutine. Pushing never
+// blocks the run loop, and a single worker runs the jobs in order; for users
+// that means presence is written before it is read back to announce it.
+type jobQueue[T any] struct {
+	mu    sync.Mutex
+	jobs  []T
+	ready chan struct{}
+}
+
+func newJobQueue[T any]() *jobQueue[T] {
+	return &jobQueue[T]{ready: make(chan struct{}, 1)}
+}
+
+func (q *jobQueue[T]) push(job T) {
+	q.mu.Lock()
+	q.jobs = append(q.jobs, job)
+	q.mu.Unlock()
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+}
+
+func (q *jobQueue[T]) take() []T {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	jobs := q.jobs
+	q.jobs = nil
+	return jobs
+}
+
+// userWorker runs queued jobs and posts lookups back to the run loop
+func (h *Hub) userWorker() {
+	for range h.userJobs.ready {
+		for _, job := range h.userJobs.take() {
+			if lookup, ok := h.runUserJob(job); ok {
+				h.lookups <- lookup
+			}
+		}
+	}
+}
+
+// runUserJob calls the user service and reports whether the result goes back
+// to the run loop
+func (h *Hub) runUserJob(job userJob) (userLookup, bool) {
+	switch job.kind {
+	case jobSetOnline:
+		if err := h.userService.SetOnlineStatus(job.userID, job.value); err != nil {
+			log.Printf("hub: saving online status of %s: %v", job.userID, err)
+		}
+		return userLookup{}, false
+	case jobSetIdle:
+		if err := h.userService.SetIdle(job.userID, job.value); err != nil {
+			log.Printf("hub: saving idle state of %s: %v", job.userID, err)
+		}
+		return userLookup{}, false
+	}
+	lookup := userLookup{kind: job.kind, userID: job.userID}
+	user, err := h.userService.GetUserByID(job.userID)
+	if err != nil || user == nil {
+		return lookup, true
+	}
+	lookup.user = user
+	if job.kind == jobAnnounceOffline && user.LastSeenAt != nil {
+		everyone, userIDs, err := h.userService.LastSeenAudience(user)
+		if err == nil {
+			lookup.lastSeen = true
+			lookup.everyone = everyone
+			lookup.audience = userIDs
+		}
+	}
+	return lookup, true
+}
+
+// queueUserJob hands a job to the worker; without a user service there is
+// nothing to persist or load
+func (h *Hub) queueUserJob(job userJob) bool {
+	if h.userService == nil {
+		return false
+	}
+	h.userJobs.push(job)
+	return true
+}
+
+// applyLookup finishes an announcement once the worker loaded the user
+func (h *Hub) applyLookup(lookup userLookup) {
+	switch lookup.kind {
+	case jobAnnounceOnline:
+		// The user went offline meanwhile and that was announced after this
+		if !h.isOnline(lookup.userID) {
+			return
+		}
+		h.noteHidden(lookup.userID, lookup.user)
+		if !h.hidden[lookup.userID] {
+			h.sendUserOnline(lookup.userID, lookup.user)
+		}
+	case jobAnnounceOffline:
+		h.sendUserOffline(lookup)
+	case jobRefreshStatus:
+		if !h.isOnline(lookup.userID) || lookup.user == nil {
+			return
+		}
+		wasHidden := h.hidden[lookup.userID]
+		h.noteHidden(lookup.userID, lookup.user)
+		if h.hidden[lookup.userID] {
+			if !wasHidden {
+				h.broadcastUserOffline(lookup.userID)
+			}
+			return
+		}
+		h.sendUserOnline(lookup.userID, lookup.user)
+	}
+}
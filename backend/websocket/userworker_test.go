@@ -0,0 +1,124 @@
+package websocket
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"chatting-service-app/models"
+
+	"github.com/google/uuid"
+)
+
+// stalledUsers is a user service whose every call waits until release is
+// closed, like a database that stopped answering
+type stalledUsers struct {
+	release   chan struct{}
+	invisible map[string]bool
+}
+
+func (s *stalledUsers) SetOnlineStatus(userID string, isOnline bool) error {
+	<-s.release
+	return nil
+}
+
+func (s *stalledUsers) SetIdle(userID string, idle bool) error {
+	<-s.release
+	return nil
+}
+
+func (s *stalledUsers) GetUserByID(userID string) (*models.User, error) {
+	<-s.release
+	user := &models.User{ID: uuid.MustParse(userID), Username: "user-" + userID[:8], PresenceStatus: models.PresenceOnline}
+	if s.invisible[userID] {
+		user.PresenceStatus = models.PresenceInvisible
+	}
+	return user, nil
+}
+
+func (s *stalledUsers) LastSeenAudience(user *models.User) (bool, []string, error) {
+	<-s.release
+	return true, nil, nil
+}
+
+// nextFrame waits for the client's next frame of the given type, skipping others
+func nextFrame(t *testing.T, c *Client, msgType string) map[string]interface{} {
+	t.Helper()
+	timeout := time.After(2 * time.Second)
+	for {
+		select {
+		case data := <-c.Send:
+			var env Envelope
+			if err := json.Unmarshal(data, &env); err != nil {
+				t.Fatal(err)
+			}
+			if env.Type != msgType {
+				continue
+			}
+			var payload map[string]interface{}
+			if err := json.Unmarshal(env.Payload, &payload); err != nil {
+				t.Fatal(err)
+			}
+			return payload
+		case <-timeout:
+			t.Fatalf("no %s frame for %s", msgType, c.ID)
+			return nil
+		}
+	}
+}
+
+func TestHubDeliversWhileUserServiceStalls(t *testing.T) {
+	users := &stalledUsers{release: make(chan struct{})}
+	h := NewHub(users)
+	go h.Run()
+	alice, bob := uuid.NewString(), uuid.NewString()
+	a := h.NewClient(nil, alice, "session")
+	b := h.NewClient(nil, bob, "session")
+	h.Register(a)
+	h.Register(b)
+
+	h.SendDirect(bob, NewFrame("message", map[string]string{"content": "hi"}))
+	if got := nextFrame(t, b, "message"); got["content"] != "hi" {
+		t.Fatalf("got %v, want the message", got)
+	}
+	close(users.release)
+	// Once the database answers, the announcement follows with the details
+	got := nextFrame(t, a, "user_online")
+	if got["userId"] != bob {
+		got = nextFrame(t, a, "user_online")
+	}
+	if got["userId"] != bob || got["user"] == nil {
+		t.Fatalf("got %v, want bob announced with his details", got)
+	}
+}
+
+func TestHubHidesInvisibleUsersUntilLoaded(t *testing.T) {
+	ghost, viewer, carol := uuid.NewString(), uuid.NewString(), uuid.NewString()
+	users := &stalledUsers{release: make(chan struct{}), invisible: map[string]bool{ghost: true}}
+	h := NewHub(users)
+	go h.Run()
+	h.Register(h.NewClient(nil, ghost, "session"))
+	v := h.NewClient(nil, viewer, "session")
+	h.Register(v)
+
+	// Before the ghost's settings are loaded, they are not listed as online
+	list := nextFrame(t, v, "online_users")
+	for _, id := range list["userIds"].([]interface{}) {
+		if id == ghost {
+			t.Fatal("invisible user listed before their settings were loaded")
+		}
+	}
+	close(users.release)
+	// The worker runs jobs in order, so carol is announced after the ghost's
+	// lookup was applied
+	h.Register(h.NewClient(nil, carol, "session"))
+	for {
+		got := nextFrame(t, v, "user_online")
+		if got["userId"] == ghost {
+			t.Fatal("invisible user announced as online")
+		}
+		if got["userId"] == carol {
+			break
+		}
+	}
+}
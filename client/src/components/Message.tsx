@@ -1,7 +1,7 @@
-import React from 'react';
+import React, { useEffect, useState } from 'react';
 import { format } from 'date-fns';
 import { Check, CheckCheck, Download } from 'lucide-react';
-import { Message as MessageType } from '../types';
+import { AttachmentLink, Message as MessageType } from '../types';
 import { useChat } from '../contexts/ChatContext';
 
 interface MessageProps {
@@ -12,12 +12,29 @@ interface MessageProps {
 const Message: React.FC<MessageProps> = ({ message, isCurrentUser }) => {
   console.log(message)
   
-  const { downloadFile } = useChat();
-  
+  const { downloadFile, getAttachmentLink } = useChat();
+  const [attachment, setAttachment] = useState<AttachmentLink | null>(null);
+
+  // Media is private; fetch a signed link to preview it
+  useEffect(() => {
+    if (!message.media_url) {
+      setAttachment(null);
+      return;
+    }
+    let cancelled = false;
+    getAttachmentLink(message.media_url)
+      .then((link) => {
+        if (!cancelled) setAttachment(link);
+      })
+      .catch((error) => console.error('Error loading attachment:', error));
+    return () => {
+      cancelled = true;
+    };
+  }, [message.media_url]);
+
   const handleDownload = () => {
     if (message.media_url) {
-      const filename = message.media_url.split('/').pop() || 'file';
-      downloadFile(filename);
+      downloadFile(message.media_url);
     }
   };
 
@@ -35,27 +52,26 @@ const Message: React.FC<MessageProps> = ({ message, isCurrentUser }) => {
   
   // Extract filename from media URL if it exists
   const hasMedia = !!message.media_url;
-  const filename = hasMedia 
-    ? message.media_url?.split('/').pop() || 'file'
-    : '';
+  const filename = attachment?.file_name || (hasMedia ? 'file' : '');
 
   // Helper: check if content is a file placeholder
   const isFilePlaceholder = hasMedia && message.content && message.content.startsWith('Sent a file:');
   // Helper: check if file is an image
-  const isImage = hasMedia && /\.(jpg|jpeg|png|gif|bmp|webp)$/i.test(filename);
+  const isImage = hasMedia && !!attachment && attachment.content_type.startsWith('image/');
 
   return (
     <div className={`${containerClasses} mb-4`}>
       <div className="max-w-[80%] md:max-w-[70%]">
         <div className={`${messageClasses} px-4 py-2 shadow-sm`}>
           {/* Show text only if not a file placeholder, or if no media */}
+          {message.deleted_at && <span className="italic opacity-75">This message was deleted</span>}
           {!isFilePlaceholder && message.content}
 
           {/* If it's an image attachment, show preview */}
           {hasMedia && isImage && (
             <div className="mt-2 flex flex-col items-start">
               <img
-                src={message.media_url}
+                src={attachment?.url}
                 alt={filename}
                 className="max-h-48 max-w-full rounded mb-2 border"
                 style={{ objectFit: 'contain' }}
@@ -81,6 +97,18 @@ const Message: React.FC<MessageProps> = ({ message, isCurrentUser }) => {
             </div>
           )}
         </div>
+        {message.reactions && message.reactions.length > 0 && (
+          <div className={`${containerClasses} mt-1 gap-1`}>
+            {message.reactions.map((r) => (
+              <span
+                key={r.emoji}
+                className={`text-xs px-2 py-0.5 rounded-full border ${r.reacted ? 'bg-blue-50 border-blue-300' : 'bg-white'}`}
+              >
+                {r.emoji} {r.count}
+              </span>
+            ))}
+          </div>
+        )}
         <div className={`${timeClasses} text-xs mt-1 flex items-center`}>
           <span>
             {message.created_at && message.created_at !== '0001-01-01T00:00:00Z' ? (
@@ -89,6 +117,7 @@ const Message: React.FC<MessageProps> = ({ message, isCurrentUser }) => {
               ''
             )}
           </span>
+          {message.edited_at && <span className="ml-1">(edited)</span>}
           {isCurrentUser && (
             <span className="ml-1">
               {message.read ? (
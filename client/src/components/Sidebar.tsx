@@ -1,11 +1,12 @@
 import React, { useState, useEffect } from 'react';
 import { Search, X } from 'lucide-react';
 import Avatar from './Avatar';
-import { User } from '../types';
+import { ConversationSummary, User } from '../types';
 
 interface SidebarProps {
   onlineUsers: User[]; // always array, never null
   onlineUserIds?: string[]; // Add this prop
+  conversations?: ConversationSummary[]; // Unread counts and recency
   selectedUser: User | null;
   setSelectedUser: (user: User | null) => void;
   showMobile: boolean;
@@ -16,6 +17,7 @@ interface SidebarProps {
 const Sidebar: React.FC<SidebarProps> = ({
   onlineUsers = [], // default to []
   onlineUserIds = [], // Default to empty array
+  conversations = [],
   selectedUser,
   setSelectedUser,
   showMobile,
@@ -31,8 +33,16 @@ const Sidebar: React.FC<SidebarProps> = ({
       user.id !== currentUser?.id && // Filter out current user
       user.username.toLowerCase().includes(searchTerm.toLowerCase())
     );
-    setFilteredUsers(filtered);
-  }, [onlineUsers, searchTerm, currentUser]);
+    // Users we have chatted with come first, most recent on top
+    const rank = (user: User) => {
+      const i = conversations.findIndex(c => c.id === user.id);
+      return i < 0 ? conversations.length : i;
+    };
+    setFilteredUsers(filtered.sort((a, b) => rank(a) - rank(b)));
+  }, [onlineUsers, searchTerm, currentUser, conversations]);
+
+  const unreadCount = (userId: string) =>
+    conversations.find(c => c.id === userId)?.unread_count ?? 0;
 
   return (
     <>
@@ -102,6 +112,11 @@ const Sidebar: React.FC<SidebarProps> = ({
                       <p className="font-medium text-gray-900">{user.username}</p>
                       {/* Remove online/offline status text */}
                     </div>
+                    {unreadCount(user.id) > 0 && (
+                      <span className="ml-auto bg-blue-500 text-white text-xs font-semibold rounded-full px-2 py-0.5">
+                        {unreadCount(user.id)}
+                      </span>
+                    )}
                   </button>
                 </li>
               ))}
@@ -70,7 +70,8 @@ export const AuthProvider = ({ children }: { children: ReactNode }) => {
       const response = await api.post('/auth/login', { email, password });
             console.log(response);
 
-      const { token: newToken, user: userData } = response.data; // Expecting user info here
+      const { token: newToken, refresh_token: refreshToken, user: userData } = response.data; // Expecting user info here
+      sessionStorage.setItem('refresh_token', refreshToken);
       setToken(newToken);
       setUser(userData);
       toast.success('Login successful!');
@@ -88,7 +89,8 @@ export const AuthProvider = ({ children }: { children: ReactNode }) => {
     try {
       setIsLoading(true);
       const response = await api.post('/auth/signup', { username, email, password });
-      const { token: newToken, user: userData } = response.data; // Expecting user info here
+      const { token: newToken, refresh_token: refreshToken, user: userData } = response.data; // Expecting user info here
+      sessionStorage.setItem('refresh_token', refreshToken);
       setToken(newToken);
       setUser(userData);
       toast.success('Account created successfully!');
@@ -103,9 +105,14 @@ export const AuthProvider = ({ children }: { children: ReactNode }) => {
   };
 
   const logout = () => {
+    // Revoke the server-side session; the local state is cleared regardless
+    if (token) {
+      api.post('/auth/logout').catch(() => {});
+    }
     setToken(null);
     setUser(null);
     sessionStorage.removeItem('token');
+    sessionStorage.removeItem('refresh_token');
     disconnectWebSocket();
     navigate('/login');
     toast.success('Logged out successfully!');
@@ -8,18 +8,24 @@ import {
   MutableRefObject,
 } from "react";
 import { useAuth } from "./AuthContext";
-import { Message, User } from "../types";
-import api from "../services/api";
+import { AttachmentLink, ConversationSummary, Message, User } from "../types";
+import api, { API_URL } from "../services/api";
 import {
   setupWebSocket,
   disconnectWebSocket,
   sendWebSocketMessage,
+  sendTypingStart,
+  sendTypingStop,
+  sendActivity,
 } from "../services/websocket";
 
 interface ChatContextType {
   messages: Message[];
   onlineUsers: User[];
   onlineUserIds: string[]; // Add this for real-time presence
+  conversations: ConversationSummary[]; // Most recently active first
+  typingUserIds: string[]; // Peers currently typing to us in direct chats
+  setTyping: (recipientId: string, typing: boolean) => void;
   selectedUser: User | null;
   isLoadingMessages: boolean;
   setSelectedUser: (user: User | null) => void;
@@ -30,7 +36,8 @@ interface ChatContextType {
     isBroadcast?: boolean
   ) => Promise<void>;
   uploadFile: (file: File) => Promise<string>;
-  downloadFile: (filename: string) => Promise<void>;
+  getAttachmentLink: (mediaUrl: string) => Promise<AttachmentLink>;
+  downloadFile: (mediaUrl: string) => Promise<void>;
   markAsDelivered: (messageId: string, recipientId: string) => Promise<void>;
   markAsRead: (messageId: string, recipientId: string) => Promise<void>;
 }
@@ -47,6 +54,9 @@ export const useChat = () => {
 
 // Utility to convert a single message from PascalCase to camelCase
 function mapMessageFromApi(msg: any): Message {
+  const statuses: any[] = msg.Statuses ?? msg.statuses ?? [];
+  const allHave = (key: string) =>
+    statuses.length > 0 && statuses.every((s) => s[key]);
   return {
     id: msg.ID || msg.id || String(msg.id || msg.ID),
     sender_id:
@@ -62,8 +72,16 @@ function mapMessageFromApi(msg: any): Message {
       msg.CreatedAt ||
       msg.created_at ||
       (msg.timestamp ? msg.timestamp : new Date().toISOString()),
-    delivered: msg.Delivered ?? msg.delivered ?? false,
-    read: msg.Read ?? msg.read ?? false,
+    delivered:
+      msg.Delivered ?? msg.delivered ?? allHave("DeliveredAt"),
+    read: msg.Read ?? msg.read ?? allHave("ReadAt"),
+    edited_at: msg.EditedAt ?? msg.edited_at ?? undefined,
+    deleted_at: msg.DeletedAt ?? msg.deleted_at ?? undefined,
+    reactions: (msg.Reactions ?? msg.reactions ?? []).map((r: any) => ({
+      emoji: r.Emoji ?? r.emoji,
+      count: r.Count ?? r.count,
+      reacted: r.Reacted ?? r.reacted ?? false,
+    })),
   };
 }
 
@@ -76,6 +94,9 @@ export const ChatProvider = ({ children }: { children: ReactNode }) => {
   const { token, userId, isAuthenticated } = useAuth();
   const [messages, setMessages] = useState<Message[]>([]);
   const [onlineUsers, setOnlineUsers] = useState<User[]>([]);
+  const [conversations, setConversations] = useState<ConversationSummary[]>([]);
+  const [typingUserIds, setTypingUserIds] = useState<string[]>([]);
+  const lastTypingSentRef = useRef<number>(0);
   const [selectedUser, setSelectedUser] = useState<User | null>(null);
   const [isLoadingMessages, setIsLoadingMessages] = useState(false);
 
@@ -133,15 +154,109 @@ export const ChatProvider = ({ children }: { children: ReactNode }) => {
     );
   };
 
+  // "me" deletions vanish from this user's devices; "everyone" leaves a tombstone
+  const handleWebSocketMessageDeleted = (
+    messageId: string,
+    scope: "me" | "everyone"
+  ) => {
+    setMessages((prev) =>
+      scope === "me"
+        ? prev.filter((msg) => msg.id !== messageId)
+        : prev.map((msg) =>
+            msg.id === messageId
+              ? { ...msg, content: "", media_url: undefined, deleted_at: new Date().toISOString() }
+              : msg
+          )
+    );
+  };
+
+  // Replace the changed entry and keep the list sorted by last activity
+  const handleWebSocketConversationUpdated = (
+    conversation: ConversationSummary
+  ) => {
+    setConversations((prev) =>
+      [conversation, ...prev.filter((c) => c.id !== conversation.id)].sort(
+        (a, b) => b.last_activity_at.localeCompare(a.last_activity_at)
+      )
+    );
+  };
+
+  const handleWebSocketTyping = (
+    event: { user_id: string; conversation_id?: string },
+    typing: boolean
+  ) => {
+    if (event.conversation_id) return; // Groups are not shown yet
+    setTypingUserIds((prev) => {
+      const others = prev.filter((id) => id !== event.user_id);
+      return typing ? [...others, event.user_id] : others;
+    });
+  };
+
+  // Sends typing_start at most every 3 seconds while the user types
+  const setTyping = (recipientId: string, typing: boolean) => {
+    if (!typing) {
+      lastTypingSentRef.current = 0;
+      sendTypingStop(recipientId);
+      return;
+    }
+    const now = Date.now();
+    if (now - lastTypingSentRef.current >= 3000) {
+      lastTypingSentRef.current = now;
+      sendTypingStart(recipientId);
+    }
+  };
+
+  const handleWebSocketReaction = (
+    event: { message_id: string; user_id: string; emoji: string },
+    added: boolean
+  ) => {
+    const mine = event.user_id === userIdRef.current;
+    setMessages((prev) =>
+      prev.map((msg) => {
+        if (msg.id !== event.message_id) return msg;
+        const reactions = [...(msg.reactions ?? [])];
+        const i = reactions.findIndex((r) => r.emoji === event.emoji);
+        if (added) {
+          if (i < 0) {
+            reactions.push({ emoji: event.emoji, count: 1, reacted: mine });
+          } else {
+            reactions[i] = {
+              ...reactions[i],
+              count: reactions[i].count + 1,
+              reacted: reactions[i].reacted || mine,
+            };
+          }
+        } else if (i >= 0) {
+          const count = reactions[i].count - 1;
+          if (count <= 0) {
+            reactions.splice(i, 1);
+          } else {
+            reactions[i] = {
+              ...reactions[i],
+              count,
+              reacted: mine ? false : reactions[i].reacted,
+            };
+          }
+        }
+        return { ...msg, reactions };
+      })
+    );
+  };
+
   useEffect(() => {
     if (isAuthenticated && token) {
       setupWebSocket(
         token,
         handleWebSocketMessage,
         handleWebSocketConnection,
-        handleWebSocketMessageStatusUpdate
+        handleWebSocketMessageStatusUpdate,
+        handleWebSocketMessageDeleted,
+        handleWebSocketReaction,
+        handleWebSocketConversationUpdated,
+        handleWebSocketTyping
       );
       fetchOnlineUsers();
+      fetchConversations();
 
       return () => {
         disconnectWebSocket();
@@ -149,6 +264,14 @@ export const ChatProvider = ({ children }: { children: ReactNode }) => {
     }
   }, [isAuthenticated, token]);
 
+  // Report the tab being hidden or shown so others see us as away
+  useEffect(() => {
+    if (!isAuthenticated) return;
+    const onVisibility = () => sendActivity(document.hidden);
+    document.addEventListener("visibilitychange", onVisibility);
+    return () => document.removeEventListener("visibilitychange", onVisibility);
+  }, [isAuthenticated]);
+
   useEffect(() => {
     if (selectedUser && userId) {
       fetchMessages(userId, selectedUser.id);
@@ -164,13 +287,23 @@ export const ChatProvider = ({ children }: { children: ReactNode }) => {
     }
   };
 
+  const fetchConversations = async () => {
+    try {
+      const response = await api.get("/conversations");
+      setConversations(response.data.conversations ?? []);
+    } catch (error) {
+      console.error("Error fetching conversations:", error);
+    }
+  };
+
   const fetchMessages = async (user1Id: string, user2Id: string) => {
     try {
       setIsLoadingMessages(true);
       const response = await api.get(
         `/messages?user1=${user1Id}&user2=${user2Id}`
       );
-      setMessages(mapMessagesFromApi(response.data));
+      // History is paginated: this loads the most recent page
+      setMessages(mapMessagesFromApi(response.data.messages ?? []));
     } catch (error) {
       console.error("Error fetching messages:", error);
     } finally {
@@ -192,11 +325,19 @@ export const ChatProvider = ({ children }: { children: ReactNode }) => {
       currentUserId
     );
     // Allow messages with content (even if no id) for real-time recipient display
-    if (!mapped.content) {
+    if (!mapped.content && !mapped.deleted_at) {
       console.warn("Received message with no content:", message);
       return;
     }
     setMessages((prev) => {
+      // Replays and edits carry a known ID: update that message in place
+      if (mapped.id && prev.some((m) => m.id === mapped.id)) {
+        return prev.map((m) =>
+          m.id === mapped.id
+            ? { ...m, content: mapped.content, edited_at: mapped.edited_at }
+            : m
+        );
+      }
       if (
         currentSelectedUser &&
         ((mapped.sender_id === currentSelectedUser.id &&
@@ -224,15 +365,19 @@ export const ChatProvider = ({ children }: { children: ReactNode }) => {
     // Handle all presence events in one place
     if (type === "user_online" && data.user) {
       // Add user to onlineUsers if not present (avoid duplicates and self)
+      // user_online is re-sent on status changes, so replace existing entries
+      const user = {
+        ...data.user,
+        status: data.status,
+        custom_status: data.custom_status,
+      };
       setOnlineUsers((prevRaw) => {
         const prev = Array.isArray(prevRaw) ? prevRaw : [];
-        if (
-          !prev.some((u) => u.id === data.user.id) &&
-          data.user.id !== userId
-        ) {
-          return [...prev, data.user];
+        if (user.id === userId) return prev;
+        if (prev.some((u) => u.id === user.id)) {
+          return prev.map((u) => (u.id === user.id ? user : u));
         }
-        return prev;
+        return [...prev, user];
       });
       setOnlineUserIds((prev) =>
         prev.includes(data.user.id) ? prev : [...prev, data.user.id]
@@ -262,14 +407,15 @@ export const ChatProvider = ({ children }: { children: ReactNode }) => {
         is_broadcast: isBroadcast,
       });
 
-      // Convert to snake_case for backend
+      // Convert to snake_case for backend. client_msg_id is an idempotency key,
+      // so a resend over either transport never stores the message twice.
       const newMessageApi = {
         sender_id: userId,
         recipient_id: recipientId,
         content,
         media_url: mediaUrl,
         is_broadcast: isBroadcast,
-        created_at: new Date().toISOString(), // Always send created_at
+        client_msg_id: crypto.randomUUID(),
       };
 
       // Optimistically add the message for the sender
@@ -281,21 +427,28 @@ export const ChatProvider = ({ children }: { children: ReactNode }) => {
         content,
         media_url: mediaUrl,
         is_broadcast: isBroadcast,
-        created_at: newMessageApi.created_at, // Use the same timestamp
+        // Shown until the ack brings the server's timestamp
+        created_at: new Date().toISOString(),
         delivered: false,
         read: false,
       };
       setMessages((prev) => [...prev, optimisticMessage]);
 
-      sendWebSocketMessage(newMessageApi);
+      // Swap the optimistic entry for the server-assigned id and timestamp
+      const confirm = (ack: { id: string; created_at: string }) => {
+        setMessages((prev) =>
+          prev.map((m) =>
+            m.id === optimisticId
+              ? { ...m, id: ack.id, created_at: ack.created_at }
+              : m
+          )
+        );
+      };
 
-      const response = await api.post("/messages", newMessageApi);
-      const mapped = mapMessageFromApi(response.data);
-      if (mapped.id && mapped.content) {
-        setMessages((prev) => [
-          ...prev.filter((m) => m.id !== optimisticId),
-          mapped,
-        ]);
+      // The socket persists the message when connected; REST is the fallback
+      if (!sendWebSocketMessage(newMessageApi, confirm)) {
+        const response = await api.post("/messages", newMessageApi);
+        confirm(response.data);
       }
 
       // return response.data;
@@ -321,16 +474,25 @@ export const ChatProvider = ({ children }: { children: ReactNode }) => {
     }
   };
 
-  const downloadFile = async (filename: string) => {
+  // Exchange a media_url for a signed URL that works without the auth header
+  const getAttachmentLink = async (mediaUrl: string): Promise<AttachmentLink> => {
+    const response = await api.get(mediaUrl);
+    const link: AttachmentLink = response.data;
+    return { ...link, url: `${API_URL}${link.url}` };
+  };
+
+  const downloadFile = async (mediaUrl: string) => {
     try {
-      const response = await api.get(`/download?file=${filename}`, {
+      const attachment = await getAttachmentLink(mediaUrl);
+      const response = await api.get(attachment.url, {
+        baseURL: "",
         responseType: "blob",
       });
 
       const url = window.URL.createObjectURL(new Blob([response.data]));
       const link = document.createElement("a");
       link.href = url;
-      link.setAttribute("download", filename);
+      link.setAttribute("download", attachment.file_name);
       document.body.appendChild(link);
       link.click();
       link.parentNode?.removeChild(link);
@@ -375,11 +537,15 @@ export const ChatProvider = ({ children }: { children: ReactNode }) => {
         messages,
         onlineUsers: onlineUsers || [], // always array
         onlineUserIds,
+        conversations,
+        typingUserIds,
+        setTyping,
         selectedUser,
         isLoadingMessages,
         setSelectedUser,
         sendMessage,
         uploadFile,
+        getAttachmentLink,
         downloadFile,
         markAsDelivered,
         markAsRead,
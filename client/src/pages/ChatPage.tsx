@@ -6,7 +6,7 @@ import Sidebar from '../components/Sidebar';
 import Message from '../components/Message';
 import Button from '../components/Button';
 import Avatar from '../components/Avatar';
-import { Message as MessageType } from '../types';
+import { Message as MessageType, User } from '../types';
 
 const ChatPage = () => {
   const { user, logout } = useAuth();
@@ -14,6 +14,9 @@ const ChatPage = () => {
     messages, 
     onlineUsers, 
     onlineUserIds, // Get onlineUserIds for real-time presence
+    conversations,
+    typingUserIds,
+    setTyping,
     selectedUser, 
     setSelectedUser, 
     sendMessage,
@@ -125,6 +128,7 @@ const ChatPage = () => {
       <Sidebar
         onlineUsers={onlineUsers}
         onlineUserIds={onlineUserIds} // Pass onlineUserIds for real-time presence
+        conversations={conversations}
         selectedUser={selectedUser}
         setSelectedUser={setSelectedUser}
         showMobile={showMobileSidebar}
@@ -147,7 +151,9 @@ const ChatPage = () => {
                 <div className="ml-3">
                   <h2 className="font-semibold text-gray-800">{selectedUser.username}</h2>
                   <p className="text-xs text-gray-500">
-                    {onlineUsers.some(u => u.id === selectedUser.id) ? 'Online' : 'Offline'}
+                    {typingUserIds.includes(selectedUser.id)
+                      ? 'typing…'
+                      : presenceLabel(onlineUsers.find(u => u.id === selectedUser.id))}
                   </p>
                 </div>
               </>
@@ -217,7 +223,10 @@ const ChatPage = () => {
             <input
               type="text"
               value={messageText}
-              onChange={(e) => setMessageText(e.target.value)}
+              onChange={(e) => {
+                setMessageText(e.target.value);
+                if (selectedUser) setTyping(selectedUser.id, e.target.value !== '');
+              }}
               placeholder="Type a message..."
               className="flex-1 border rounded-full py-2 px-4 focus:outline-none focus:ring-2 focus:ring-blue-200"
               disabled={isSending}
@@ -260,6 +269,15 @@ const ChatPage = () => {
   );
 };
 
+const statusLabels = { online: 'Online', away: 'Away', dnd: 'Do not disturb', offline: 'Offline' };
+
+// Header text for a peer: their status, then their custom status if any
+const presenceLabel = (user?: User) => {
+  if (!user) return 'Offline';
+  const label = statusLabels[user.status ?? 'online'];
+  return user.custom_status ? `${label} · ${user.custom_status}` : label;
+};
+
 const MessageCircle = ({ className }: { className?: string }) => (
   <svg 
     className={className}
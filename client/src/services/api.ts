@@ -1,7 +1,8 @@
 import axios from 'axios';
+import { sendAuthToken } from './websocket';
 
 // Use VITE_API_URL from environment or default to '/api' for Docker/Nginx
-const API_URL = import.meta.env.VITE_API_URL || '/api';
+export const API_URL = import.meta.env.VITE_API_URL || '/api';
 
 const api = axios.create({
   baseURL: API_URL,
@@ -22,13 +23,57 @@ api.interceptors.request.use(
   (error) => Promise.reject(error)
 );
 
+// Exchange the stored refresh token for a new pair; concurrent 401s share one request
+let refreshPromise: Promise<string | null> | null = null;
+
+const refreshAccessToken = (): Promise<string | null> => {
+  const refreshToken = sessionStorage.getItem('refresh_token');
+  if (!refreshToken) {
+    return Promise.resolve(null);
+  }
+  if (!refreshPromise) {
+    refreshPromise = axios
+      .post(`${API_URL}/auth/refresh`, { refresh_token: refreshToken })
+      .then((response) => {
+        const { token, refresh_token: newRefreshToken } = response.data;
+        sessionStorage.setItem('token', token);
+        sessionStorage.setItem('refresh_token', newRefreshToken);
+        // Keep the open WebSocket authenticated without reconnecting
+        sendAuthToken(token);
+        return token as string;
+      })
+      .catch(() => null)
+      .finally(() => {
+        refreshPromise = null;
+      });
+  }
+  return refreshPromise;
+};
+
 // Response interceptor to handle errors
 api.interceptors.response.use(
   (response) => response,
-  (error) => {
+  async (error) => {
+    const original = error.config;
+    // On 401, try one token refresh before giving up
+    if (
+      error.response &&
+      error.response.status === 401 &&
+      original &&
+      !original._retried &&
+      !['/auth/login', '/auth/signup', '/auth/refresh', '/auth/logout'].includes(String(original.url))
+    ) {
+      original._retried = true;
+      const token = await refreshAccessToken();
+      if (token) {
+        original.headers.Authorization = `Bearer ${token}`;
+        return api(original);
+      }
+    }
     // Handle 401 (Unauthorized) errors by redirecting to login
     if (error.response && error.response.status === 401) {
       sessionStorage.removeItem('token');
+      sessionStorage.removeItem('refresh_token');
       window.location.href = '/login';
     }
     return Promise.reject(error);
@@ -6,11 +6,19 @@ let currentToken: string | null = null;
 type MessageHandler = (message: any) => void;
 type ConnectionHandler = (data: any, type: string) => void;
 type MessageStatusUpdateHandler = (messageId: string, status: "delivered" | "read") => void;
+type MessageDeletedHandler = (messageId: string, scope: "me" | "everyone") => void;
+type ReactionHandler = (event: { message_id: string; user_id: string; emoji: string }, added: boolean) => void;
+type ConversationUpdatedHandler = (conversation: any) => void;
+type TypingHandler = (event: { user_id: string; conversation_id?: string }, typing: boolean) => void;
 
 // Store handlers (kept for potential reconnection logic, but won't be actively used for messages)
 let messageHandler: MessageHandler | null = null;
 let connectionHandler: ConnectionHandler | null = null;
 let messageStatusUpdateHandler: MessageStatusUpdateHandler | null = null;
+let messageDeletedHandler: MessageDeletedHandler | null = null;
+let reactionHandler: ReactionHandler | null = null;
+let conversationUpdatedHandler: ConversationUpdatedHandler | null = null;
+let typingHandler: TypingHandler | null = null;
 
 /**
  * Set up WebSocket connection
@@ -19,12 +27,20 @@ export const setupWebSocket = (
   token: string,
   onMessage: MessageHandler, // This handler will not be called in this modified version
   onConnection: ConnectionHandler, // This handler will not be called in this modified version
-  onMessageStatusUpdate: MessageStatusUpdateHandler // New handler for status updates
+  onMessageStatusUpdate: MessageStatusUpdateHandler, // New handler for status updates
+  onMessageDeleted?: MessageDeletedHandler,
+  onReaction?: ReactionHandler,
+  onConversationUpdated?: ConversationUpdatedHandler,
+  onTyping?: TypingHandler
 ) => {
   // Store handlers for reconnection (kept for the reconnect logic in onclose)
   messageHandler = onMessage;
   connectionHandler = onConnection;
   messageStatusUpdateHandler = onMessageStatusUpdate;
+  messageDeletedHandler = onMessageDeleted ?? null;
+  reactionHandler = onReaction ?? null;
+  conversationUpdatedHandler = onConversationUpdated ?? null;
+  typingHandler = onTyping ?? null;
   currentToken = token;
 
   // Close existing connection if any
@@ -60,41 +76,72 @@ export const setupWebSocket = (
 
   socket.onmessage = (event) => {
     try {
-      const data = JSON.parse(event.data);
-
-      // Accept both wrapped and raw message formats
-      if (data.type === "message" && data.payload) {
-        onMessage(data.payload);
-      } else if (data.type === undefined && data.Content && data.SenderID && data.RecipientID) {
-        // Backend sent a raw message object (PascalCase)
-        onMessage(data);
-      } else if (data.type === undefined && data.content && data.sender_id && data.recipient_id) {
-        // Backend sent a raw message object (camelCase)
-        onMessage(data);
-      } else if (typeof data.type === "string") {
-        // Only call connectionHandler for known presence types
-        switch (data.type) {
-          case "delivered":
-            if (data.message_id) {
-              updateMessageStatus(data.message_id, "delivered");
-            }
-            break;
-          case "read":
-            if (data.message_id) {
-              updateMessageStatus(data.message_id, "read");
-            }
-            break;
-          case "online_users":
-          case "user_online":
-          case "user_offline":
-            if (connectionHandler) connectionHandler(data, data.type);
-            break;
-          case "error":
-            console.error("WebSocket error:", data.payload);
-            break;
-          default:
-            console.log("Unknown message type:", data);
-        }
+      // Every server frame is an envelope: { v, type, id?, payload? }
+      const frame = JSON.parse(event.data);
+      const payload = frame.payload ?? {};
+
+      switch (frame.type) {
+        case "message":
+        case "message_edited":
+          onMessage(payload);
+          break;
+        case "delivered":
+        case "read":
+          if (payload.message_id) {
+            updateMessageStatus(payload.message_id, frame.type);
+          }
+          break;
+        case "message_status":
+          // A recipient received or read messages we sent
+          for (const id of payload.message_ids ?? []) {
+            updateMessageStatus(id, payload.status);
+          }
+          break;
+        case "message_deleted":
+          if (payload.message_id && messageDeletedHandler) {
+            messageDeletedHandler(payload.message_id, payload.scope);
+          }
+          break;
+        case "reaction_added":
+        case "reaction_removed":
+          if (payload.message_id && reactionHandler) {
+            reactionHandler(payload, frame.type === "reaction_added");
+          }
+          break;
+        case "read_state":
+          // Read on another of our devices
+          for (const id of payload.message_ids ?? [payload.message_id]) {
+            if (id) updateMessageStatus(id, "read");
+          }
+          break;
+        case "conversation_updated":
+          if (payload.id && conversationUpdatedHandler) {
+            conversationUpdatedHandler(payload);
+          }
+          break;
+        case "typing_start":
+        case "typing_stop":
+          if (payload.user_id && typingHandler) {
+            typingHandler(payload, frame.type === "typing_start");
+          }
+          break;
+        case "online_users":
+        case "user_online":
+        case "user_offline":
+          if (connectionHandler) connectionHandler(payload, frame.type);
+          break;
+        case "ack":
+          if (frame.id && pendingAcks.has(frame.id)) {
+            pendingAcks.get(frame.id)!(payload);
+            pendingAcks.delete(frame.id);
+          }
+          break;
+        case "error":
+          if (frame.id) pendingAcks.delete(frame.id);
+          console.error("WebSocket error:", payload.code, payload.message);
+          break;
+        default:
+          console.log("Unknown message type:", frame);
       }
     } catch (error) {
       console.error("Error parsing WebSocket message:", error);
@@ -119,53 +166,76 @@ export const setupWebSocket = (
   return socket;
 };
 
+// Callbacks waiting for the ack of a frame, keyed by frame id
+const pendingAcks = new Map<string, (payload: any) => void>();
+let frameCounter = 0;
+
 /**
- * Send a message through WebSocket
+ * Send a typed frame to the server, optionally asking for an ack
  */
-export const sendWebSocketMessage = (message: any) => {
+const sendFrame = (type: string, payload?: any, onAck?: (payload: any) => void) => {
   if (socket && socket.readyState === WebSocket.OPEN) {
-    socket.send(
-      JSON.stringify({
-        type: "message",
-        payload: message,
-      })
-    );
+    if (onAck) {
+      const id = `c-${++frameCounter}`;
+      pendingAcks.set(id, onAck);
+      socket.send(JSON.stringify({ v: 1, type, id, ack: true, payload }));
+    } else {
+      socket.send(JSON.stringify({ v: 1, type, payload }));
+    }
     return true;
   }
   return false;
 };
 
 /**
- * Send delivered status
+ * Send a message through WebSocket; onAck receives the server-assigned id and created_at
  */
-export const sendDeliveredStatus = (messageId: string) => {
-  if (socket && socket.readyState === WebSocket.OPEN) {
-    socket.send(
-      JSON.stringify({
-        type: "delivered",
-        message_id: messageId,
-      })
-    );
-    return true;
-  }
-  return false;
+export const sendWebSocketMessage = (message: any, onAck?: (ack: any) => void) =>
+  sendFrame("message", message, onAck);
+
+/**
+ * Hand a refreshed access token to the server so the connection stays authenticated
+ */
+export const sendAuthToken = (token: string) => {
+  currentToken = token;
+  return sendFrame("auth", { token });
 };
 
+/**
+ * Send delivered status
+ */
+export const sendDeliveredStatus = (messageId: string) =>
+  sendFrame("delivered", { message_id: messageId });
+
 /**
  * Send read status
  */
-export const sendReadStatus = (messageId: string) => {
-  if (socket && socket.readyState === WebSocket.OPEN) {
-    socket.send(
-      JSON.stringify({
-        type: "read",
-        message_id: messageId,
-      })
-    );
-    return true;
-  }
-  return false;
-};
+export const sendReadStatus = (messageId: string) =>
+  sendFrame("read", { message_id: messageId });
+
+/**
+ * Mark the whole conversation read up to and including a message
+ */
+export const sendReadUpTo = (messageId: string) =>
+  sendFrame("read_up_to", { message_id: messageId });
+
+/**
+ * Tell the recipient we are typing; repeat while typing, the server expires it
+ */
+export const sendTypingStart = (recipientId: string) =>
+  sendFrame("typing_start", { recipient_id: recipientId });
+
+/**
+ * Tell the recipient we stopped typing
+ */
+export const sendTypingStop = (recipientId: string) =>
+  sendFrame("typing_stop", { recipient_id: recipientId });
+
+/**
+ * Tell the server whether the user is active on this device
+ */
+export const sendActivity = (idle: boolean) =>
+  sendFrame("activity", idle ? { idle: true } : {});
 
 /**
  * Update message status in local state
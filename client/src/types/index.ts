@@ -2,6 +2,9 @@ export interface User {
   id: string;
   username: string;
   email: string;
+  status?: 'online' | 'away' | 'dnd' | 'offline';
+  custom_status?: string;
+  last_seen_at?: string;
 }
 
 export interface Message {
@@ -14,4 +17,32 @@ export interface Message {
   created_at: string;
   delivered: boolean;
   read: boolean;
+  edited_at?: string;
+  deleted_at?: string;
+  reactions?: Reaction[];
+}
+
+// One entry of GET /conversations; id is the peer for direct chats
+export interface ConversationSummary {
+  type: "direct" | "group";
+  id: string;
+  name: string;
+  last_message?: any;
+  last_activity_at: string;
+  unread_count: number;
+}
+
+// Short-lived download link for a message's media_url
+export interface AttachmentLink {
+  url: string;
+  expires_at: string;
+  file_name: string;
+  content_type: string;
+  size: number;
+}
+
+export interface Reaction {
+  emoji: string;
+  count: number;
+  reacted: boolean;
 }
\ No newline at end of file